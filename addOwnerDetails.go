@@ -2,6 +2,7 @@ package main
 
 import (
 	"auth-microservice/config"
+	"auth-microservice/jwt"
 	"auth-microservice/model"
 	userpb "auth-microservice/proto/user"
 	"context"
@@ -20,27 +21,17 @@ func (*UserService) AddOwnerDetails(ctx context.Context, request *userpb.AddOwne
 		zap.String("adharNumber", request.AdharNumber),
 		zap.String("gstNumber", request.GstNumber))
 
-	userEmail, emailCtxError := ctx.Value("userEmail").(string)
-	userRole, roleCtxError := ctx.Value("userRole").(string)
-
-	if !emailCtxError || !roleCtxError {
-		logger.Error("Failed to get user email and role from context")
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
 		return &userpb.AddOwnerDetailsResponse{
-			Message:    "Failed to get user email and role from context",
+			Message:    "Failed to get authenticated user from context",
 			Error:      "Internal Server Error",
 			StatusCode: StatusInternalServerError,
 		}, nil
 	}
-	logger.Info("Context values retrieved", zap.String("userEmail", userEmail), zap.String("userRole", userRole))
-	if userRole != model.AdminRole {
-		logger.Warn("Permission denied", zap.String("userRole", userRole))
-		return &userpb.AddOwnerDetailsResponse{
-			Data:       nil,
-			Message:    "You do not have permission to perform this action. Only admin can add owner details",
-			StatusCode: StatusForbidden,
-			Error:      "Forbidden",
-		}, nil
-	}
+	userEmail := principal.Email
+	logger.Info("Context values retrieved", zap.String("userEmail", userEmail), zap.String("userRole", principal.Role))
 	var ownerDetails model.Details
 	ownerDetails.AccountNumber = request.AccountNumber
 	ownerDetails.BankName = request.BankName
@@ -63,7 +54,15 @@ func (*UserService) AddOwnerDetails(ctx context.Context, request *userpb.AddOwne
 	}
 	// check if the user is owner or not
 	var user model.User
-	userDbConnector.Where("email = ?", userEmail).First(&user)
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.AddOwnerDetailsResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
 	ownerDetails.UserId = strconv.FormatUint(uint64(user.ID), 10)
 
 	// check if the owner details already exists
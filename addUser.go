@@ -6,7 +6,6 @@ import (
 	userpb "auth-microservice/proto/user"
 	"context"
 	"fmt"
-	"strconv"
 
 	"go.uber.org/zap"
 	"gorm.io/gorm"
@@ -45,13 +44,44 @@ func (userServiceManager *UserService) AddUser(ctx context.Context, request *use
 			StatusCode: int64(StatusBadRequest),
 		}, nil
 	}
+	violations, err := userServiceManager.validateNewPassword(userPassword,
+		&model.User{Name: userName, Email: userEmail, Phone: userPhone})
+	if err != nil {
+		logger.Error("Failed to check password policy", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.AddUserResponse{
+			Data:       nil,
+			Message:    "Failed to create user, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: int64(StatusInternalServerError),
+		}, nil
+	}
+	if len(violations) > 0 {
+		logger.Warn("Password violates the password policy", zap.String("userEmail", userEmail))
+		return &userpb.AddUserResponse{
+			Data:       nil,
+			Message:    weakPasswordMessage,
+			Error:      "Weak Password",
+			StatusCode: int64(StatusBadRequest),
+			Violations: violations,
+		}, nil
+	}
 	var existingUser model.User
 	userNotFoundError := userDbConnector.Where("email = ?", userEmail).First(&existingUser).Error
 	// If the user is not found, create a new user
 	if userNotFoundError == gorm.ErrRecordNotFound {
-		hashedPassword := config.GenerateHashedPassword(userPassword)
+		hashedPassword, err := userServiceManager.passwordHashers.Hash(userPassword)
+		if err != nil {
+			logger.Error("Failed to hash password", zap.String("userEmail", userEmail), zap.Error(err))
+			return &userpb.AddUserResponse{
+				Data:       nil,
+				Message:    "Failed to create user, Please try again later.",
+				Error:      "Internal Server Error",
+				StatusCode: int64(StatusInternalServerError),
+			}, nil
+		}
 		newUser := &model.User{Name: userName, Email: userEmail,
-			Phone: userPhone, Password: hashedPassword, Role: userRole}
+			Phone: userPhone, Password: hashedPassword, Role: userRole,
+			EmailVerificationPending: true}
 
 		// Create a new user in the database and return the primary key if successful or an error if it fails
 		primaryKey := userDbConnector.Create(newUser)
@@ -64,10 +94,10 @@ func (userServiceManager *UserService) AddUser(ctx context.Context, request *use
 				StatusCode: int64(StatusConflict),
 			}, nil
 		}
-		// Gennerating the the jwt token.
-		token, err := userServiceManager.jwtManager.GenerateToken(newUser)
+		// Generating the jwt token and the refresh token.
+		data, err := userServiceManager.issueTokens(ctx, newUser, "")
 		if err != nil {
-			logger.Error("Error in generating token")
+			logger.Error("Error in generating token", zap.Error(err))
 			return &userpb.AddUserResponse{
 				Data:       nil,
 				Error:      "Internal Server Error",
@@ -75,19 +105,15 @@ func (userServiceManager *UserService) AddUser(ctx context.Context, request *use
 				Message:    "Security Issues, Please try again later.",
 			}, nil
 		}
+		// The account can be used right away, but only for a few RPCs until verified
+		if err := userServiceManager.sendVerificationEmail(ctx, newUser); err != nil {
+			logger.Error("Failed to send verification email", zap.String("userEmail", newUser.Email), zap.Error(err))
+		}
 		logger.Info(fmt.Sprintf("User %s created successfully", newUser.Name))
 		return &userpb.AddUserResponse{
 			Message: "User created successfully",
 			Error:   "", StatusCode: int64(StatusOK),
-			Data: &userpb.Responsedata{
-				User: &userpb.User{
-					UserId:    strconv.FormatUint(uint64(newUser.ID), 10),
-					UserName:  newUser.Name,
-					UserEmail: newUser.Email,
-					UserPhone: newUser.Phone,
-				},
-				Token: token,
-			},
+			Data:    data,
 		}, nil
 	} 
 	logger.Warn("User email already registered", zap.String("userEmail", userEmail))
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+)
+
+// maxApiKeyDays is the longest an API key can be valid for, zero days
+// creates a key that does not expire.
+const maxApiKeyDays = 365
+
+// gatewayIncomingHeader forwards the X-Api-Key header to the interceptor,
+// other headers are matched like the gateway does by default.
+func gatewayIncomingHeader(key string) (string, bool) {
+	if strings.EqualFold(key, jwt.ApiKeyHeader) {
+		return jwt.ApiKeyHeader, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func userpbApiKey(apiKey *model.ApiKey) *userpb.ApiKey {
+	return &userpb.ApiKey{
+		ApiKeyId:   strconv.FormatUint(uint64(apiKey.ID), 10),
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Scopes:     strings.Fields(apiKey.Scopes),
+		CreatedAt:  apiKey.CreatedAt.UTC().Format(time.RFC3339),
+		ExpiresAt:  formatOptionalTime(apiKey.ExpiresAt),
+		LastUsedAt: formatOptionalTime(apiKey.LastUsedAt),
+	}
+}
+
+func (*UserService) CreateApiKey(ctx context.Context, request *userpb.CreateApiKeyRequest) (*userpb.CreateApiKeyResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.CreateApiKeyResponse{
+			Data:       nil,
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received CreateApiKey request", zap.String("userEmail", userEmail), zap.String("keyName", request.Name))
+	if request.Name == "" || request.ExpiresInDays < 0 || request.ExpiresInDays > maxApiKeyDays {
+		logger.Warn("Invalid request fields", zap.String("userEmail", userEmail))
+		return &userpb.CreateApiKeyResponse{
+			Data:       nil,
+			Message:    "The request contains missing or invalid fields. Keys expire after at most 365 days.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.CreateApiKeyResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	// a key can only carry permissions the user has
+	for _, scope := range request.Scopes {
+		if !principal.HasScope(scope) {
+			logger.Warn("Scope not granted to user", zap.String("userEmail", userEmail), zap.String("scope", scope))
+			return &userpb.CreateApiKeyResponse{
+				Data:       nil,
+				Message:    "You do not have the " + scope + " permission",
+				Error:      "Forbidden",
+				StatusCode: StatusForbidden,
+			}, nil
+		}
+	}
+	key, prefix, keyHash, err := jwt.GenerateApiKey()
+	apiKey := &model.ApiKey{
+		UserID:  user.ID,
+		KeyHash: keyHash,
+		Prefix:  prefix,
+		Name:    request.Name,
+		Scopes:  strings.Join(request.Scopes, " "),
+	}
+	if request.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, int(request.ExpiresInDays))
+		apiKey.ExpiresAt = &expiresAt
+	}
+	if err == nil {
+		err = userDbConnector.Create(apiKey).Error
+	}
+	if err != nil {
+		logger.Error("Failed to create api key", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.CreateApiKeyResponse{
+			Data:       nil,
+			Message:    "Failed to create the api key, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Api key created", zap.String("userEmail", userEmail), zap.String("prefix", prefix))
+	return &userpb.CreateApiKeyResponse{
+		Data:       &userpb.CreateApiKeyResponseData{ApiKey: userpbApiKey(apiKey), Key: key},
+		Message:    "Api key created successfully, the key is only shown once.",
+		Error:      "",
+		StatusCode: StatusCreated,
+	}, nil
+}
+
+func (*UserService) ListApiKeys(ctx context.Context, request *userpb.ListApiKeysRequest) (*userpb.ListApiKeysResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.ListApiKeysResponse{
+			Data:       nil,
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received ListApiKeys request", zap.String("userEmail", userEmail))
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.ListApiKeysResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	var apiKeys []model.ApiKey
+	if err := userDbConnector.Where("user_id = ? AND revoked_at IS NULL", user.ID).Order("id").Find(&apiKeys).Error; err != nil {
+		logger.Error("Failed to list api keys", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.ListApiKeysResponse{
+			Data:       nil,
+			Message:    "Failed to list the api keys, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	data := make([]*userpb.ApiKey, 0, len(apiKeys))
+	for i := range apiKeys {
+		data = append(data, userpbApiKey(&apiKeys[i]))
+	}
+	return &userpb.ListApiKeysResponse{
+		Data:       data,
+		Message:    "Api keys fetched successfully",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+func (*UserService) RevokeApiKey(ctx context.Context, request *userpb.RevokeApiKeyRequest) (*userpb.RevokeApiKeyResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.RevokeApiKeyResponse{
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received RevokeApiKey request", zap.String("userEmail", userEmail), zap.String("apiKeyId", request.ApiKeyId))
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.RevokeApiKeyResponse{
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	result := userDbConnector.Model(&model.ApiKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", request.ApiKeyId, user.ID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		logger.Error("Failed to revoke api key", zap.String("userEmail", userEmail), zap.Error(result.Error))
+		return &userpb.RevokeApiKeyResponse{
+			Message:    "Failed to revoke the api key, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if result.RowsAffected == 0 {
+		logger.Warn("Api key not found", zap.String("userEmail", userEmail), zap.String("apiKeyId", request.ApiKeyId))
+		return &userpb.RevokeApiKeyResponse{
+			Message:    "Api key not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	logger.Info("Api key revoked", zap.String("userEmail", userEmail), zap.String("apiKeyId", request.ApiKeyId))
+	return &userpb.RevokeApiKeyResponse{
+		Message:    "Api key revoked successfully",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
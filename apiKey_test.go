@@ -0,0 +1,98 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestApiKeyLifecycle(t *testing.T) {
+	service, _ := newTestUserService(t)
+	service.jwtManager.UseApiKeyStore(jwt.NewGormApiKeyStore(userDbConnector))
+	owner := model.User{Name: "owner", Email: "owner@example.com", Phone: "9876543210", Role: model.AdminRole,
+		Roles: []model.Role{{Name: "pos", Permissions: []model.Permission{{Name: "users:read"}}}}}
+	require.Nil(t, userDbConnector.Create(&owner).Error)
+	ownerCtx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: owner.ID, Email: owner.Email,
+		Role: model.AdminRole, Scopes: []string{"users:read"}, EmailVerified: true})
+
+	// keys cannot carry permissions the owner does not have
+	created, err := service.CreateApiKey(ownerCtx, &userpb.CreateApiKeyRequest{Name: "POS", Scopes: []string{"roles:manage"}})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusForbidden), created.StatusCode)
+
+	created, err = service.CreateApiKey(ownerCtx, &userpb.CreateApiKeyRequest{Name: "POS", Scopes: []string{"users:read"}, ExpiresInDays: 30})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), created.StatusCode)
+	key := created.Data.Key
+	assert.Contains(t, key, created.Data.ApiKey.Prefix)
+	assert.NotEmpty(t, created.Data.ApiKey.ExpiresAt)
+
+	principal, err := callWithMetadata(t, service, metadata.Pairs(jwt.ApiKeyHeader, key), "/userpb.UserService/GetUserProfile")
+	require.Nil(t, err)
+	assert.Equal(t, owner.ID, principal.UserID)
+	assert.True(t, principal.IsApiKey())
+	assert.Equal(t, []string{"users:read"}, principal.Scopes)
+	_, err = callWithMetadata(t, service, metadata.Pairs("authorization", "ApiKey "+key), "/userpb.UserService/GetUserProfile")
+	require.Nil(t, err)
+
+	// the account itself is only managed after a login
+	_, err = callWithMetadata(t, service, metadata.Pairs(jwt.ApiKeyHeader, key), "/userpb.UserService/CreateApiKey")
+	assert.NotNil(t, err)
+
+	listed, err := service.ListApiKeys(ownerCtx, &userpb.ListApiKeysRequest{})
+	require.Nil(t, err)
+	require.Len(t, listed.Data, 1)
+	assert.Equal(t, "POS", listed.Data[0].Name)
+	assert.NotEmpty(t, listed.Data[0].LastUsedAt)
+
+	revoked, err := service.RevokeApiKey(ownerCtx, &userpb.RevokeApiKeyRequest{ApiKeyId: listed.Data[0].ApiKeyId})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), revoked.StatusCode)
+	_, err = callWithMetadata(t, service, metadata.Pairs(jwt.ApiKeyHeader, key), "/userpb.UserService/GetUserProfile")
+	assert.NotNil(t, err)
+	revoked, err = service.RevokeApiKey(ownerCtx, &userpb.RevokeApiKeyRequest{ApiKeyId: listed.Data[0].ApiKeyId})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), revoked.StatusCode)
+}
+
+func TestExpiredApiKeyIsRejected(t *testing.T) {
+	service, _ := newTestUserService(t)
+	service.jwtManager.UseApiKeyStore(jwt.NewGormApiKeyStore(userDbConnector))
+	owner := model.User{Name: "owner", Email: "owner@example.com", Phone: "9876543210", Role: model.AdminRole}
+	require.Nil(t, userDbConnector.Create(&owner).Error)
+	key, prefix, keyHash, err := jwt.GenerateApiKey()
+	require.Nil(t, err)
+	expiresAt := time.Now().Add(-time.Minute)
+	require.Nil(t, userDbConnector.Create(&model.ApiKey{UserID: owner.ID, KeyHash: keyHash, Prefix: prefix,
+		Name: "POS", ExpiresAt: &expiresAt, Scopes: "users:read"}).Error)
+
+	_, err = callWithMetadata(t, service, metadata.Pairs(jwt.ApiKeyHeader, key), "/userpb.UserService/GetUserProfile")
+	assert.NotNil(t, err)
+}
+
+func TestApiKeyWithoutScopesCannotReadOwnerDetails(t *testing.T) {
+	service, _ := newTestUserService(t)
+	service.jwtManager.UseApiKeyStore(jwt.NewGormApiKeyStore(userDbConnector))
+	owner := model.User{Name: "owner", Email: "owner@example.com", Phone: "9876543210", Role: model.AdminRole}
+	require.Nil(t, userDbConnector.Create(&owner).Error)
+	ownerCtx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: owner.ID, Email: owner.Email,
+		Role: model.AdminRole, EmailVerified: true})
+	created, err := service.CreateApiKey(ownerCtx, &userpb.CreateApiKeyRequest{Name: "POS"})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), created.StatusCode)
+
+	// the bank and tax details need a login, and the second factor with it
+	for _, method := range []string{"/userpb.UserService/GetUserDetails", "/userpb.UserService/GetUserProfile"} {
+		_, err = callWithMetadata(t, service, metadata.Pairs(jwt.ApiKeyHeader, created.Data.Key), method)
+		assert.Equal(t, codes.Code(StatusForbidden), status.Code(err), method)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"errors"
+	"strconv"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// assignRole adds the named role to the user's roles.
+func assignRole(user *model.User, roleName string) error {
+	var role model.Role
+	if err := userDbConnector.Where("name = ?", roleName).First(&role).Error; err != nil {
+		return err
+	}
+	return userDbConnector.Model(user).Association("Roles").Append(&role)
+}
+
+func (*UserService) AssignRole(ctx context.Context, request *userpb.AssignRoleRequest) (*userpb.AssignRoleResponse, error) {
+	logger.Info("Received AssignRole request", zap.String("userId", request.UserId), zap.String("roleName", request.RoleName))
+	userId, err := strconv.ParseUint(request.UserId, 10, 64)
+	if err != nil {
+		logger.Warn("Invalid user id", zap.String("userId", request.UserId))
+		return &userpb.AssignRoleResponse{
+			Data:       nil,
+			Message:    "Invalid user id",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var user model.User
+	if err := userDbConnector.First(&user, userId).Error; err != nil {
+		logger.Warn("User not found", zap.String("userId", request.UserId), zap.Error(err))
+		return &userpb.AssignRoleResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	err = assignRole(&user, request.RoleName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		logger.Warn("Role not found", zap.String("roleName", request.RoleName), zap.Error(err))
+		return &userpb.AssignRoleResponse{
+			Data:       nil,
+			Message:    "Role not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if err != nil {
+		logger.Error("Failed to assign role", zap.String("userId", request.UserId), zap.Error(err))
+		return &userpb.AssignRoleResponse{
+			Data:       nil,
+			Message:    "Failed to assign role",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	roles := []string{}
+	if err := userDbConnector.Model(&user).Association("Roles").Find(&user.Roles); err == nil {
+		for _, assigned := range user.Roles {
+			roles = append(roles, assigned.Name)
+		}
+	}
+	logger.Info("Role assigned successfully", zap.String("userId", request.UserId), zap.String("roleName", request.RoleName))
+	return &userpb.AssignRoleResponse{
+		Data: &userpb.AssignRoleResponseData{
+			UserId: request.UserId,
+			Roles:  roles,
+		},
+		Message:    "Role assigned successfully. It takes effect with the next token the user receives.",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
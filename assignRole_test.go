@@ -0,0 +1,60 @@
+package main
+
+import (
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateRoleGrantPermissionAndAssignRole(t *testing.T) {
+	service, _ := newTestUserService(t)
+	ctx := context.Background()
+	user := model.User{Name: "partner", Email: "partner@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	userId := strconv.FormatUint(uint64(user.ID), 10)
+
+	created, err := service.CreateRole(ctx, &userpb.CreateRoleRequest{Name: "Delivery Partner"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusBadRequest), created.StatusCode)
+	created, err = service.CreateRole(ctx, &userpb.CreateRoleRequest{Name: "delivery-partner", Description: "Delivers orders"})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), created.StatusCode)
+	assert.Equal(t, "delivery-partner", created.Data.Name)
+	created, err = service.CreateRole(ctx, &userpb.CreateRoleRequest{Name: "delivery-partner"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusConflict), created.StatusCode)
+
+	granted, err := service.GrantPermission(ctx, &userpb.GrantPermissionRequest{RoleName: "delivery-partner", Permission: "orders"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusBadRequest), granted.StatusCode)
+	granted, err = service.GrantPermission(ctx, &userpb.GrantPermissionRequest{RoleName: "missing", Permission: "orders:read"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), granted.StatusCode)
+	granted, err = service.GrantPermission(ctx, &userpb.GrantPermissionRequest{RoleName: "delivery-partner", Permission: "orders:read"})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), granted.StatusCode)
+	assert.Equal(t, []string{"orders:read"}, granted.Data.Permissions)
+
+	assigned, err := service.AssignRole(ctx, &userpb.AssignRoleRequest{UserId: userId, RoleName: "missing"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), assigned.StatusCode)
+	assigned, err = service.AssignRole(ctx, &userpb.AssignRoleRequest{UserId: "0", RoleName: "delivery-partner"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), assigned.StatusCode)
+	assigned, err = service.AssignRole(ctx, &userpb.AssignRoleRequest{UserId: userId, RoleName: "delivery-partner"})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), assigned.StatusCode)
+	assert.Equal(t, []string{"delivery-partner"}, assigned.Data.Roles)
+
+	// the permissions of the role become token scopes
+	tokens, err := service.issueTokens(ctx, &user, "")
+	require.Nil(t, err)
+	principal, err := service.jwtManager.VerifyToken(tokens.Token)
+	require.Nil(t, err)
+	assert.Contains(t, principal.Scopes, "orders:read")
+}
@@ -1,16 +1,34 @@
 package main
 
 import (
-	"auth-microservice/config"
 	"auth-microservice/model"
 	userpb "auth-microservice/proto/user"
 	"context"
-	"strconv"
+	"errors"
 	"strings"
 
 	"go.uber.org/zap"
 )
 
+var errUserNotFound = errors.New("user not found")
+
+// rehashPassword replaces the user's stored hash with one of the preferred
+// algorithm. A failure is only logged, the old hash still works.
+func (userServiceManager *UserService) rehashPassword(user *model.User, userPassword string) {
+	hashedPassword, err := userServiceManager.passwordHashers.Hash(userPassword)
+	if err == nil {
+		// only replace the hash that was verified, the password may have changed since
+		err = userDbConnector.Model(&model.User{}).
+			Where("id = ? AND password = ?", user.ID, user.Password).
+			Update("password", hashedPassword).Error
+	}
+	if err != nil {
+		logger.Warn("Failed to rehash password", zap.String("userEmail", user.Email), zap.Error(err))
+		return
+	}
+	logger.Info("Password rehashed", zap.String("userEmail", user.Email))
+	user.Password = hashedPassword
+}
 
 func (UserServiceManager *UserService) AuthenticateUser(ctx context.Context, request *userpb.AuthenticateUserRequest) (*userpb.AuthenticateUserResponse, error) {
 	userEmail := request.UserEmail
@@ -33,40 +51,120 @@ func (UserServiceManager *UserService) AuthenticateUser(ctx context.Context, req
 			StatusCode: StatusBadRequest,
 		}, nil
 	}
-	var existingUser model.User
-	userNotFoundError := userDbConnector.Where("email = ?", userEmail).First(&existingUser).Error
-	// If the user is not found, create a new user with the provided details
-	if userNotFoundError != nil {
-		logger.Warn("Authentication failed",
-			zap.String("userEmail", userEmail),
-			zap.String("userRole", existingUser.Role),
-			zap.Error(userNotFoundError))
+	// Failed logins are counted per account and per client address
+	throttleKeys := []string{accountThrottleKey(userEmail)}
+	if ip := clientIP(ctx); ip != "" {
+		throttleKeys = append(throttleKeys, ipThrottleKey(ip))
+	}
+	wait, locked, err := UserServiceManager.loginThrottle.check(throttleKeys...)
+	if err != nil {
+		logger.Error("Failed to check login throttle", zap.String("userEmail", userEmail), zap.Error(err))
 		return &userpb.AuthenticateUserResponse{
 			Data:       nil,
-			Message:    "Authentication Failed, User not found OR Invalid role",
-			Error:      "Not Found",
-			StatusCode: StatusNotFound,
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+			Message:    "Security Issues, Please try again later.",
 		}, nil
 	}
-	if existingUser.Role != request.Role {
-		logger.Warn("Invalid role for user", zap.String("userEmail", userEmail), zap.String("userRole", existingUser.Role))
+	if wait > 0 {
+		logger.Warn("Login throttled", zap.String("userEmail", userEmail), zap.Duration("wait", wait), zap.Bool("locked", locked))
 		return &userpb.AuthenticateUserResponse{
 			Data:       nil,
-			Message:    "Invalid role",
-			Error:      "Unauthorized",
-			StatusCode: StatusUnauthorized,
+			Message:    loginThrottledMessage(wait, locked),
+			Error:      "Too Many Requests",
+			StatusCode: StatusTooManyRequests,
 		}, nil
 	}
-	if config.ComparePasswords(existingUser.Password, userPassword) != nil {
-		logger.Warn("Authentication failed due to wrong password",
-			zap.String("userEmail", userEmail))
-		return &userpb.AuthenticateUserResponse{
-			Message: "Authentication Failed,Wrong Password",
-			Error:   "Unauthorized", StatusCode: StatusUnauthorized,
+	var existingUser model.User
+	userNotFoundError := userDbConnector.Where("email = ?", userEmail).First(&existingUser).Error
+	if UserServiceManager.hardenedAuth {
+		// The password is checked even for unknown users, so every failure
+		// takes as long and looks the same
+		passwordError := errUserNotFound
+		if userNotFoundError == nil {
+			passwordError = UserServiceManager.passwordHashers.Compare(existingUser.Password, userPassword)
+		} else {
+			UserServiceManager.passwordHashers.CompareDummy(userPassword)
+		}
+		if passwordError != nil || existingUser.Role != request.Role {
+			if passwordError != nil {
+				UserServiceManager.loginThrottle.recordFailures(throttleKeys...)
+			}
+			logger.Warn("Authentication failed",
+				zap.String("userEmail", userEmail),
+				zap.Bool("userFound", userNotFoundError == nil),
+				zap.Bool("passwordMatched", passwordError == nil))
+			return &userpb.AuthenticateUserResponse{
+				Data:       nil,
+				Message:    "Authentication Failed, Invalid email, password or role",
+				Error:      "Unauthorized",
+				StatusCode: StatusUnauthorized,
+			}, nil
+		}
+	} else {
+		// If the user is not found, create a new user with the provided details
+		if userNotFoundError != nil {
+			UserServiceManager.loginThrottle.recordFailures(throttleKeys...)
+			logger.Warn("Authentication failed",
+				zap.String("userEmail", userEmail),
+				zap.String("userRole", existingUser.Role),
+				zap.Error(userNotFoundError))
+			return &userpb.AuthenticateUserResponse{
+				Data:       nil,
+				Message:    "Authentication Failed, User not found OR Invalid role",
+				Error:      "Not Found",
+				StatusCode: StatusNotFound,
+			}, nil
+		}
+		if existingUser.Role != request.Role {
+			logger.Warn("Invalid role for user", zap.String("userEmail", userEmail), zap.String("userRole", existingUser.Role))
+			return &userpb.AuthenticateUserResponse{
+				Data:       nil,
+				Message:    "Invalid role",
+				Error:      "Unauthorized",
+				StatusCode: StatusUnauthorized,
+			}, nil
+		}
+		if UserServiceManager.passwordHashers.Compare(existingUser.Password, userPassword) != nil {
+			UserServiceManager.loginThrottle.recordFailures(throttleKeys...)
+			logger.Warn("Authentication failed due to wrong password",
+				zap.String("userEmail", userEmail))
+			return &userpb.AuthenticateUserResponse{
+				Message: "Authentication Failed,Wrong Password",
+				Error:   "Unauthorized", StatusCode: StatusUnauthorized,
+			}, nil
+		}
+	}
+	if err := UserServiceManager.loginThrottle.reset(accountThrottleKey(userEmail)); err != nil {
+		logger.Error("Failed to reset login throttle", zap.String("userEmail", userEmail), zap.Error(err))
+	}
+	// Upgrade hashes of older algorithms or parameters while the password is known
+	if UserServiceManager.passwordHashers.NeedsRehash(existingUser.Password) {
+		UserServiceManager.rehashPassword(&existingUser, userPassword)
+	}
+	// Users with two-factor authentication get a challenge for VerifyMfa instead of tokens
+	if existingUser.TotpEnabled {
+		mfaToken, err := UserServiceManager.issueMfaChallenge(&existingUser)
+		if err != nil {
+			logger.Error("Error in generating mfa token",
+				zap.String("userEmail", userEmail),
+				zap.Error(err))
+			return &userpb.AuthenticateUserResponse{
+				Data:       nil,
+				Error:      "Internal Server Error",
+				StatusCode: StatusInternalServerError,
+				Message:    "Security Issues, Please try again later.",
+			}, nil
+		}
+		logger.Info("Password accepted, second factor required", zap.String("userEmail", userEmail))
+		return &userpb.AuthenticateUserResponse{Error: "",
+			Message:    "Two-factor authentication required, Please send a code with the mfa token.",
+			StatusCode: StatusOK,
+			MfaToken:   mfaToken,
 		}, nil
 	}
-	// Gennerating the the jwt token.
-	token, err := UserServiceManager.jwtManager.GenerateToken(&existingUser)
+	// Generating the jwt token and the refresh token.
+	data, err := UserServiceManager.issueTokens(ctx, &existingUser, "")
 	if err != nil {
 		logger.Error("Error in generating token",
 			zap.String("userEmail", userEmail),
@@ -86,14 +184,6 @@ func (UserServiceManager *UserService) AuthenticateUser(ctx context.Context, req
 	return &userpb.AuthenticateUserResponse{Error: "",
 		Message:    "User authenticated successfully",
 		StatusCode: StatusCreated,
-		Data: &userpb.Responsedata{
-			User: &userpb.User{
-				UserId:    strconv.FormatUint(uint64(existingUser.ID), 10),
-				UserName:  existingUser.Name,
-				UserEmail: existingUser.Email,
-				UserPhone: existingUser.Phone,
-			},
-			Token: token,
-		},
+		Data:       data,
 	}, nil
 }
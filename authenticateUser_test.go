@@ -5,11 +5,14 @@ import (
 	"auth-microservice/model"
 	userpb "auth-microservice/proto/user"
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func (m *MockJWTManager) GenerateToken(user *model.User) (string, error) {
@@ -118,3 +121,55 @@ func (suite *UserServiceTestSuite) TestAuthenticateUser_UserNotFound() {
 func TestUserServiceTestSuite(t *testing.T) {
 	suite.Run(t, new(UserServiceTestSuite))
 }
+
+func TestAuthenticateUserRehashesPassword(t *testing.T) {
+	service, _ := newTestUserService(t)
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte("old-password"), bcrypt.MinCost)
+	require.Nil(t, err)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210",
+		Password: string(legacyHash), Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+
+	response, err := service.AuthenticateUser(context.Background(), &userpb.AuthenticateUserRequest{
+		UserEmail: user.Email, UserPassword: "old-password", Role: model.UserRole})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusCreated), response.StatusCode)
+
+	var stored model.User
+	require.Nil(t, userDbConnector.First(&stored, user.ID).Error)
+	assert.True(t, strings.HasPrefix(stored.Password, "$argon2id$"))
+
+	// the upgraded hash still logs the user in
+	response, err = service.AuthenticateUser(context.Background(), &userpb.AuthenticateUserRequest{
+		UserEmail: user.Email, UserPassword: "old-password", Role: model.UserRole})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusCreated), response.StatusCode)
+}
+
+func TestAuthenticateUserHardenedFailuresLookAlike(t *testing.T) {
+	service, _ := newTestUserService(t)
+	service.hardenedAuth = true
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210",
+		Password: hashPassword(t, "right-password"), Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+
+	var failures []*userpb.AuthenticateUserResponse
+	for _, request := range []*userpb.AuthenticateUserRequest{
+		{UserEmail: "nobody@example.com", UserPassword: "right-password", Role: model.UserRole},
+		{UserEmail: user.Email, UserPassword: "wrong-password", Role: model.UserRole},
+		{UserEmail: user.Email, UserPassword: "right-password", Role: "delivery"},
+	} {
+		response, err := service.AuthenticateUser(context.Background(), request)
+		assert.Nil(t, err)
+		failures = append(failures, response)
+	}
+	for _, response := range failures {
+		assert.Equal(t, failures[0], response)
+	}
+	assert.Equal(t, int64(StatusUnauthorized), failures[0].StatusCode)
+
+	response, err := service.AuthenticateUser(context.Background(), &userpb.AuthenticateUserRequest{
+		UserEmail: user.Email, UserPassword: "right-password", Role: model.UserRole})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusCreated), response.StatusCode)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+
+	"go.uber.org/zap"
+)
+
+func (userServiceManager *UserService) ChangePassword(ctx context.Context, request *userpb.ChangePasswordRequest) (*userpb.ChangePasswordResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.ChangePasswordResponse{
+			Data:       nil,
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received ChangePassword request", zap.String("userEmail", userEmail),
+		zap.Bool("revokeOtherSessions", request.RevokeOtherSessions))
+
+	if request.CurrentPassword == "" || request.NewPassword == "" {
+		logger.Warn("Invalid request fields", zap.String("userEmail", userEmail))
+		return &userpb.ChangePasswordResponse{
+			Data:       nil,
+			Message:    "The request contains missing or invalid fields.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.ChangePasswordResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	// wrong current passwords count as failed logins of the account
+	throttleKey := accountThrottleKey(user.Email)
+	wait, locked, err := userServiceManager.loginThrottle.check(throttleKey)
+	if err != nil {
+		logger.Error("Failed to check login throttle", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.ChangePasswordResponse{
+			Data:       nil,
+			Message:    "Failed to change password, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if wait > 0 {
+		logger.Warn("Change password throttled", zap.String("userEmail", userEmail), zap.Duration("wait", wait), zap.Bool("locked", locked))
+		return &userpb.ChangePasswordResponse{
+			Data:       nil,
+			Message:    loginThrottledMessage(wait, locked),
+			Error:      "Too Many Requests",
+			StatusCode: StatusTooManyRequests,
+		}, nil
+	}
+	if userServiceManager.passwordHashers.Compare(user.Password, request.CurrentPassword) != nil {
+		userServiceManager.loginThrottle.recordFailures(throttleKey)
+		logger.Warn("Change password failed due to wrong password", zap.String("userEmail", userEmail))
+		return &userpb.ChangePasswordResponse{
+			Data:       nil,
+			Message:    "The current password is wrong",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	if err := userServiceManager.loginThrottle.reset(throttleKey); err != nil {
+		logger.Error("Failed to reset login throttle", zap.String("userEmail", userEmail), zap.Error(err))
+	}
+	violations, err := userServiceManager.validateNewPassword(request.NewPassword, &user)
+	if err == nil && len(violations) > 0 {
+		logger.Warn("New password violates the password policy", zap.String("userEmail", userEmail))
+		return &userpb.ChangePasswordResponse{
+			Data:       nil,
+			Message:    weakPasswordMessage,
+			Error:      "Weak Password",
+			StatusCode: StatusBadRequest,
+			Violations: violations,
+		}, nil
+	}
+	var hashedPassword string
+	if err == nil {
+		hashedPassword, err = userServiceManager.passwordHashers.Hash(request.NewPassword)
+	}
+	if err == nil {
+		err = userDbConnector.Model(&user).Update("password", hashedPassword).Error
+	}
+	if err != nil {
+		logger.Error("Failed to change password", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.ChangePasswordResponse{
+			Data:       nil,
+			Message:    "Failed to change password, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if !request.RevokeOtherSessions {
+		logger.Info("Password changed successfully", zap.String("userEmail", userEmail))
+		return &userpb.ChangePasswordResponse{
+			Data:       nil,
+			Message:    "Password changed successfully",
+			Error:      "",
+			StatusCode: StatusOK,
+		}, nil
+	}
+
+	// Revoking the other sessions also revokes the caller's token, so this
+	// session continues with a new token pair.
+	if err := revokeAllSessions(user.ID); err != nil {
+		logger.Error("Failed to revoke sessions", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.ChangePasswordResponse{
+			Data:       nil,
+			Message:    "Password was changed, but other sessions could not be logged out. Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	// reload the user for the new token generation
+	var data *userpb.Responsedata
+	err = userDbConnector.First(&user, user.ID).Error
+	if err == nil {
+		data, err = userServiceManager.issueTokens(ctx, &user, "")
+	}
+	if err != nil {
+		logger.Error("Error in generating token", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.ChangePasswordResponse{
+			Data:       nil,
+			Message:    "Password was changed and all sessions were logged out. Please login again.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Password changed and other sessions logged out", zap.String("userEmail", userEmail))
+	return &userpb.ChangePasswordResponse{
+		Data:       data,
+		Message:    "Password changed successfully and other sessions were logged out",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	"auth-microservice/password"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangePassword(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210",
+		Password: hashPassword(t, "old-password")}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	session, err := service.issueTokens(context.Background(), &user, "")
+	require.Nil(t, err)
+	ctx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: user.ID, Email: user.Email})
+
+	response, err := service.ChangePassword(ctx, &userpb.ChangePasswordRequest{CurrentPassword: "wrong-password", NewPassword: "new-password"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), response.StatusCode)
+
+	response, err = service.ChangePassword(ctx, &userpb.ChangePasswordRequest{CurrentPassword: "old-password", NewPassword: "new-password"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), response.StatusCode)
+	assert.Nil(t, response.Data)
+
+	var stored model.User
+	require.Nil(t, userDbConnector.First(&stored, user.ID).Error)
+	assert.Nil(t, service.passwordHashers.Compare(stored.Password, "new-password"))
+	assert.Equal(t, user.TokenGeneration, stored.TokenGeneration)
+
+	// other sessions are kept unless asked otherwise
+	refreshed, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: session.RefreshToken})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), refreshed.StatusCode)
+}
+
+func TestChangePasswordLockout(t *testing.T) {
+	service, _ := newTestUserService(t)
+	// no backoff between attempts, only the lockout
+	service.loginThrottle.baseDelay = 0
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210",
+		Password: hashPassword(t, "old-password"), Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	ctx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: user.ID, Email: user.Email})
+
+	for i := 0; i < service.loginThrottle.maxFailures; i++ {
+		response, err := service.ChangePassword(ctx, &userpb.ChangePasswordRequest{CurrentPassword: "wrong-password", NewPassword: "new-password"})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(StatusUnauthorized), response.StatusCode)
+	}
+	// a stolen access token cannot be used to guess the password
+	response, err := service.ChangePassword(ctx, &userpb.ChangePasswordRequest{CurrentPassword: "old-password", NewPassword: "new-password"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusTooManyRequests), response.StatusCode)
+	assert.Contains(t, response.Message, "the account is locked")
+
+	// the account is locked for logins too
+	login, err := service.AuthenticateUser(contextFromIP("203.0.113.7"), &userpb.AuthenticateUserRequest{
+		UserEmail: user.Email, UserPassword: "old-password", Role: model.UserRole})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusTooManyRequests), login.StatusCode)
+	assert.Equal(t, response.Message, login.Message)
+}
+
+func TestChangePasswordRevokeOtherSessions(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210",
+		Password: hashPassword(t, "old-password")}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	session, err := service.issueTokens(context.Background(), &user, "")
+	require.Nil(t, err)
+	ctx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: user.ID, Email: user.Email})
+
+	response, err := service.ChangePassword(ctx, &userpb.ChangePasswordRequest{
+		CurrentPassword: "old-password", NewPassword: "new-password", RevokeOtherSessions: true})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), response.StatusCode)
+	require.NotNil(t, response.Data)
+
+	refreshed, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: session.RefreshToken})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), refreshed.StatusCode)
+
+	// the new token pair carries the new generation
+	claims, err := service.jwtManager.VerifyToken(response.Data.Token)
+	require.Nil(t, err)
+	assert.Equal(t, user.TokenGeneration+1, claims.TokenGeneration)
+	refreshed, err = service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: response.Data.RefreshToken})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), refreshed.StatusCode)
+}
+
+func TestChangePasswordPolicy(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "jane.doe@example.com", Phone: "9876543210",
+		Password: hashPassword(t, "old-password")}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	ctx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: user.ID, Email: user.Email})
+
+	response, err := service.ChangePassword(ctx, &userpb.ChangePasswordRequest{CurrentPassword: "old-password", NewPassword: "jane.doe"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusBadRequest), response.StatusCode)
+	require.Len(t, response.Violations, 1)
+	assert.Equal(t, password.RuleContainsUserInfo, response.Violations[0].Rule)
+
+	var stored model.User
+	require.Nil(t, userDbConnector.First(&stored, user.ID).Error)
+	assert.Nil(t, service.passwordHashers.Compare(stored.Password, "old-password"))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// clientIP returns the address of the client that sent the request. Requests
+// through the gateway arrive from loopback, for those the gateway appends the
+// real client address to x-forwarded-for, so the last entry is the one it saw.
+// Entries before it are set by the client and are not trusted.
+func clientIP(ctx context.Context) string {
+	var ip net.IP
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip == nil || ip.IsLoopback() {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("x-forwarded-for"); len(values) > 0 {
+				entries := strings.Split(values[len(values)-1], ",")
+				if forwarded := net.ParseIP(strings.TrimSpace(entries[len(entries)-1])); forwarded != nil {
+					ip = forwarded
+				}
+			}
+		}
+	}
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
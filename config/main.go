@@ -5,11 +5,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"regexp"
 	"strings"
 	"unicode"
 
 	"github.com/joho/godotenv"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
@@ -43,9 +43,47 @@ func ValidateFields(userEmail string, userPassword string, userName string, user
 	if !strings.Contains(userEmail, "@") || !strings.Contains(userEmail, ".") {
 		return false
 	}
-	// password validation also
-	return len(userPassword) >= 6 && ValidatePhone(userPhone)
+	// the password itself is checked against the password policy
+	return ValidatePhone(userPhone)
 }
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]{1,49}$`)
+var permissionNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*:[a-z][a-z0-9_-]*$`)
+
+// ValidateRoleName accepts lower case names like "delivery-partner"
+func ValidateRoleName(name string) bool {
+	return roleNamePattern.MatchString(name)
+}
+
+// ValidatePermissionName accepts "resource:action" names like "orders:read"
+func ValidatePermissionName(name string) bool {
+	return len(name) <= 100 && permissionNamePattern.MatchString(name)
+}
+
+// SeedRoles creates the built-in role that is allowed to manage roles and
+// unlock users
+func SeedRoles(db *gorm.DB) error {
+	var permissions []model.Permission
+	for _, name := range []string{model.ManageRolesPermission, model.UnlockUsersPermission,
+		model.ManageServiceClientsPermission, model.ManageOAuthClientsPermission, model.ReadUsersPermission} {
+		var permission model.Permission
+		if err := db.Where(model.Permission{Name: name}).FirstOrCreate(&permission).Error; err != nil {
+			return err
+		}
+		// reading users is granted to the roles of other services
+		if name != model.ReadUsersPermission {
+			permissions = append(permissions, permission)
+		}
+	}
+	var role model.Role
+	err := db.Where(model.Role{Name: model.RoleAdminRole}).
+		Attrs(model.Role{Description: "Can manage roles, service and oauth clients and unlock users"}).
+		FirstOrCreate(&role).Error
+	if err != nil {
+		return err
+	}
+	return db.Model(&role).Association("Permissions").Append(&permissions)
+}
+
 func GoDotEnvVariable(key string) string {
 	err := godotenv.Load(".env")
 	if err != nil {
@@ -60,7 +98,10 @@ func ConnectDB() (*gorm.DB, *gorm.DB) {
 		panic("failed to connect database")
 	}
 	// Migrate the schema
-	userdb.AutoMigrate(&model.User{})
+	userdb.AutoMigrate(model.UserModels()...)
+	if err := SeedRoles(userdb); err != nil {
+		panic("failed to seed roles")
+	}
 
 	ownerDetailsdb, err := gorm.Open(mysql.Open(DatabaseDsn()), &gorm.Config{})
 	if err != nil {
@@ -71,18 +112,6 @@ func ConnectDB() (*gorm.DB, *gorm.DB) {
 	return userdb, ownerDetailsdb
 }
 
-func GenerateHashedPassword(password string) string {
-	// Responsible for generating a hashed password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		log.Fatalf("Couldn't hash password and the error is %s", err)
-	}
-	return string(hashedPassword)
-}
-func ComparePasswords(hashedPassword string, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-}
-
 func ValidateOwnerDeatils(AccountNumber string, IFSCCode string,
 	BankName string, BranchName string, PanNumber string,
 	AdharNumber string, GstNumber string) bool {
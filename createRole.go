@@ -0,0 +1,64 @@
+package main
+
+import (
+	"auth-microservice/config"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+func userpbRole(role *model.Role) *userpb.Role {
+	permissions := make([]string, 0, len(role.Permissions))
+	for _, permission := range role.Permissions {
+		permissions = append(permissions, permission.Name)
+	}
+	return &userpb.Role{
+		RoleId:      strconv.FormatUint(uint64(role.ID), 10),
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: permissions,
+	}
+}
+
+func (*UserService) CreateRole(ctx context.Context, request *userpb.CreateRoleRequest) (*userpb.CreateRoleResponse, error) {
+	logger.Info("Received CreateRole request", zap.String("roleName", request.Name))
+	if !config.ValidateRoleName(request.Name) {
+		logger.Warn("Invalid role name", zap.String("roleName", request.Name))
+		return &userpb.CreateRoleResponse{
+			Data:       nil,
+			Message:    "Invalid role name. Use lower case letters, digits and dashes.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var existingRole model.Role
+	if err := userDbConnector.Where("name = ?", request.Name).First(&existingRole).Error; err == nil {
+		logger.Warn("Role already exists", zap.String("roleName", request.Name))
+		return &userpb.CreateRoleResponse{
+			Data:       nil,
+			Message:    "Role already exists",
+			Error:      "Conflict",
+			StatusCode: StatusConflict,
+		}, nil
+	}
+	role := &model.Role{Name: request.Name, Description: request.Description}
+	if err := userDbConnector.Create(role).Error; err != nil {
+		logger.Error("Failed to create role", zap.String("roleName", request.Name), zap.Error(err))
+		return &userpb.CreateRoleResponse{
+			Data:       nil,
+			Message:    "Failed to create role",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Role created successfully", zap.String("roleName", role.Name))
+	return &userpb.CreateRoleResponse{
+		Data:       userpbRole(role),
+		Message:    "Role created successfully",
+		Error:      "",
+		StatusCode: StatusCreated,
+	}, nil
+}
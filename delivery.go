@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// deliverInBackground runs deliver after the response is returned, so the
+// time the response takes does not tell whether anything was sent. Failures
+// can only be logged.
+func (userServiceManager *UserService) deliverInBackground(ctx context.Context, what string, deliver func(ctx context.Context) error) {
+	ctx = context.WithoutCancel(ctx)
+	userServiceManager.deliveries.Add(1)
+	go func() {
+		defer userServiceManager.deliveries.Done()
+		if err := deliver(ctx); err != nil {
+			logger.Error("Failed to send "+what, zap.Error(err))
+			return
+		}
+		logger.Info("Sent " + what)
+	}()
+}
@@ -0,0 +1,47 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+)
+
+// FakeProvider signs in the identities of codes added by tests, without a
+// server.
+type FakeProvider struct {
+	name       string
+	mu         sync.Mutex
+	identities map[string]*Identity
+}
+
+func NewFakeProvider(name string) *FakeProvider {
+	return &FakeProvider{name: name, identities: map[string]*Identity{}}
+}
+
+// AddCode makes Exchange return identity for code, once.
+func (provider *FakeProvider) AddCode(code string, identity Identity) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	identity.Provider = provider.name
+	provider.identities[code] = &identity
+}
+
+func (provider *FakeProvider) Name() string {
+	return provider.name
+}
+
+func (provider *FakeProvider) AuthCodeURL(state string, nonce string, codeVerifier string) string {
+	return "https://" + provider.name + ".example.com/authorize?state=" + url.QueryEscape(state)
+}
+
+func (provider *FakeProvider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Identity, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	identity, ok := provider.identities[code]
+	if !ok {
+		return nil, errors.New("invalid code")
+	}
+	delete(provider.identities, code)
+	return identity, nil
+}
@@ -0,0 +1,193 @@
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+// ErrNoEmail is returned when the provider shares no verified email address.
+var ErrNoEmail = errors.New("the account has no verified email address")
+
+// Identity is the account a user has at a provider.
+type Identity struct {
+	Provider string
+	// Subject identifies the account at the provider, it never changes
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider runs the authorization code flow with PKCE against an identity
+// provider.
+type Provider interface {
+	Name() string
+	// AuthCodeURL returns where to send the user to sign in. The provider
+	// returns state with the code, codeVerifier and nonce are kept to
+	// complete the login.
+	AuthCodeURL(state string, nonce string, codeVerifier string) string
+	// Exchange trades the code for the identity of the user.
+	Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Identity, error)
+}
+
+// OIDCProvider signs users in with OpenID Connect, like Google. The identity
+// is read from the ID token, which is verified against the keys the issuer
+// publishes.
+type OIDCProvider struct {
+	name     string
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCProvider discovers the endpoints and keys of issuer. The scopes of
+// config default to openid, email and profile.
+func NewOIDCProvider(ctx context.Context, name string, issuer string, config oauth2.Config) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("could not discover %s: %w", issuer, err)
+	}
+	config.Endpoint = provider.Endpoint()
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	return &OIDCProvider{
+		name:     name,
+		config:   config,
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+func (provider *OIDCProvider) Name() string {
+	return provider.name
+}
+
+func (provider *OIDCProvider) AuthCodeURL(state string, nonce string, codeVerifier string) string {
+	return provider.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier))
+}
+
+func (provider *OIDCProvider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Identity, error) {
+	token, err := provider.config.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("could not exchange the code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("the token response has no id_token")
+	}
+	idToken, err := provider.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("invalid id token: nonce does not match")
+	}
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid id token claims: %w", err)
+	}
+	return &Identity{
+		Provider:      provider.name,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
+
+// GitHubAPI is the base URL of the GitHub REST API.
+const GitHubAPI = "https://api.github.com"
+
+// GitHubProvider signs users in with GitHub, which speaks OAuth 2.0 but not
+// OpenID Connect, so the identity is read from its API.
+type GitHubProvider struct {
+	config oauth2.Config
+	apiURL string
+}
+
+// NewGitHubProvider uses the GitHub endpoints unless config sets others.
+func NewGitHubProvider(config oauth2.Config, apiURL string) *GitHubProvider {
+	if config.Endpoint.AuthURL == "" {
+		config.Endpoint = github.Endpoint
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"read:user", "user:email"}
+	}
+	return &GitHubProvider{config: config, apiURL: strings.TrimSuffix(apiURL, "/")}
+}
+
+func (provider *GitHubProvider) Name() string {
+	return "github"
+}
+
+// AuthCodeURL ignores nonce, GitHub issues no ID token to put it in.
+func (provider *GitHubProvider) AuthCodeURL(state string, nonce string, codeVerifier string) string {
+	return provider.config.AuthCodeURL(state, oauth2.S256ChallengeOption(codeVerifier))
+}
+
+func (provider *GitHubProvider) Exchange(ctx context.Context, code string, codeVerifier string, nonce string) (*Identity, error) {
+	token, err := provider.config.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("could not exchange the code: %w", err)
+	}
+	client := provider.config.Client(ctx, token)
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(client, provider.apiURL+"/user", &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, errors.New("the github user has no id")
+	}
+	// the public profile email is not necessarily verified, the primary
+	// address from the email list is
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(client, provider.apiURL+"/user/emails", &emails); err != nil {
+		return nil, err
+	}
+	identity := &Identity{Provider: provider.Name(), Subject: strconv.FormatInt(user.ID, 10), Name: user.Name}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+	for _, email := range emails {
+		if email.Primary && email.Verified {
+			identity.Email = email.Email
+			identity.EmailVerified = true
+		}
+	}
+	if identity.Email == "" {
+		return nil, ErrNoEmail
+	}
+	return identity, nil
+}
+
+func getJSON(client *http.Client, url string, target interface{}) error {
+	response, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("could not get %s: %w", url, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not get %s: %s", url, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
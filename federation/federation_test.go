@@ -0,0 +1,192 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	josejwt "github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// stubOIDCProvider is a minimal OpenID provider. Codes are handed out by
+// authorize instead of a login page, the token endpoint checks PKCE.
+type stubOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	mu     sync.Mutex
+	codes  map[string]stubAuthorization
+}
+
+type stubAuthorization struct {
+	challenge string
+	nonce     string
+	subject   string
+	email     string
+}
+
+func newStubOIDCProvider(t *testing.T) *stubOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	stub := &stubOIDCProvider{key: key, codes: map[string]stubAuthorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                stub.server.URL,
+			"authorization_endpoint":                stub.server.URL + "/authorize",
+			"token_endpoint":                        stub.server.URL + "/token",
+			"jwks_uri":                              stub.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "stub", Algorithm: "RS256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		stub.mu.Lock()
+		authorization, ok := stub.codes[r.FormValue("code")]
+		delete(stub.codes, r.FormValue("code"))
+		stub.mu.Unlock()
+		verifierHash := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifierHash[:]) != authorization.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     stub.idToken(t, "client", authorization),
+		})
+	})
+	stub.server = httptest.NewServer(mux)
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (stub *stubOIDCProvider) idToken(t *testing.T, audience string, authorization stubAuthorization) string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: stub.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "stub"))
+	require.Nil(t, err)
+	now := time.Now()
+	token, err := josejwt.Signed(signer).Claims(josejwt.Claims{
+		Issuer:   stub.server.URL,
+		Subject:  authorization.subject,
+		Audience: josejwt.Audience{audience},
+		IssuedAt: josejwt.NewNumericDate(now),
+		Expiry:   josejwt.NewNumericDate(now.Add(time.Hour)),
+	}).Claims(map[string]interface{}{
+		"nonce":          authorization.nonce,
+		"email":          authorization.email,
+		"email_verified": true,
+		"name":           "Stub User",
+	}).Serialize()
+	require.Nil(t, err)
+	return token
+}
+
+// authorize plays the user signing in at the provider and returns the code
+// the provider redirects back with.
+func (stub *stubOIDCProvider) authorize(t *testing.T, authCodeURL string, subject string, email string) string {
+	parsed, err := url.Parse(authCodeURL)
+	require.Nil(t, err)
+	query := parsed.Query()
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	code := oauth2.GenerateVerifier()
+	stub.mu.Lock()
+	stub.codes[code] = stubAuthorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"),
+		subject: subject, email: email}
+	stub.mu.Unlock()
+	return code
+}
+
+func TestOIDCProvider(t *testing.T) {
+	stub := newStubOIDCProvider(t)
+	ctx := context.Background()
+	provider, err := NewOIDCProvider(ctx, "google", stub.server.URL, oauth2.Config{
+		ClientID: "client", ClientSecret: "secret", RedirectURL: "http://localhost:3000/oauth/callback"})
+	require.Nil(t, err)
+
+	verifier := oauth2.GenerateVerifier()
+	authCodeURL := provider.AuthCodeURL("state", "nonce", verifier)
+	assert.Contains(t, authCodeURL, stub.server.URL+"/authorize?")
+	code := stub.authorize(t, authCodeURL, "subject-1", "user@example.com")
+
+	identity, err := provider.Exchange(ctx, code, verifier, "nonce")
+	require.Nil(t, err)
+	assert.Equal(t, &Identity{Provider: "google", Subject: "subject-1", Email: "user@example.com",
+		EmailVerified: true, Name: "Stub User"}, identity)
+}
+
+func TestOIDCProviderRejectsWrongVerifierAndNonce(t *testing.T) {
+	stub := newStubOIDCProvider(t)
+	ctx := context.Background()
+	provider, err := NewOIDCProvider(ctx, "google", stub.server.URL, oauth2.Config{ClientID: "client"})
+	require.Nil(t, err)
+
+	verifier := oauth2.GenerateVerifier()
+	code := stub.authorize(t, provider.AuthCodeURL("state", "nonce", verifier), "subject-1", "user@example.com")
+	_, err = provider.Exchange(ctx, code, oauth2.GenerateVerifier(), "nonce")
+	assert.NotNil(t, err)
+
+	code = stub.authorize(t, provider.AuthCodeURL("state", "nonce", verifier), "subject-1", "user@example.com")
+	_, err = provider.Exchange(ctx, code, verifier, "other-nonce")
+	assert.NotNil(t, err)
+}
+
+func TestOIDCProviderRejectsOtherAudience(t *testing.T) {
+	stub := newStubOIDCProvider(t)
+	ctx := context.Background()
+	provider, err := NewOIDCProvider(ctx, "google", stub.server.URL, oauth2.Config{ClientID: "other-client"})
+	require.Nil(t, err)
+
+	verifier := oauth2.GenerateVerifier()
+	code := stub.authorize(t, provider.AuthCodeURL("state", "nonce", verifier), "subject-1", "user@example.com")
+	_, err = provider.Exchange(ctx, code, verifier, "nonce")
+	assert.NotNil(t, err)
+}
+
+func TestGitHubProvider(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "code" || r.FormValue("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"access","token_type":"bearer"}`))
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":42,"login":"octocat","name":""}`))
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"email":"public@example.com","primary":false,"verified":true},
+			{"email":"octocat@example.com","primary":true,"verified":true}]`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider := NewGitHubProvider(oauth2.Config{ClientID: "client", Endpoint: oauth2.Endpoint{
+		AuthURL: server.URL + "/login/oauth/authorize", TokenURL: server.URL + "/login/oauth/access_token"}}, server.URL)
+	identity, err := provider.Exchange(context.Background(), "code", oauth2.GenerateVerifier(), "")
+	require.Nil(t, err)
+	assert.Equal(t, &Identity{Provider: "github", Subject: "42", Email: "octocat@example.com",
+		EmailVerified: true, Name: "octocat"}, identity)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"auth-microservice/jwt"
 	"auth-microservice/model"
 	userpb "auth-microservice/proto/user"
 	"context"
@@ -10,30 +11,20 @@ import (
 func (*UserService) GetUserDetails(ctx context.Context, request *userpb.GetUserDetailsRequest) (*userpb.GetUserDetailsResponse, error) {
 	logger.Info("GetUserDetails invoked")
 	// Extract the fields for context
-	userEmail, emailCtxError := ctx.Value("userEmail").(string)
-	userRole, roleCtxError := ctx.Value("userRole").(string)
-	if !emailCtxError || !roleCtxError {
-		logger.Error("Failed to get user email and role from context")
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
 		return &userpb.GetUserDetailsResponse{
 			Data:       nil,
-			Message:    "Failed to get user email and role from context",
+			Message:    "Failed to get authenticated user from context",
 			StatusCode: StatusInternalServerError,
 			Error:      "Internal Server Error",
 		}, nil
 	}
-	if userRole != model.AdminRole {
-		logger.Warn("Unauthorized access")
-		return &userpb.GetUserDetailsResponse{
-			Data:       nil,
-			Message:    "Unauthorized access",
-			StatusCode: StatusUnauthorized,
-			Error:      "Unauthorized",
-		}, nil
-	}
 	var user model.User
 	var details model.Details
 
-	if err := userDbConnector.Where("email = ?", userEmail).First(&user).Error; err != nil {
+	if err := loadPrincipalUser(principal, &user); err != nil {
 		logger.Error("User not found")
 		return &userpb.GetUserDetailsResponse{
 			Data:       nil,
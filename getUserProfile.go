@@ -0,0 +1,42 @@
+package main
+
+import (
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// GetUserProfile looks up any user by id, for other services that only know
+// the user id, like the one stored with an order.
+func (*UserService) GetUserProfile(ctx context.Context, request *userpb.GetUserProfileRequest) (*userpb.GetUserProfileResponse, error) {
+	logger.Info("Received GetUserProfile request", zap.String("userId", request.UserId))
+	userId, err := strconv.ParseUint(request.UserId, 10, 64)
+	if err != nil {
+		logger.Warn("Invalid user id", zap.String("userId", request.UserId))
+		return &userpb.GetUserProfileResponse{
+			Data:       nil,
+			Message:    "Invalid user id",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var user model.User
+	if err := userDbConnector.First(&user, userId).Error; err != nil {
+		logger.Warn("User not found", zap.String("userId", request.UserId), zap.Error(err))
+		return &userpb.GetUserProfileResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	return &userpb.GetUserProfileResponse{
+		Data:       userpbUser(&user),
+		Message:    "User found",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
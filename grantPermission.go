@@ -0,0 +1,61 @@
+package main
+
+import (
+	"auth-microservice/config"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+
+	"go.uber.org/zap"
+)
+
+func (*UserService) GrantPermission(ctx context.Context, request *userpb.GrantPermissionRequest) (*userpb.GrantPermissionResponse, error) {
+	logger.Info("Received GrantPermission request",
+		zap.String("roleName", request.RoleName), zap.String("permission", request.Permission))
+	if !config.ValidatePermissionName(request.Permission) {
+		logger.Warn("Invalid permission name", zap.String("permission", request.Permission))
+		return &userpb.GrantPermissionResponse{
+			Data:       nil,
+			Message:    "Invalid permission. Permissions look like resource:action, e.g. orders:read",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var role model.Role
+	if err := userDbConnector.Where("name = ?", request.RoleName).First(&role).Error; err != nil {
+		logger.Warn("Role not found", zap.String("roleName", request.RoleName), zap.Error(err))
+		return &userpb.GrantPermissionResponse{
+			Data:       nil,
+			Message:    "Role not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	var permission model.Permission
+	if err := userDbConnector.Where(model.Permission{Name: request.Permission}).FirstOrCreate(&permission).Error; err != nil {
+		logger.Error("Failed to create permission", zap.String("permission", request.Permission), zap.Error(err))
+		return &userpb.GrantPermissionResponse{
+			Data:       nil,
+			Message:    "Failed to grant permission",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if err := userDbConnector.Model(&role).Association("Permissions").Append(&permission); err != nil {
+		logger.Error("Failed to grant permission", zap.String("roleName", role.Name), zap.Error(err))
+		return &userpb.GrantPermissionResponse{
+			Data:       nil,
+			Message:    "Failed to grant permission",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userDbConnector.Preload("Permissions").First(&role, role.ID)
+	logger.Info("Permission granted successfully", zap.String("roleName", role.Name), zap.String("permission", permission.Name))
+	return &userpb.GrantPermissionResponse{
+		Data:       userpbRole(&role),
+		Message:    "Permission granted successfully",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
@@ -0,0 +1,84 @@
+package jwt
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"auth-microservice/model"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	PurposeEmailVerification = "email_verification"
+	// PurposeMfaChallenge tokens prove the password of a user with two-factor
+	// authentication was checked, the second factor is still missing
+	PurposeMfaChallenge = "mfa_challenge"
+)
+
+// ActionClaims are the claims of a short lived token that authorizes a single
+// action, like verifying an email address. The purpose is used as audience so
+// these tokens are never accepted as access tokens.
+type ActionClaims struct {
+	jwt.StandardClaims
+	UserEmail string
+}
+
+// UserID returns the id of the user the token was issued to.
+func (claims *ActionClaims) UserID() uint {
+	userID, _ := strconv.ParseUint(claims.Subject, 10, 64)
+	return uint(userID)
+}
+
+// GenerateActionToken issues a token for purpose that is valid for duration.
+func (manager *JWTManager) GenerateActionToken(user *model.User, purpose string, duration time.Duration) (string, error) {
+	tokenID, err := GenerateID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return manager.sign(ActionClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Issuer:    manager.issuer,
+			Audience:  purpose,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(duration).Unix(),
+		},
+		UserEmail: user.Email,
+	})
+}
+
+// VerifyActionToken checks the signature, expiry and purpose of an action token.
+func (manager *JWTManager) VerifyActionToken(actionToken string, purpose string) (*ActionClaims, error) {
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(actionToken, &ActionClaims{}, manager.keyFunc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	claims, ok := token.Claims.(*ActionClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+	if err := manager.validateClaims(&claims.StandardClaims, purpose); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	return claims, nil
+}
+
+// ConsumeActionToken verifies an action token and revokes it, so that it can
+// only be used once.
+func (manager *JWTManager) ConsumeActionToken(actionToken string, purpose string) (*ActionClaims, error) {
+	claims, err := manager.VerifyActionToken(actionToken, purpose)
+	if err != nil {
+		return nil, err
+	}
+	// revoking fails on the unique token id if the token was used before
+	if err := manager.RevokeToken(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		return nil, fmt.Errorf("token was already used")
+	}
+	return claims, nil
+}
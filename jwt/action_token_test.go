@@ -0,0 +1,34 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+
+	"auth-microservice/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActionTokenIsSingleUse(t *testing.T) {
+	manager, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	manager.UseClaimsValidation("meal-mingle-auth", "meal-mingle", time.Minute)
+	manager.UseRevocationStore(&fakeRevocationStore{revoked: map[string]bool{}})
+	user := &model.User{Email: "user@example.com"}
+	user.ID = 7
+
+	token, err := manager.GenerateActionToken(user, PurposeEmailVerification, time.Hour)
+	assert.Nil(t, err)
+
+	_, err = manager.VerifyActionToken(token, "password_reset")
+	assert.NotNil(t, err)
+	_, err = manager.VerifyToken(token)
+	assert.NotNil(t, err, "action tokens must not be accepted as access tokens")
+
+	claims, err := manager.ConsumeActionToken(token, PurposeEmailVerification)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(7), claims.UserID())
+	assert.Equal(t, "user@example.com", claims.UserEmail)
+
+	_, err = manager.ConsumeActionToken(token, PurposeEmailVerification)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,108 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"auth-microservice/model"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// ApiKeyPrefix starts every API key, so leaked keys are easy to spot
+	ApiKeyPrefix = "mmk_"
+	// ApiKeyHeader is the metadata key API keys can be sent in, the
+	// authorization header with the ApiKey scheme works as well
+	ApiKeyHeader = "x-api-key"
+)
+
+// ErrInvalidApiKey is returned for unknown, expired and revoked keys.
+var ErrInvalidApiKey = errors.New("invalid api key")
+
+// ApiKeyStore resolves API keys to the user that created them.
+type ApiKeyStore interface {
+	Authenticate(apiKey string) (*Principal, error)
+}
+
+// GenerateApiKey creates a new key. It returns the key for the user, the
+// prefix shown in listings and the hash to store.
+func GenerateApiKey() (string, string, string, error) {
+	id := make([]byte, 4)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", "", fmt.Errorf("could not generate api key: %w", err)
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", fmt.Errorf("could not generate api key: %w", err)
+	}
+	prefix := ApiKeyPrefix + hex.EncodeToString(id)
+	apiKey := prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return apiKey, prefix, HashOpaqueToken(apiKey), nil
+}
+
+type gormApiKeyStore struct {
+	db *gorm.DB
+	// lastUsedInterval limits how often the last use of a key is written
+	lastUsedInterval time.Duration
+}
+
+// NewGormApiKeyStore returns an ApiKeyStore backed by the api_keys table.
+func NewGormApiKeyStore(db *gorm.DB) ApiKeyStore {
+	return &gormApiKeyStore{db: db, lastUsedInterval: time.Minute}
+}
+
+func (store *gormApiKeyStore) Authenticate(apiKey string) (*Principal, error) {
+	if !strings.HasPrefix(apiKey, ApiKeyPrefix) {
+		return nil, ErrInvalidApiKey
+	}
+	now := time.Now()
+	var key model.ApiKey
+	err := store.db.Where("key_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)",
+		HashOpaqueToken(apiKey), now).First(&key).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrInvalidApiKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	var user model.User
+	err = store.db.Preload("Roles.Permissions").First(&user, key.UserID).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrInvalidApiKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = store.db.Model(&model.ApiKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", key.ID, now.Add(-store.lastUsedInterval)).
+		Update("last_used_at", now).Error
+	if err != nil {
+		return nil, err
+	}
+	// permissions the user lost since creating the key are not granted
+	userPermissions := map[string]bool{}
+	for _, scope := range userScopes(&user) {
+		userPermissions[scope] = true
+	}
+	var scopes []string
+	for _, scope := range strings.Fields(key.Scopes) {
+		if userPermissions[scope] {
+			scopes = append(scopes, scope)
+		}
+	}
+	return &Principal{
+		UserID:        user.ID,
+		Email:         user.Email,
+		Role:          user.Role,
+		AuthTime:      key.CreatedAt,
+		Scopes:        scopes,
+		EmailVerified: !user.EmailVerificationPending,
+		ApiKeyID:      key.ID,
+	}, nil
+}
@@ -0,0 +1,133 @@
+package jwt
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"auth-microservice/model"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// PurposeSsoSession tokens are kept in a cookie by the OpenID provider, so a
+// user who logged in once is not asked again by the other frontends.
+const PurposeSsoSession = "sso_session"
+
+// IDClaims are the claims of an OpenID Connect ID token, issued to the
+// client as audience.
+type IDClaims struct {
+	jwt.StandardClaims
+	AuthTime      int64  `json:"auth_time"`
+	Nonce         string `json:"nonce,omitempty"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name,omitempty"`
+}
+
+// Issuer is the iss claim of issued tokens.
+func (manager *JWTManager) Issuer() string {
+	return manager.issuer
+}
+
+// SigningAlgorithm returns the algorithm of the asymmetric signing keys, or
+// an empty string when tokens are signed with the secret key.
+func (manager *JWTManager) SigningAlgorithm() string {
+	if manager.keySet == nil {
+		return ""
+	}
+	return manager.keySet.algorithm
+}
+
+// GenerateIDToken issues an ID token for clientID. Clients verify it with the
+// published keys, so it is never signed with the secret key.
+func (manager *JWTManager) GenerateIDToken(user *model.User, clientID string, nonce string, authTime time.Time) (string, error) {
+	if manager.keySet == nil {
+		return "", fmt.Errorf("id tokens need an asymmetric signing key")
+	}
+	now := time.Now()
+	return manager.sign(IDClaims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Issuer:    manager.issuer,
+			Audience:  clientID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(manager.tokenDuration).Unix(),
+		},
+		AuthTime:      authTime.Unix(),
+		Nonce:         nonce,
+		Email:         user.Email,
+		EmailVerified: !user.EmailVerificationPending,
+		Name:          user.Name,
+	})
+}
+
+// GenerateSessionToken issues an SSO session token. It carries the token
+// generation, so logging out all sessions ends the SSO session too.
+func (manager *JWTManager) GenerateSessionToken(user *model.User, duration time.Duration) (string, error) {
+	tokenID, err := GenerateID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	return manager.sign(UserClaims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Issuer:    manager.issuer,
+			Audience:  PurposeSsoSession,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(duration).Unix(),
+		},
+		UserEmail:       user.Email,
+		TokenGeneration: user.TokenGeneration,
+	})
+}
+
+// VerifySessionToken checks an SSO session token and that it was not revoked.
+func (manager *JWTManager) VerifySessionToken(sessionToken string) (*Principal, error) {
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(sessionToken, &UserClaims{}, manager.keyFunc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	claims, ok := token.Claims.(*UserClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+	if err := manager.validateClaims(&claims.StandardClaims, PurposeSsoSession); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	if err := manager.checkRevocation(claims); err != nil {
+		return nil, err
+	}
+	return principalFromClaims(claims), nil
+}
+
+// Authenticate verifies an access token presented outside of gRPC, like to
+// the userinfo endpoint, and returns its principal.
+func (manager *JWTManager) Authenticate(accessToken string) (*Principal, error) {
+	claims, err := manager.VerifyToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := manager.checkRevocation(claims); err != nil {
+		return nil, err
+	}
+	return principalFromClaims(claims), nil
+}
+
+func (manager *JWTManager) checkRevocation(claims *UserClaims) error {
+	if manager.revocationStore == nil {
+		return nil
+	}
+	revoked, err := manager.revocationStore.IsRevoked(claims)
+	if err != nil {
+		return fmt.Errorf("could not check token revocation: %w", err)
+	}
+	if revoked {
+		return fmt.Errorf("token has been revoked")
+	}
+	return nil
+}
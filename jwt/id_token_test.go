@@ -0,0 +1,51 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+
+	"auth-microservice/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateIDTokenNeedsKeySet(t *testing.T) {
+	manager, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	_, err := manager.GenerateIDToken(&model.User{Email: "user@example.com"}, "client", "nonce", time.Now())
+	assert.NotNil(t, err)
+
+	keySet, err := LoadKeySet(t.TempDir(), AlgorithmRS256)
+	assert.Nil(t, err)
+	manager.UseKeySet(keySet)
+	assert.Equal(t, AlgorithmRS256, manager.SigningAlgorithm())
+	idToken, err := manager.GenerateIDToken(&model.User{Email: "user@example.com"}, "client", "nonce", time.Now())
+	assert.Nil(t, err)
+	// an ID token is issued to the client, it is no access token
+	_, err = manager.VerifyToken(idToken)
+	assert.NotNil(t, err)
+}
+
+func TestSessionTokenIsNoAccessToken(t *testing.T) {
+	manager, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	store := &fakeRevocationStore{revoked: map[string]bool{}}
+	manager.UseRevocationStore(store)
+	user := &model.User{Email: "user@example.com"}
+	user.ID = 7
+
+	sessionToken, err := manager.GenerateSessionToken(user, time.Hour)
+	assert.Nil(t, err)
+	principal, err := manager.VerifySessionToken(sessionToken)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(7), principal.UserID)
+	_, err = manager.Authenticate(sessionToken)
+	assert.NotNil(t, err)
+
+	accessToken, err := manager.GenerateToken(user, "")
+	assert.Nil(t, err)
+	_, err = manager.VerifySessionToken(accessToken)
+	assert.NotNil(t, err)
+
+	store.revoked[principal.TokenID] = true
+	_, err = manager.VerifySessionToken(sessionToken)
+	assert.NotNil(t, err)
+}
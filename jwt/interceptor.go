@@ -0,0 +1,89 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor returns an interceptor that authenticates every call
+// with the manager's keys, except for the given public methods.
+func (manager *JWTManager) UnaryServerInterceptor(publicMethods ...string) grpc.UnaryServerInterceptor {
+	public := make(map[string]bool, len(publicMethods))
+	for _, method := range publicMethods {
+		public[method] = true
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		// skip the authentication for the public endpoints
+		if public[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return nil, status.Errorf(401, "metadata is not provided")
+		}
+		tokenString := md.Get("authorization")
+		if apiKey, found := apiKeyFromMetadata(md); found {
+			principal, err := manager.authenticateApiKey(apiKey)
+			if err != nil {
+				return nil, err
+			}
+			return handler(NewContext(ctx, principal), req)
+		}
+		if len(tokenString) == 0 {
+			return nil, status.Errorf(401, "authorization token is not provided")
+		}
+		token, found := strings.CutPrefix(tokenString[0], "Bearer ")
+		if !found {
+			return nil, status.Errorf(401, "authorization token must use the Bearer scheme")
+		}
+		// Parse JWT token
+		claims, err := manager.VerifyToken(token)
+		if err != nil {
+			return nil, status.Errorf(401, "token is invalid: %v", err)
+		}
+		if manager.revocationStore != nil {
+			revoked, err := manager.revocationStore.IsRevoked(claims)
+			if err != nil {
+				return nil, status.Errorf(500, "could not check token revocation: %v", err)
+			}
+			if revoked {
+				return nil, status.Errorf(401, "token has been revoked")
+			}
+		}
+		// Pass the caller to the context for further use
+		ctx = NewContext(ctx, principalFromClaims(claims))
+		// Proceed with the request
+		return handler(ctx, req)
+	}
+}
+
+// apiKeyFromMetadata reads an API key from the x-api-key header or the
+// authorization header with the ApiKey scheme.
+func apiKeyFromMetadata(md metadata.MD) (string, bool) {
+	if apiKey := md.Get(ApiKeyHeader); len(apiKey) > 0 {
+		return apiKey[0], true
+	}
+	if authorization := md.Get("authorization"); len(authorization) > 0 {
+		return strings.CutPrefix(authorization[0], "ApiKey ")
+	}
+	return "", false
+}
+
+func (manager *JWTManager) authenticateApiKey(apiKey string) (*Principal, error) {
+	if manager.apiKeyStore == nil {
+		return nil, status.Errorf(401, "api keys are not accepted")
+	}
+	principal, err := manager.apiKeyStore.Authenticate(apiKey)
+	if errors.Is(err, ErrInvalidApiKey) {
+		return nil, status.Errorf(401, "api key is invalid, expired or revoked")
+	}
+	if err != nil {
+		return nil, status.Errorf(500, "could not check api key: %v", err)
+	}
+	return principal, nil
+}
@@ -1,60 +1,236 @@
 package jwt
 
 import (
-	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"os"
-	"strings"
+	"net/http"
+	"sort"
+	"strconv"
 	"time"
 
 	"auth-microservice/model"
 
 	"github.com/dgrijalva/jwt-go"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
 )
 
 type JWTManager struct {
-	secretKey     string
-	tokenDuration time.Duration
+	secretKey            string
+	tokenDuration        time.Duration
+	refreshTokenDuration time.Duration
+	revocationStore      RevocationStore
+	apiKeyStore          ApiKeyStore
+	keySet               *KeySet
+	issuer               string
+	audience             string
+	clockSkew            time.Duration
+	// legacyTokensUntil is when access tokens without issuer and audience
+	// stop being accepted
+	legacyTokensUntil time.Time
 }
 type UserClaims struct {
 	jwt.StandardClaims
 	UserEmail string
 	UserRole  string
+	// TokenGeneration is the user's generation when the token was issued, tokens
+	// from an older generation are rejected after a "log out all sessions".
+	TokenGeneration uint
+	// Scopes are the permissions granted through the user's roles
+	Scopes []string `json:"scopes,omitempty"`
+	// EmailVerificationPending is set until the user verified their email
+	EmailVerificationPending bool `json:"email_verification_pending,omitempty"`
+	// SubjectType tells service clients apart from users, whose tokens
+	// leave it empty
+	SubjectType string `json:"sub_type,omitempty"`
+	// SessionID is the login the token was issued for
+	SessionID string `json:"sid,omitempty"`
 }
 
-func NewJWTManager(secretKey string, tokenDuration time.Duration) (*JWTManager, error) {
+func NewJWTManager(secretKey string, tokenDuration time.Duration, refreshTokenDuration time.Duration) (*JWTManager, error) {
 	return &JWTManager{
-		secretKey:     secretKey,
-		tokenDuration: tokenDuration,
+		secretKey:            secretKey,
+		tokenDuration:        tokenDuration,
+		refreshTokenDuration: refreshTokenDuration,
 	}, nil
 }
-func (manager *JWTManager) GenerateToken(user *model.User) (string, error) {
+
+// UseRevocationStore sets the store consulted by the interceptor to reject
+// tokens that were revoked before they expired.
+func (manager *JWTManager) UseRevocationStore(store RevocationStore) {
+	manager.revocationStore = store
+}
+
+// UseApiKeyStore makes the interceptor accept API keys besides access tokens.
+func (manager *JWTManager) UseApiKeyStore(store ApiKeyStore) {
+	manager.apiKeyStore = store
+}
+
+// UseClaimsValidation sets the issuer and audience put into every token and
+// required when verifying one. clockSkew is the tolerance applied to the
+// exp, nbf and iat claims.
+func (manager *JWTManager) UseClaimsValidation(issuer string, audience string, clockSkew time.Duration) {
+	manager.issuer = issuer
+	manager.audience = audience
+	manager.clockSkew = clockSkew
+}
+
+// AcceptLegacyTokensUntil makes VerifyToken accept access tokens without
+// issuer and audience, as issued before UseClaimsValidation, until deadline.
+// The other claims of such tokens are still checked.
+func (manager *JWTManager) AcceptLegacyTokensUntil(deadline time.Time) {
+	manager.legacyTokensUntil = deadline
+}
+
+// UseKeySet switches token signing to the asymmetric keys of keySet. Tokens
+// signed with the secret key are still accepted while it is configured.
+func (manager *JWTManager) UseKeySet(keySet *KeySet) {
+	manager.keySet = keySet
+}
+
+// TokenDuration is how long an issued access token stays valid.
+func (manager *JWTManager) TokenDuration() time.Duration {
+	return manager.tokenDuration
+}
+
+// ClockSkew is how long after its expiry a token is still accepted.
+func (manager *JWTManager) ClockSkew() time.Duration {
+	return manager.clockSkew
+}
+
+// RevokeToken revokes a single access token until it expires.
+func (manager *JWTManager) RevokeToken(tokenID string, expiresAt time.Time) error {
+	if manager.revocationStore == nil {
+		return fmt.Errorf("no revocation store configured")
+	}
+	return manager.revocationStore.Revoke(tokenID, expiresAt)
+}
+
+// RefreshTokenDuration is how long an issued refresh token stays usable.
+func (manager *JWTManager) RefreshTokenDuration() time.Duration {
+	return manager.refreshTokenDuration
+}
+// GenerateToken issues an access token to the user for the session.
+func (manager *JWTManager) GenerateToken(user *model.User, sessionID string) (string, error) {
+	tokenID, err := GenerateID()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
 	claims := UserClaims{
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(manager.tokenDuration).Unix(),
+			Id:        tokenID,
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Issuer:    manager.issuer,
+			Audience:  manager.audience,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(manager.tokenDuration).Unix(),
 		},
-		UserEmail: user.Email,
-		UserRole:  user.Role,
+		UserEmail:       user.Email,
+		UserRole:        user.Role,
+		TokenGeneration: user.TokenGeneration,
+		Scopes:          userScopes(user),
+		SessionID:       sessionID,
+
+		EmailVerificationPending: user.EmailVerificationPending,
 	}
 	// creating new token...
+	return manager.sign(claims)
+}
+
+// sign signs claims with the current asymmetric key, or the secret key when
+// no key set is configured.
+func (manager *JWTManager) sign(claims jwt.Claims) (string, error) {
+	if manager.keySet != nil {
+		key := manager.keySet.current()
+		if key == nil {
+			return "", fmt.Errorf("no signing key available")
+		}
+		token := jwt.NewWithClaims(jwt.GetSigningMethod(key.algorithm), claims)
+		token.Header["kid"] = key.id
+		return token.SignedString(key.private)
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(manager.secretKey))
 }
-func VerifyToken(accessToken string) (*UserClaims, error) {
-	token, err := jwt.ParseWithClaims(
-		accessToken,
-		&UserClaims{},
-		func(token *jwt.Token) (interface{}, error) {
-			_, ok := token.Method.(*jwt.SigningMethodHMAC)
-			if !ok {
-				return nil, fmt.Errorf("unexpected token signing method")
+// userScopes collects the permissions of the user's roles, which have to be
+// preloaded by the caller.
+func userScopes(user *model.User) []string {
+	seen := map[string]bool{}
+	var scopes []string
+	for _, role := range user.Roles {
+		for _, permission := range role.Permissions {
+			if !seen[permission.Name] {
+				seen[permission.Name] = true
+				scopes = append(scopes, permission.Name)
 			}
-			return []byte(os.Getenv("SECRET_KEY")), nil
-		},
-	)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// GenerateRefreshToken creates a new opaque refresh token. Only the returned
+// hash should be stored, the token itself is handed to the client.
+func (manager *JWTManager) GenerateRefreshToken() (string, string, error) {
+	return GenerateOpaqueToken()
+}
+
+// GenerateOpaqueToken creates a random token that is handed to a client, like
+// a refresh token, a client secret or a single-use code. It returns the token
+// and its hash, only the hash should be stored.
+func GenerateOpaqueToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("could not generate token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken returns the value under which an opaque token is stored.
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateID returns a random identifier suitable for token families and token IDs.
+func GenerateID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not generate id: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+// keyFunc picks the verification key for a token, by its kid header for
+// asymmetric tokens and the secret key for HMAC tokens.
+func (manager *JWTManager) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if manager.secretKey == "" {
+			return nil, fmt.Errorf("unexpected token signing method")
+		}
+		return []byte(manager.secretKey), nil
+	}
+	if manager.keySet == nil {
+		return nil, fmt.Errorf("unexpected token signing method")
+	}
+	kid, _ := token.Header["kid"].(string)
+	key := manager.keySet.lookup(kid)
+	if key == nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.algorithm {
+		return nil, fmt.Errorf("unexpected token signing method")
+	}
+	return key.public, nil
+}
+func (manager *JWTManager) VerifyToken(accessToken string) (*UserClaims, error) {
+	// the time based claims are checked by validateClaims with clock skew
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(accessToken, &UserClaims{}, manager.keyFunc)
 	if err != nil {
 		return nil, fmt.Errorf("invalid token: %w", err)
 	}
@@ -62,33 +238,62 @@ func VerifyToken(accessToken string) (*UserClaims, error) {
 	if !ok {
 		return nil, fmt.Errorf("invalid token claims")
 	}
-
+	if manager.isLegacyToken(&claims.StandardClaims) {
+		err = manager.validateTimeClaims(&claims.StandardClaims)
+	} else {
+		err = manager.validateClaims(&claims.StandardClaims, manager.audience)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
 	return claims, nil
 }
-func UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
-	// skip the authentication for the health check endpoint
-	if info.FullMethod == "/userpb.UserService/AddUser" || 
-	info.FullMethod == "/userpb.UserService/AuthenticateUser" || 
-	info.FullMethod == "/userpb.UserService/PhoneVerification" {
-		return handler(ctx, req)
+
+// isLegacyToken tells if the token was issued without issuer and audience
+// and is still accepted without them.
+func (manager *JWTManager) isLegacyToken(claims *jwt.StandardClaims) bool {
+	return claims.Issuer == "" && claims.Audience == "" && time.Now().Before(manager.legacyTokensUntil)
+}
+
+func (manager *JWTManager) validateClaims(claims *jwt.StandardClaims, audience string) error {
+	if err := manager.validateTimeClaims(claims); err != nil {
+		return err
 	}
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return nil, status.Errorf(401, "metadata is not provided")
+	if manager.issuer != "" && claims.Issuer != manager.issuer {
+		return fmt.Errorf("unexpected token issuer %q", claims.Issuer)
 	}
-	tokenString := md.Get("authorization")
-	if len(tokenString) == 0 {
-		return nil, status.Errorf(401, "authorization token is not provided")
+	// action tokens carry their purpose as audience, so it is always compared
+	if claims.Audience != audience {
+		return fmt.Errorf("unexpected token audience %q", claims.Audience)
 	}
-	token := strings.Split(tokenString[0], " ")
-	// Parse JWT token
-	claims, err := VerifyToken(token[1])
-	if err != nil {
-		return nil, status.Errorf(401, "token is invalid: %v", err)
+	return nil
+}
+
+// validateTimeClaims checks the exp, nbf and iat claims with clock skew.
+func (manager *JWTManager) validateTimeClaims(claims *jwt.StandardClaims) error {
+	now := time.Now()
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("token has no expiry")
+	}
+	if now.Add(-manager.clockSkew).Unix() > claims.ExpiresAt {
+		return fmt.Errorf("token is expired")
+	}
+	if claims.NotBefore != 0 && now.Add(manager.clockSkew).Unix() < claims.NotBefore {
+		return fmt.Errorf("token is not valid yet")
+	}
+	if claims.IssuedAt != 0 && now.Add(manager.clockSkew).Unix() < claims.IssuedAt {
+		return fmt.Errorf("token was issued in the future")
+	}
+	return nil
+}
+// JWKSHandler serves the public verification keys so other services can
+// verify tokens without sharing the secret key.
+func (manager *JWTManager) JWKSHandler(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	jwks := JSONWebKeySet{Keys: []JSONWebKey{}}
+	if manager.keySet != nil {
+		jwks = manager.keySet.PublicKeys()
 	}
-	// Pass useremail to context for further use
-	ctx = context.WithValue(ctx, "userEmail", claims.UserEmail)
-	ctx = context.WithValue(ctx, "userRole", claims.UserRole)
-	// Proceed with the request
-	return handler(ctx, req)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(jwks)
 }
@@ -0,0 +1,166 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"auth-microservice/model"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestGenerateRefreshToken(t *testing.T) {
+	manager, err := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	assert.Nil(t, err)
+
+	token, hash, err := manager.GenerateRefreshToken()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, token)
+	assert.Equal(t, HashOpaqueToken(token), hash)
+	assert.NotEqual(t, token, hash)
+
+	other, _, err := manager.GenerateRefreshToken()
+	assert.Nil(t, err)
+	assert.NotEqual(t, token, other)
+	assert.Equal(t, 24*time.Hour, manager.RefreshTokenDuration())
+}
+
+type fakeRevocationStore struct {
+	revoked map[string]bool
+}
+
+func (store *fakeRevocationStore) Revoke(tokenID string, expiresAt time.Time) error {
+	if store.revoked[tokenID] {
+		return errors.New("duplicate token id")
+	}
+	store.revoked[tokenID] = true
+	return nil
+}
+
+func (store *fakeRevocationStore) IsRevoked(claims *UserClaims) (bool, error) {
+	return store.revoked[claims.Id], nil
+}
+
+func TestGenerateTokenSetsTokenID(t *testing.T) {
+	manager, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+
+	token, err := manager.GenerateToken(&model.User{Email: "user@example.com", Role: model.UserRole, TokenGeneration: 3}, "")
+	assert.Nil(t, err)
+	claims, err := manager.VerifyToken(token)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, claims.Id)
+	assert.Equal(t, uint(3), claims.TokenGeneration)
+}
+
+func TestUnaryInterceptorRejectsRevokedToken(t *testing.T) {
+	manager, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	manager.UseRevocationStore(&fakeRevocationStore{revoked: map[string]bool{}})
+
+	token, _ := manager.GenerateToken(&model.User{Email: "user@example.com", Role: model.UserRole}, "")
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	info := &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/GetUserDetails"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		principal, _ := FromContext(ctx)
+		return principal.TokenID, nil
+	}
+
+	interceptor := manager.UnaryServerInterceptor("/userpb.UserService/AddUser")
+	tokenID, err := interceptor(ctx, nil, info, handler)
+	assert.Nil(t, err)
+	assert.Nil(t, manager.RevokeToken(tokenID.(string), time.Now().Add(time.Hour)))
+
+	_, err = interceptor(ctx, nil, info, handler)
+	assert.NotNil(t, err)
+}
+
+func TestVerifyTokenValidatesClaims(t *testing.T) {
+	issuer, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	issuer.UseClaimsValidation("meal-mingle-auth", "meal-mingle", time.Minute)
+	token, err := issuer.GenerateToken(&model.User{Email: "user@example.com"}, "")
+	assert.Nil(t, err)
+	_, err = issuer.VerifyToken(token)
+	assert.Nil(t, err)
+
+	otherAudience, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	otherAudience.UseClaimsValidation("meal-mingle-auth", "orders", time.Minute)
+	_, err = otherAudience.VerifyToken(token)
+	assert.NotNil(t, err)
+
+	otherIssuer, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	otherIssuer.UseClaimsValidation("someone-else", "meal-mingle", time.Minute)
+	_, err = otherIssuer.VerifyToken(token)
+	assert.NotNil(t, err)
+}
+
+func TestVerifyTokenAcceptsLegacyTokensUntilDeadline(t *testing.T) {
+	legacy, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	token, err := legacy.GenerateToken(&model.User{Email: "user@example.com"}, "")
+	assert.Nil(t, err)
+
+	manager, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	manager.UseClaimsValidation("meal-mingle-auth", "meal-mingle", time.Minute)
+	_, err = manager.VerifyToken(token)
+	assert.NotNil(t, err)
+
+	manager.AcceptLegacyTokensUntil(time.Now().Add(time.Hour))
+	_, err = manager.VerifyToken(token)
+	assert.Nil(t, err)
+
+	// only tokens without both claims are legacy tokens
+	otherAudience, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	otherAudience.UseClaimsValidation("", "orders", time.Minute)
+	token, err = otherAudience.GenerateToken(&model.User{Email: "user@example.com"}, "")
+	assert.Nil(t, err)
+	_, err = manager.VerifyToken(token)
+	assert.NotNil(t, err)
+
+	manager.AcceptLegacyTokensUntil(time.Now().Add(-time.Minute))
+	token, err = legacy.GenerateToken(&model.User{Email: "user@example.com"}, "")
+	assert.Nil(t, err)
+	_, err = manager.VerifyToken(token)
+	assert.NotNil(t, err)
+}
+
+func TestVerifyTokenClockSkew(t *testing.T) {
+	manager, _ := NewJWTManager("secret", -30*time.Second, 24*time.Hour)
+	token, err := manager.GenerateToken(&model.User{Email: "user@example.com"}, "")
+	assert.Nil(t, err)
+	_, err = manager.VerifyToken(token)
+	assert.NotNil(t, err)
+
+	manager.UseClaimsValidation("", "", time.Minute)
+	_, err = manager.VerifyToken(token)
+	assert.Nil(t, err)
+}
+
+func TestGenerateTokenSetsSubject(t *testing.T) {
+	manager, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	user := &model.User{Email: "user@example.com"}
+	user.ID = 42
+
+	token, err := manager.GenerateToken(user, "")
+	assert.Nil(t, err)
+	claims, err := manager.VerifyToken(token)
+	assert.Nil(t, err)
+	assert.Equal(t, "42", claims.Subject)
+	assert.Equal(t, uint(42), principalFromClaims(claims).UserID)
+}
+
+func TestGenerateTokenIncludesRoleScopes(t *testing.T) {
+	manager, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	user := &model.User{Email: "staff@example.com", Roles: []model.Role{
+		{Name: "staff", Permissions: []model.Permission{{Name: "orders:read"}, {Name: "orders:write"}}},
+		{Name: "support", Permissions: []model.Permission{{Name: "orders:read"}, {Name: "tickets:read"}}},
+	}}
+
+	token, err := manager.GenerateToken(user, "")
+	assert.Nil(t, err)
+	claims, err := manager.VerifyToken(token)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"orders:read", "orders:write", "tickets:read"}, claims.Scopes)
+	assert.True(t, principalFromClaims(claims).HasScope("tickets:read"))
+}
@@ -0,0 +1,373 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const (
+	// keyReloadInterval is how often a rotating key set reads its directory
+	// again, to pick up keys rotated in by other processes sharing it
+	keyReloadInterval = time.Minute
+	// minKeyReloadInterval limits the reloads caused by tokens signed with an
+	// unknown key
+	minKeyReloadInterval = 10 * time.Second
+	// rotationLockTimeout is after how long the rotation lock of a process
+	// that died while rotating is ignored
+	rotationLockTimeout = time.Minute
+	rotationLockFile    = "rotation.lock"
+)
+
+// signingKey is a key loaded from the key directory. Keys without a private
+// part can only be used to verify tokens.
+type signingKey struct {
+	id        string
+	algorithm string
+	private   crypto.Signer
+	public    crypto.PublicKey
+	createdAt time.Time
+}
+
+// KeySet holds the asymmetric keys stored in a directory, one PEM file per key
+// named after its key id. The newest private key is used for signing, every
+// other key stays available for verification until it is pruned.
+type KeySet struct {
+	mu        sync.RWMutex
+	dir       string
+	algorithm string
+	keys      []*signingKey
+	loadedAt  time.Time
+}
+
+// LoadKeySet reads all keys from dir. If the directory has no usable signing
+// key for algorithm, a new one is generated.
+func LoadKeySet(dir string, algorithm string) (*KeySet, error) {
+	if algorithm != AlgorithmRS256 && algorithm != AlgorithmEdDSA {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create key directory: %w", err)
+	}
+	keySet := &KeySet{dir: dir, algorithm: algorithm}
+	if err := keySet.reload(); err != nil {
+		return nil, err
+	}
+	if keySet.current() == nil {
+		if err := keySet.Rotate(0); err != nil {
+			return nil, err
+		}
+	}
+	return keySet, nil
+}
+
+func (keySet *KeySet) reload() error {
+	files, err := filepath.Glob(filepath.Join(keySet.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	keys := make([]*signingKey, 0, len(files))
+	for _, file := range files {
+		key, err := readKeyFile(file)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].createdAt.Before(keys[j].createdAt) })
+	keySet.mu.Lock()
+	keySet.keys = keys
+	keySet.loadedAt = time.Now()
+	keySet.mu.Unlock()
+	return nil
+}
+
+// reloadIfStale reloads the keys unless they were loaded within
+// minKeyReloadInterval and reports whether it did.
+func (keySet *KeySet) reloadIfStale() bool {
+	keySet.mu.Lock()
+	if time.Since(keySet.loadedAt) < minKeyReloadInterval {
+		keySet.mu.Unlock()
+		return false
+	}
+	keySet.loadedAt = time.Now()
+	keySet.mu.Unlock()
+	return keySet.reload() == nil
+}
+
+func readKeyFile(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key %s: %w", file, err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not PEM encoded", file)
+	}
+	key := &signingKey{
+		id:        strings.TrimSuffix(filepath.Base(file), ".pem"),
+		createdAt: info.ModTime(),
+	}
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %s has unsupported PEM type %q", file, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse key %s: %w", file, err)
+	}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.algorithm, key.private, key.public = AlgorithmRS256, k, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.algorithm, key.private, key.public = AlgorithmEdDSA, k, k.Public()
+	case *rsa.PublicKey:
+		key.algorithm, key.public = AlgorithmRS256, k
+	case ed25519.PublicKey:
+		key.algorithm, key.public = AlgorithmEdDSA, k
+	default:
+		return nil, fmt.Errorf("key %s has unsupported key type %T", file, parsed)
+	}
+	return key, nil
+}
+
+// current returns the newest private key for the configured algorithm.
+func (keySet *KeySet) current() *signingKey {
+	keySet.mu.RLock()
+	defer keySet.mu.RUnlock()
+	for i := len(keySet.keys) - 1; i >= 0; i-- {
+		key := keySet.keys[i]
+		if key.private != nil && key.algorithm == keySet.algorithm {
+			return key
+		}
+	}
+	return nil
+}
+
+// lookup returns the key with id. Keys rotated in by another process sharing
+// the directory are not loaded yet, so a miss reloads the directory.
+func (keySet *KeySet) lookup(id string) *signingKey {
+	if key := keySet.find(id); key != nil {
+		return key
+	}
+	if keySet.reloadIfStale() {
+		return keySet.find(id)
+	}
+	return nil
+}
+
+func (keySet *KeySet) find(id string) *signingKey {
+	keySet.mu.RLock()
+	defer keySet.mu.RUnlock()
+	for _, key := range keySet.keys {
+		if key.id == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// Rotate generates a new signing key and removes keys that were replaced more
+// than retention ago. retention has to be at least the lifetime of the longest
+// lived token signed with the keys, or such tokens are rejected before they
+// expire.
+func (keySet *KeySet) Rotate(retention time.Duration) error {
+	id, err := GenerateID()
+	if err != nil {
+		return err
+	}
+	var private crypto.Signer
+	switch keySet.algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return fmt.Errorf("could not generate signing key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	// other processes sharing the directory must not read a partial key
+	file := filepath.Join(keySet.dir, id+".pem")
+	if err := os.WriteFile(file+".tmp", data, 0o600); err != nil {
+		return fmt.Errorf("could not write signing key: %w", err)
+	}
+	if err := os.Rename(file+".tmp", file); err != nil {
+		return fmt.Errorf("could not write signing key: %w", err)
+	}
+	if err := keySet.reload(); err != nil {
+		return err
+	}
+	if retention > 0 {
+		return keySet.prune(retention)
+	}
+	return nil
+}
+
+func (keySet *KeySet) prune(retention time.Duration) error {
+	keySet.mu.RLock()
+	keys := keySet.keys
+	keySet.mu.RUnlock()
+	pruned := false
+	// keys are sorted by creation, a key is retired once the next one exists
+	for i := 0; i < len(keys)-1; i++ {
+		if time.Since(keys[i+1].createdAt) > retention {
+			if err := os.Remove(filepath.Join(keySet.dir, keys[i].id+".pem")); err != nil {
+				return fmt.Errorf("could not remove retired key: %w", err)
+			}
+			pruned = true
+		}
+	}
+	if pruned {
+		return keySet.reload()
+	}
+	return nil
+}
+
+// rotateIfDue reloads the keys and rotates the signing key once it is older
+// than interval. Of the processes sharing the directory only the one holding
+// the rotation lock generates the key, the others load it with a later reload.
+func (keySet *KeySet) rotateIfDue(interval time.Duration, retention time.Duration) error {
+	if err := keySet.reload(); err != nil {
+		return err
+	}
+	if current := keySet.current(); current != nil && time.Since(current.createdAt) < interval {
+		return nil
+	}
+	unlock, locked, err := keySet.lockRotation()
+	if err != nil || !locked {
+		return err
+	}
+	defer unlock()
+	// another process may have rotated while the keys were read
+	if err := keySet.reload(); err != nil {
+		return err
+	}
+	if current := keySet.current(); current != nil && time.Since(current.createdAt) < interval {
+		return nil
+	}
+	return keySet.Rotate(retention)
+}
+
+// lockRotation creates the lock file of the directory. It reports false when
+// another process holds the lock.
+func (keySet *KeySet) lockRotation() (func(), bool, error) {
+	path := filepath.Join(keySet.dir, rotationLockFile)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if os.IsExist(err) {
+		info, statErr := os.Stat(path)
+		if statErr != nil || time.Since(info.ModTime()) < rotationLockTimeout {
+			return nil, false, nil
+		}
+		// the process holding the lock died while rotating
+		os.Remove(path)
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if os.IsExist(err) {
+			return nil, false, nil
+		}
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("could not lock key directory: %w", err)
+	}
+	file.Close()
+	return func() { os.Remove(path) }, true, nil
+}
+
+// StartRotation rotates the signing key every interval until ctx is done. The
+// directory is reloaded every keyReloadInterval in between, so every process
+// sharing it signs with the newest key.
+func (keySet *KeySet) StartRotation(ctx context.Context, interval time.Duration, retention time.Duration, onError func(error)) {
+	check := func() {
+		if err := keySet.rotateIfDue(interval, retention); err != nil && onError != nil {
+			onError(err)
+		}
+	}
+	check()
+	period := keyReloadInterval
+	if interval < period {
+		period = interval
+	}
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				check()
+			}
+		}
+	}()
+}
+
+// JSONWebKey is the public part of a key as published in the JWKS document.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JSONWebKeySet is the document served at /.well-known/jwks.json.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// PublicKeys returns every verification key in JWKS form.
+func (keySet *KeySet) PublicKeys() JSONWebKeySet {
+	keySet.mu.RLock()
+	defer keySet.mu.RUnlock()
+	jwks := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range keySet.keys {
+		jwk := JSONWebKey{KeyID: key.id, Use: "sig", Algorithm: key.algorithm}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
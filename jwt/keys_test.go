@@ -0,0 +1,84 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+
+	"auth-microservice/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAsymmetricSigning(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmRS256, AlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			keySet, err := LoadKeySet(t.TempDir(), algorithm)
+			assert.Nil(t, err)
+			manager, _ := NewJWTManager("", time.Hour, 24*time.Hour)
+			manager.UseKeySet(keySet)
+
+			token, err := manager.GenerateToken(&model.User{Email: "user@example.com", Role: model.UserRole}, "")
+			assert.Nil(t, err)
+			claims, err := manager.VerifyToken(token)
+			assert.Nil(t, err)
+			assert.Equal(t, "user@example.com", claims.UserEmail)
+
+			// tokens signed before a rotation stay valid
+			assert.Nil(t, keySet.Rotate(time.Hour))
+			_, err = manager.VerifyToken(token)
+			assert.Nil(t, err)
+
+			jwks := keySet.PublicKeys()
+			assert.Len(t, jwks.Keys, 2)
+			assert.Equal(t, algorithm, jwks.Keys[0].Algorithm)
+		})
+	}
+}
+
+func TestKeySetsSharingADirectory(t *testing.T) {
+	dir := t.TempDir()
+	rotating, err := LoadKeySet(dir, AlgorithmEdDSA)
+	require.Nil(t, err)
+	other, err := LoadKeySet(dir, AlgorithmEdDSA)
+	require.Nil(t, err)
+	signer, _ := NewJWTManager("", time.Hour, 24*time.Hour)
+	signer.UseKeySet(rotating)
+	verifier, _ := NewJWTManager("", time.Hour, 24*time.Hour)
+	verifier.UseKeySet(other)
+
+	// a key rotated in by another process is loaded on the first token it signed
+	require.Nil(t, rotating.Rotate(time.Hour))
+	token, err := signer.GenerateToken(&model.User{Email: "user@example.com", Role: model.UserRole}, "")
+	require.Nil(t, err)
+	other.loadedAt = time.Now().Add(-minKeyReloadInterval)
+	_, err = verifier.VerifyToken(token)
+	assert.Nil(t, err)
+
+	// the newest key is young enough, so the other process does not rotate
+	require.Nil(t, other.rotateIfDue(time.Hour, time.Hour))
+	assert.Len(t, other.PublicKeys().Keys, 2)
+
+	// only the process holding the lock rotates
+	unlock, locked, err := rotating.lockRotation()
+	require.Nil(t, err)
+	require.True(t, locked)
+	require.Nil(t, other.rotateIfDue(time.Nanosecond, time.Hour))
+	assert.Len(t, other.PublicKeys().Keys, 2)
+	unlock()
+	require.Nil(t, other.rotateIfDue(time.Nanosecond, time.Hour))
+	assert.Len(t, other.PublicKeys().Keys, 3)
+}
+
+func TestHMACTokenRejectedWithoutSecret(t *testing.T) {
+	hmacManager, _ := NewJWTManager("secret", time.Hour, 24*time.Hour)
+	token, err := hmacManager.GenerateToken(&model.User{Email: "user@example.com"}, "")
+	assert.Nil(t, err)
+
+	keySet, err := LoadKeySet(t.TempDir(), AlgorithmEdDSA)
+	assert.Nil(t, err)
+	manager, _ := NewJWTManager("", time.Hour, 24*time.Hour)
+	manager.UseKeySet(keySet)
+	_, err = manager.VerifyToken(token)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,86 @@
+package jwt
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Principal is the authenticated caller of an RPC, as established by the
+// interceptor from the access token.
+type Principal struct {
+	// UserID is zero for tokens that do not carry a subject and for service
+	// clients
+	UserID uint
+	// ClientID is set instead of the user for service clients
+	ClientID string
+	// ApiKeyID is set when the user called with an API key instead of a token
+	ApiKeyID uint
+	// SessionID is the login the access token belongs to, it is empty for
+	// API keys, service clients and tokens issued before sessions were kept
+	SessionID      string
+	Email          string
+	Role           string
+	TokenID        string
+	TokenExpiresAt time.Time
+	AuthTime       time.Time
+	Scopes         []string
+	EmailVerified  bool
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal.
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal stored in ctx by the interceptor.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// IsService reports whether the caller is a service client rather than a user.
+func (principal *Principal) IsService() bool {
+	return principal.ClientID != ""
+}
+
+// IsApiKey reports whether the user called with an API key.
+func (principal *Principal) IsApiKey() bool {
+	return principal.ApiKeyID != 0
+}
+
+// HasScope reports whether the principal was granted scope.
+func (principal *Principal) HasScope(scope string) bool {
+	for _, granted := range principal.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func principalFromClaims(claims *UserClaims) *Principal {
+	if claims.SubjectType == SubjectTypeService {
+		return &Principal{
+			ClientID:       claims.Subject,
+			TokenID:        claims.Id,
+			TokenExpiresAt: time.Unix(claims.ExpiresAt, 0),
+			AuthTime:       time.Unix(claims.IssuedAt, 0),
+			Scopes:         claims.Scopes,
+		}
+	}
+	userID, _ := strconv.ParseUint(claims.Subject, 10, 64)
+	return &Principal{
+		UserID:         uint(userID),
+		Email:          claims.UserEmail,
+		Role:           claims.UserRole,
+		TokenID:        claims.Id,
+		TokenExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		AuthTime:       time.Unix(claims.IssuedAt, 0),
+		Scopes:         claims.Scopes,
+		EmailVerified:  !claims.EmailVerificationPending,
+		SessionID:      claims.SessionID,
+	}
+}
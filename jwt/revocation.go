@@ -0,0 +1,100 @@
+package jwt
+
+import (
+	"time"
+
+	"auth-microservice/model"
+
+	"gorm.io/gorm"
+)
+
+// RevocationStore keeps track of access tokens that must no longer be accepted
+// even though they have not expired yet.
+type RevocationStore interface {
+	Revoke(tokenID string, expiresAt time.Time) error
+	IsRevoked(claims *UserClaims) (bool, error)
+}
+
+type gormRevocationStore struct {
+	db *gorm.DB
+	// lastSeenInterval limits how often the last use of a session is written
+	lastSeenInterval time.Duration
+}
+
+// NewGormRevocationStore returns a RevocationStore backed by the revoked_tokens
+// table, the sessions table and the users' token generation counter.
+func NewGormRevocationStore(db *gorm.DB) RevocationStore {
+	return &gormRevocationStore{db: db, lastSeenInterval: time.Minute}
+}
+
+func (store *gormRevocationStore) Revoke(tokenID string, expiresAt time.Time) error {
+	return store.db.Create(&model.RevokedToken{TokenID: tokenID, ExpiresAt: expiresAt}).Error
+}
+
+func (store *gormRevocationStore) IsRevoked(claims *UserClaims) (bool, error) {
+	if claims.Id != "" {
+		var count int64
+		err := store.db.Model(&model.RevokedToken{}).Where("token_id = ?", claims.Id).Count(&count).Error
+		if err != nil {
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+	if claims.SessionID != "" {
+		revoked, err := store.isSessionRevoked(claims.SessionID)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+	if claims.SubjectType == SubjectTypeService {
+		// deleting a service client or rotating its secret revokes its tokens
+		var client model.ServiceClient
+		err := store.db.Select("secret_rotated_at").Where("client_id = ?", claims.Subject).First(&client).Error
+		if err == gorm.ErrRecordNotFound {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return client.SecretRotatedAt != nil && claims.IssuedAt < client.SecretRotatedAt.Unix(), nil
+	}
+	var user model.User
+	query := store.db.Select("token_generation")
+	if claims.Subject != "" {
+		query = query.Where("id = ?", claims.Subject)
+	} else {
+		// tokens issued before the sub claim only identify the user by email
+		query = query.Where("email = ?", claims.UserEmail)
+	}
+	err := query.First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return claims.TokenGeneration < user.TokenGeneration, nil
+}
+
+// isSessionRevoked checks the session of a token and records that it was
+// seen. A session that does not exist anymore counts as revoked.
+func (store *gormRevocationStore) isSessionRevoked(sessionID string) (bool, error) {
+	var session model.Session
+	err := store.db.Select("revoked_at").Where("session_id = ?", sessionID).First(&session).Error
+	if err == gorm.ErrRecordNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if session.RevokedAt != nil {
+		return true, nil
+	}
+	now := time.Now()
+	err = store.db.Model(&model.Session{}).
+		Where("session_id = ? AND last_seen_at < ?", sessionID, now.Add(-store.lastSeenInterval)).
+		Update("last_seen_at", now).Error
+	return false, err
+}
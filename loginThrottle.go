@@ -0,0 +1,188 @@
+package main
+
+import (
+	"auth-microservice/model"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// loginThrottle slows down password guessing. Every failed login of an
+// account or client address doubles the wait before the next attempt, after
+// maxFailures the account or address is locked for lockoutDuration. The
+// counters are stored in the database so they are shared by all replicas.
+type loginThrottle struct {
+	maxFailures      int
+	maxFailuresPerIP int
+	baseDelay        time.Duration
+	lockoutDuration  time.Duration
+	// failures older than failureWindow are forgotten
+	failureWindow time.Duration
+}
+
+func defaultLoginThrottle() *loginThrottle {
+	return &loginThrottle{
+		maxFailures:      5,
+		maxFailuresPerIP: 20,
+		baseDelay:        time.Second,
+		lockoutDuration:  15 * time.Minute,
+		failureWindow:    24 * time.Hour,
+	}
+}
+
+// newLoginThrottle reads the LOGIN_* variables on top of the defaults.
+func newLoginThrottle() (*loginThrottle, error) {
+	throttle := defaultLoginThrottle()
+	for key, value := range map[string]*int{
+		"LOGIN_MAX_FAILURES":        &throttle.maxFailures,
+		"LOGIN_MAX_FAILURES_PER_IP": &throttle.maxFailuresPerIP,
+	} {
+		if env := os.Getenv(key); env != "" {
+			parsed, err := strconv.Atoi(env)
+			if err != nil || parsed < 1 {
+				return nil, fmt.Errorf("invalid %s %q", key, env)
+			}
+			*value = parsed
+		}
+	}
+	for key, value := range map[string]*time.Duration{
+		"LOGIN_BACKOFF_BASE":     &throttle.baseDelay,
+		"LOGIN_LOCKOUT_DURATION": &throttle.lockoutDuration,
+		"LOGIN_FAILURE_WINDOW":   &throttle.failureWindow,
+	} {
+		if env := os.Getenv(key); env != "" {
+			parsed, err := time.ParseDuration(env)
+			if err != nil || parsed < 0 {
+				return nil, fmt.Errorf("invalid %s %q", key, env)
+			}
+			*value = parsed
+		}
+	}
+	return throttle, nil
+}
+
+func accountThrottleKey(userEmail string) string {
+	return "account:" + strings.ToLower(userEmail)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginThrottledMessage tells a throttled user how long to wait.
+func loginThrottledMessage(wait time.Duration, locked bool) string {
+	if locked {
+		return fmt.Sprintf("Too many failed login attempts, the account is locked. Please try again in %d minutes.",
+			int(math.Ceil(wait.Minutes())))
+	}
+	return fmt.Sprintf("Too many failed login attempts. Please try again in %d seconds.", int(math.Ceil(wait.Seconds())))
+}
+
+// blocked returns how long the key has to wait before the next attempt and
+// whether it reached the lockout threshold.
+func (throttle *loginThrottle) blocked(key string) (time.Duration, bool, error) {
+	var record model.LoginThrottle
+	err := userDbConnector.Where("throttle_key = ?", key).First(&record).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	wait := time.Until(record.BlockedUntil)
+	if wait <= 0 {
+		return 0, false, nil
+	}
+	return wait, record.Failures >= throttle.limit(key), nil
+}
+
+// check returns the longest wait of keys and whether one of them is locked.
+func (throttle *loginThrottle) check(keys ...string) (time.Duration, bool, error) {
+	var longest time.Duration
+	anyLocked := false
+	for _, key := range keys {
+		wait, locked, err := throttle.blocked(key)
+		if err != nil {
+			return 0, false, err
+		}
+		if wait > longest {
+			longest = wait
+		}
+		anyLocked = anyLocked || locked
+	}
+	return longest, anyLocked, nil
+}
+
+func (throttle *loginThrottle) limit(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return throttle.maxFailuresPerIP
+	}
+	return throttle.maxFailures
+}
+
+// delay is the wait after the given number of consecutive failures.
+func (throttle *loginThrottle) delay(failures int, limit int) time.Duration {
+	if failures >= limit {
+		return throttle.lockoutDuration
+	}
+	if failures < 2 || throttle.baseDelay == 0 {
+		return 0
+	}
+	delay := throttle.baseDelay << (failures - 2)
+	if delay <= 0 || delay > throttle.lockoutDuration {
+		return throttle.lockoutDuration
+	}
+	return delay
+}
+
+// recordFailure counts a failed login for key and blocks it for the backoff delay.
+func (throttle *loginThrottle) recordFailure(key string) error {
+	now := time.Now()
+	return userDbConnector.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&model.LoginThrottle{}).
+			Where("throttle_key = ? AND last_failure_at < ?", key, now.Add(-throttle.failureWindow)).
+			Update("failures", 0).Error
+		if err != nil {
+			return err
+		}
+		// the counter is incremented in the database, so concurrent failures on
+		// other replicas are all counted
+		err = tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "throttle_key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures":        gorm.Expr("failures + 1"),
+				"last_failure_at": now,
+			}),
+		}).Create(&model.LoginThrottle{ThrottleKey: key, Failures: 1, LastFailureAt: now}).Error
+		if err != nil {
+			return err
+		}
+		var record model.LoginThrottle
+		if err := tx.Where("throttle_key = ?", key).First(&record).Error; err != nil {
+			return err
+		}
+		return tx.Model(&record).Update("blocked_until", now.Add(throttle.delay(record.Failures, throttle.limit(key)))).Error
+	})
+}
+
+// recordFailures counts a failed login for every key, errors are only logged
+// so they do not hide the login result.
+func (throttle *loginThrottle) recordFailures(keys ...string) {
+	for _, key := range keys {
+		if err := throttle.recordFailure(key); err != nil {
+			logger.Error("Failed to record login failure", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+// reset forgets the failures of key.
+func (throttle *loginThrottle) reset(key string) error {
+	return userDbConnector.Where("throttle_key = ?", key).Delete(&model.LoginThrottle{}).Error
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"auth-microservice/config"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginThrottleDelay(t *testing.T) {
+	throttle := defaultLoginThrottle()
+
+	assert.Equal(t, time.Duration(0), throttle.delay(1, 5))
+	assert.Equal(t, time.Second, throttle.delay(2, 5))
+	assert.Equal(t, 2*time.Second, throttle.delay(3, 5))
+	assert.Equal(t, 4*time.Second, throttle.delay(4, 5))
+	assert.Equal(t, throttle.lockoutDuration, throttle.delay(5, 5))
+	assert.Equal(t, throttle.lockoutDuration, throttle.delay(15, 20))
+}
+
+func TestAuthenticateUserLockout(t *testing.T) {
+	service, _ := newTestUserService(t)
+	// no backoff between attempts, only the lockout
+	service.loginThrottle.baseDelay = 0
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210",
+		Password: hashPassword(t, "correct-password"), Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	wrong := &userpb.AuthenticateUserRequest{UserEmail: user.Email, UserPassword: "wrong-password", Role: model.UserRole}
+	correct := &userpb.AuthenticateUserRequest{UserEmail: user.Email, UserPassword: "correct-password", Role: model.UserRole}
+
+	for i := 0; i < service.loginThrottle.maxFailures; i++ {
+		response, err := service.AuthenticateUser(contextFromIP("203.0.113.7"), wrong)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(StatusUnauthorized), response.StatusCode)
+	}
+	// the right password is not even checked while locked, from any address
+	response, err := service.AuthenticateUser(contextFromIP("198.51.100.1"), correct)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusTooManyRequests), response.StatusCode)
+
+	unlock, err := service.UnlockUser(contextFromIP("198.51.100.1"), &userpb.UnlockUserRequest{UserId: strconv.FormatUint(uint64(user.ID), 10)})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), unlock.StatusCode)
+
+	response, err = service.AuthenticateUser(contextFromIP("198.51.100.1"), correct)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusCreated), response.StatusCode)
+}
+
+func TestAuthenticateUserBackoff(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210",
+		Password: hashPassword(t, "correct-password"), Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	wrong := &userpb.AuthenticateUserRequest{UserEmail: user.Email, UserPassword: "wrong-password", Role: model.UserRole}
+
+	response, err := service.AuthenticateUser(contextFromIP("203.0.113.7"), wrong)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), response.StatusCode)
+	response, err = service.AuthenticateUser(contextFromIP("203.0.113.7"), wrong)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), response.StatusCode)
+
+	// the second failure makes the next attempt wait
+	response, err = service.AuthenticateUser(contextFromIP("203.0.113.7"), wrong)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusTooManyRequests), response.StatusCode)
+
+	var record model.LoginThrottle
+	require.Nil(t, userDbConnector.Where("throttle_key = ?", accountThrottleKey(user.Email)).First(&record).Error)
+	assert.Equal(t, 2, record.Failures)
+}
+
+func TestAuthenticateUserIPLockout(t *testing.T) {
+	service, _ := newTestUserService(t)
+	service.loginThrottle.baseDelay = 0
+	service.loginThrottle.maxFailuresPerIP = 3
+	ctx := contextFromIP("203.0.113.7")
+
+	// guessing different accounts from one address
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		response, err := service.AuthenticateUser(ctx, &userpb.AuthenticateUserRequest{
+			UserEmail: email, UserPassword: "wrong-password", Role: model.UserRole})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(StatusNotFound), response.StatusCode)
+	}
+	response, err := service.AuthenticateUser(ctx, &userpb.AuthenticateUserRequest{
+		UserEmail: "d@example.com", UserPassword: "wrong-password", Role: model.UserRole})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusTooManyRequests), response.StatusCode)
+}
+
+func TestSeedRolesGrantsUnlock(t *testing.T) {
+	newTestUserService(t)
+	require.Nil(t, config.SeedRoles(userDbConnector))
+	require.Nil(t, config.SeedRoles(userDbConnector))
+
+	var role model.Role
+	require.Nil(t, userDbConnector.Preload("Permissions").Where("name = ?", model.RoleAdminRole).First(&role).Error)
+	var names []string
+	for _, permission := range role.Permissions {
+		names = append(names, permission.Name)
+	}
+	assert.ElementsMatch(t, []string{model.ManageRolesPermission, model.UnlockUsersPermission,
+		model.ManageServiceClientsPermission, model.ManageOAuthClientsPermission}, names)
+
+	var count int64
+	require.Nil(t, userDbConnector.Model(&model.Permission{}).Where("name = ?", model.ReadUsersPermission).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"auth-microservice/config"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	otpPurposeLogin = "login"
+
+	// A phone can request otpPhoneLimit codes of a purpose per otpPhoneWindow
+	otpPhoneLimit  = 3
+	otpPhoneWindow = 15 * time.Minute
+	// A client address can request otpIPLimit codes of a purpose per otpIPWindow
+	otpIPLimit  = 20
+	otpIPWindow = time.Hour
+)
+
+// otpRateLimited reports whether phone or requestIP requested too many codes
+// for purpose recently. Otherwise the request is counted, whether or not a
+// code is sent for it.
+func otpRateLimited(phone string, purpose string, requestIP string) (bool, error) {
+	var count int64
+	err := userDbConnector.Model(&model.OtpRequest{}).
+		Where("phone = ? AND purpose = ? AND created_at > ?", phone, purpose, time.Now().Add(-otpPhoneWindow)).
+		Count(&count).Error
+	if err != nil || count >= otpPhoneLimit {
+		return err == nil, err
+	}
+	if requestIP != "" {
+		err = userDbConnector.Model(&model.OtpRequest{}).
+			Where("request_ip = ? AND purpose = ? AND created_at > ?", requestIP, purpose, time.Now().Add(-otpIPWindow)).
+			Count(&count).Error
+		if err != nil || count >= otpIPLimit {
+			return err == nil, err
+		}
+	}
+	return false, userDbConnector.Create(&model.OtpRequest{Phone: phone, Purpose: purpose, RequestIP: requestIP}).Error
+}
+
+func (userServiceManager *UserService) RequestLoginOtp(ctx context.Context, request *userpb.RequestLoginOtpRequest) (*userpb.RequestLoginOtpResponse, error) {
+	phone := request.Phone
+	requestIP := clientIP(ctx)
+	logger.Info("Received RequestLoginOtp request", zap.String("phone", phone), zap.String("ip", requestIP))
+	if !config.ValidatePhone(phone) {
+		logger.Warn("Invalid phone number", zap.String("phone", phone))
+		return &userpb.RequestLoginOtpResponse{
+			Data:       nil,
+			Message:    "Invalid phone number. Phone number can only be 10 digits long",
+			Error:      "Invalid Phone",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	limited, err := otpRateLimited(phone, otpPurposeLogin, requestIP)
+	if err != nil {
+		logger.Error("Failed to check login otp rate limit", zap.String("phone", phone), zap.Error(err))
+		return &userpb.RequestLoginOtpResponse{
+			Data:       nil,
+			Message:    "Failed to send the code, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if limited {
+		logger.Warn("Too many login otp requests", zap.String("phone", phone), zap.String("ip", requestIP))
+		return &userpb.RequestLoginOtpResponse{
+			Data:       nil,
+			Message:    "Too many codes requested, Please try again later.",
+			Error:      "Too Many Requests",
+			StatusCode: StatusTooManyRequests,
+		}, nil
+	}
+	var user model.User
+	err = userDbConnector.Where("phone = ?", phone).First(&user).Error
+	if userServiceManager.hardenedAuth {
+		// answer the same, and as fast, whether or not the phone number is
+		// registered, the code is sent after the response
+		if err != nil {
+			logger.Warn("Phone number not registered", zap.String("phone", phone), zap.Error(err))
+			return loginOtpSentResponse(phone), nil
+		}
+		userServiceManager.deliverInBackground(ctx, "login otp", func(ctx context.Context) error {
+			return userServiceManager.issuePhoneOtp(ctx, phone, otpPurposeLogin, requestIP)
+		})
+		return loginOtpSentResponse(phone), nil
+	}
+	if err != nil {
+		logger.Warn("Phone number not registered", zap.String("phone", phone), zap.Error(err))
+		return &userpb.RequestLoginOtpResponse{
+			Data:       nil,
+			Message:    "Phone number is not registered",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if err := userServiceManager.issuePhoneOtp(ctx, phone, otpPurposeLogin, requestIP); err != nil {
+		logger.Error("Failed to send login otp", zap.String("phone", phone), zap.Error(err))
+		return &userpb.RequestLoginOtpResponse{
+			Data:       nil,
+			Message:    "Failed to send the code, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Login otp sent", zap.String("phone", phone))
+	return loginOtpSentResponse(phone), nil
+}
+
+func loginOtpSentResponse(phone string) *userpb.RequestLoginOtpResponse {
+	return &userpb.RequestLoginOtpResponse{
+		Data: &userpb.SendPhoneOtpResponseData{
+			Phone:            phone,
+			ExpiresInSeconds: int64(otpDuration.Seconds()),
+		},
+		Message:    "Login code sent",
+		Error:      "",
+		StatusCode: StatusOK,
+	}
+}
+
+func (userServiceManager *UserService) LoginWithOtp(ctx context.Context, request *userpb.LoginWithOtpRequest) (*userpb.LoginWithOtpResponse, error) {
+	phone := request.Phone
+	logger.Info("Received LoginWithOtp request", zap.String("phone", phone))
+	if !config.ValidatePhone(phone) || len(request.Code) != otpLength {
+		logger.Warn("Invalid request fields", zap.String("phone", phone))
+		return &userpb.LoginWithOtpResponse{
+			Data:       nil,
+			Message:    fmt.Sprintf("The request needs a 10 digit phone number and a %d digit code.", otpLength),
+			Error:      "Invalid fields!",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	err := userServiceManager.checkPhoneOtp(phone, otpPurposeLogin, request.Code)
+	if err == errOtpInvalid || err == errOtpTooManyAttempts || err == errOtpNotFoundOrExpiry {
+		logger.Warn("Login otp verification failed", zap.String("phone", phone), zap.Error(err))
+		return &userpb.LoginWithOtpResponse{
+			Data:       nil,
+			Message:    otpErrorMessage(err),
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	var user model.User
+	if err == nil {
+		err = userDbConnector.Where("phone = ?", phone).First(&user).Error
+	}
+	// the code was delivered to the phone, so it is verified now
+	if err == nil && !user.PhoneVerified {
+		err = userDbConnector.Model(&user).Update("phone_verified", true).Error
+	}
+	if err != nil {
+		logger.Error("Failed to login with otp", zap.String("phone", phone), zap.Error(err))
+		return &userpb.LoginWithOtpResponse{
+			Data:       nil,
+			Message:    "Failed to login, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	// the code proves the phone, users with two-factor authentication still
+	// need their authenticator
+	if user.TotpEnabled {
+		mfaToken, err := userServiceManager.issueMfaChallenge(&user)
+		if err != nil {
+			logger.Error("Error in generating mfa token", zap.String("phone", phone), zap.Error(err))
+			return &userpb.LoginWithOtpResponse{
+				Data:       nil,
+				Message:    "Security Issues, Please try again later.",
+				Error:      "Internal Server Error",
+				StatusCode: StatusInternalServerError,
+			}, nil
+		}
+		logger.Info("Otp accepted, second factor required", zap.String("userEmail", user.Email))
+		return &userpb.LoginWithOtpResponse{
+			Data:       nil,
+			Message:    "Two-factor authentication required, Please send a code with the mfa token.",
+			Error:      "",
+			StatusCode: StatusOK,
+			MfaToken:   mfaToken,
+		}, nil
+	}
+	data, err := userServiceManager.issueTokens(ctx, &user, "")
+	if err != nil {
+		logger.Error("Error in generating token", zap.String("phone", phone), zap.Error(err))
+		return &userpb.LoginWithOtpResponse{
+			Data:       nil,
+			Message:    "Security Issues, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("User authenticated with otp successfully", zap.String("userEmail", user.Email))
+	return &userpb.LoginWithOtpResponse{
+		Data:       data,
+		Message:    "User authenticated successfully",
+		Error:      "",
+		StatusCode: StatusCreated,
+	}, nil
+}
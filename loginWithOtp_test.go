@@ -0,0 +1,138 @@
+package main
+
+import (
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestLoginWithOtp(t *testing.T) {
+	service, sender := newTestUserService(t)
+	user := model.User{Name: "partner", Email: "partner@example.com", Phone: "9876543210", Role: "delivery"}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	ctx := contextFromIP("203.0.113.7")
+
+	response, err := service.RequestLoginOtp(ctx, &userpb.RequestLoginOtpRequest{Phone: user.Phone})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), response.StatusCode)
+	code := lastOtpCode(t, sender, user.Phone)
+
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+	login, err := service.LoginWithOtp(ctx, &userpb.LoginWithOtpRequest{Phone: user.Phone, Code: wrongCode})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), login.StatusCode)
+
+	login, err = service.LoginWithOtp(ctx, &userpb.LoginWithOtpRequest{Phone: user.Phone, Code: code})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusCreated), login.StatusCode)
+	assert.NotEmpty(t, login.Data.Token)
+	assert.NotEmpty(t, login.Data.RefreshToken)
+	assert.Equal(t, user.Email, login.Data.User.UserEmail)
+
+	var stored model.User
+	require.Nil(t, userDbConnector.First(&stored, user.ID).Error)
+	assert.True(t, stored.PhoneVerified)
+
+	// a code can only be used once
+	login, err = service.LoginWithOtp(ctx, &userpb.LoginWithOtpRequest{Phone: user.Phone, Code: code})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), login.StatusCode)
+}
+
+func TestLoginWithOtpTooManyAttempts(t *testing.T) {
+	service, sender := newTestUserService(t)
+	user := model.User{Name: "partner", Email: "partner@example.com", Phone: "9876543210", Role: "delivery"}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	ctx := contextFromIP("203.0.113.7")
+
+	_, err := service.RequestLoginOtp(ctx, &userpb.RequestLoginOtpRequest{Phone: user.Phone})
+	require.Nil(t, err)
+	code := lastOtpCode(t, sender, user.Phone)
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+	for i := 0; i < maxOtpAttempts; i++ {
+		service.LoginWithOtp(ctx, &userpb.LoginWithOtpRequest{Phone: user.Phone, Code: wrongCode})
+	}
+	login, err := service.LoginWithOtp(ctx, &userpb.LoginWithOtpRequest{Phone: user.Phone, Code: code})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), login.StatusCode)
+	assert.Equal(t, otpErrorMessage(errOtpTooManyAttempts), login.Message)
+}
+
+func TestRequestLoginOtpRateLimit(t *testing.T) {
+	service, sender := newTestUserService(t)
+	user := model.User{Name: "partner", Email: "partner@example.com", Phone: "9876543210", Role: "delivery"}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+
+	for i := 0; i < otpPhoneLimit; i++ {
+		ctx := contextFromIP(fmt.Sprintf("203.0.113.%d", i+1))
+		response, err := service.RequestLoginOtp(ctx, &userpb.RequestLoginOtpRequest{Phone: user.Phone})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(StatusOK), response.StatusCode)
+	}
+	response, err := service.RequestLoginOtp(contextFromIP("198.51.100.1"), &userpb.RequestLoginOtpRequest{Phone: user.Phone})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusTooManyRequests), response.StatusCode)
+	assert.Len(t, sender.Messages(user.Phone), otpPhoneLimit)
+
+	// only the latest code is valid
+	code := lastOtpCode(t, sender, user.Phone)
+	login, err := service.LoginWithOtp(context.Background(), &userpb.LoginWithOtpRequest{Phone: user.Phone, Code: code})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusCreated), login.StatusCode)
+}
+
+func TestRequestLoginOtpRateLimitPerIP(t *testing.T) {
+	service, _ := newTestUserService(t)
+	ctx := contextFromIP("203.0.113.7")
+	// requests for unregistered phones count as well
+	for i := 0; i < otpIPLimit; i++ {
+		response, err := service.RequestLoginOtp(ctx, &userpb.RequestLoginOtpRequest{Phone: fmt.Sprintf("90000000%02d", i)})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(StatusNotFound), response.StatusCode)
+	}
+	response, err := service.RequestLoginOtp(ctx, &userpb.RequestLoginOtpRequest{Phone: "9876543210"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusTooManyRequests), response.StatusCode)
+
+	response, err = service.RequestLoginOtp(contextFromIP("198.51.100.1"), &userpb.RequestLoginOtpRequest{Phone: "9876543210"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), response.StatusCode)
+}
+
+func TestRequestLoginOtpRateLimitUnknownPhone(t *testing.T) {
+	service, _ := newTestUserService(t)
+	for i := 0; i < otpPhoneLimit; i++ {
+		ctx := contextFromIP(fmt.Sprintf("203.0.113.%d", i+1))
+		response, err := service.RequestLoginOtp(ctx, &userpb.RequestLoginOtpRequest{Phone: "9123456789"})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(StatusNotFound), response.StatusCode)
+	}
+	response, err := service.RequestLoginOtp(contextFromIP("198.51.100.1"), &userpb.RequestLoginOtpRequest{Phone: "9123456789"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusTooManyRequests), response.StatusCode)
+}
+
+func TestClientIP(t *testing.T) {
+	assert.Equal(t, "203.0.113.7", clientIP(contextFromIP("203.0.113.7")))
+	assert.Equal(t, "", clientIP(context.Background()))
+
+	// behind the gateway only the address it appended is trusted
+	ctx := metadata.NewIncomingContext(contextFromIP("127.0.0.1"),
+		metadata.Pairs("x-forwarded-for", "10.0.0.1, 198.51.100.4"))
+	assert.Equal(t, "198.51.100.4", clientIP(ctx))
+	ctx = metadata.NewIncomingContext(contextFromIP("203.0.113.7"),
+		metadata.Pairs("x-forwarded-for", "198.51.100.4"))
+	assert.Equal(t, "203.0.113.7", clientIP(ctx))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// revokeAllSessions invalidates every access and refresh token issued to the user.
+func revokeAllSessions(userID uint) error {
+	return userDbConnector.Transaction(func(tx *gorm.DB) error {
+		return revokeAllSessionsIn(tx, userID)
+	})
+}
+
+// revokeAllSessionsIn revokes the sessions like revokeAllSessions, as part of
+// the transaction tx.
+func revokeAllSessionsIn(tx *gorm.DB, userID uint) error {
+	err := tx.Model(&model.User{}).Where("id = ?", userID).
+		Update("token_generation", gorm.Expr("token_generation + 1")).Error
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = tx.Model(&model.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&model.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error
+}
+
+func (userServiceManager *UserService) Logout(ctx context.Context, request *userpb.LogoutRequest) (*userpb.LogoutResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.LogoutResponse{
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received Logout request", zap.String("userEmail", userEmail), zap.Bool("allSessions", request.AllSessions))
+
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.LogoutResponse{
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if request.AllSessions {
+		if err := revokeAllSessions(user.ID); err != nil {
+			logger.Error("Failed to revoke sessions", zap.String("userEmail", userEmail), zap.Error(err))
+			return &userpb.LogoutResponse{
+				Message:    "Failed to logout, Please try again later.",
+				Error:      "Internal Server Error",
+				StatusCode: StatusInternalServerError,
+			}, nil
+		}
+		logger.Info("Logged out of all sessions", zap.String("userEmail", userEmail))
+		return &userpb.LogoutResponse{
+			Message:    "Logged out of all sessions successfully",
+			Error:      "",
+			StatusCode: StatusOK,
+		}, nil
+	}
+
+	if principal.TokenID != "" {
+		if err := userServiceManager.jwtManager.RevokeToken(principal.TokenID, principal.TokenExpiresAt); err != nil {
+			logger.Error("Failed to revoke token", zap.String("userEmail", userEmail), zap.Error(err))
+			return &userpb.LogoutResponse{
+				Message:    "Failed to logout, Please try again later.",
+				Error:      "Internal Server Error",
+				StatusCode: StatusInternalServerError,
+			}, nil
+		}
+	}
+	// the session of the token ends with it, even without the refresh token
+	if principal.SessionID != "" {
+		if err := revokeRefreshTokenFamily(principal.SessionID); err != nil {
+			logger.Error("Failed to revoke session", zap.String("userEmail", userEmail), zap.Error(err))
+			return &userpb.LogoutResponse{
+				Message:    "Failed to logout, Please try again later.",
+				Error:      "Internal Server Error",
+				StatusCode: StatusInternalServerError,
+			}, nil
+		}
+	}
+	if request.RefreshToken != "" {
+		var stored model.RefreshToken
+		err := userDbConnector.Where("token_hash = ? AND user_id = ?", jwt.HashOpaqueToken(request.RefreshToken), user.ID).
+			First(&stored).Error
+		if err == nil {
+			err = revokeRefreshTokenFamily(stored.FamilyID)
+		}
+		if err != nil && err != gorm.ErrRecordNotFound {
+			logger.Error("Failed to revoke refresh token", zap.String("userEmail", userEmail), zap.Error(err))
+			return &userpb.LogoutResponse{
+				Message:    "Failed to logout, Please try again later.",
+				Error:      "Internal Server Error",
+				StatusCode: StatusInternalServerError,
+			}, nil
+		}
+	}
+	logger.Info("User logged out successfully", zap.String("userEmail", userEmail))
+	return &userpb.LogoutResponse{
+		Message:    "Logged out successfully",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
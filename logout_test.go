@@ -0,0 +1,60 @@
+package main
+
+import (
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestLogoutRevokesTokenAndSession(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	laptop, laptopCtx := loginFrom(t, service, &user, "Laptop", "203.0.113.7")
+	phone, _ := loginFrom(t, service, &user, "Phone", "198.51.100.4")
+
+	loggedOut, err := service.Logout(laptopCtx, &userpb.LogoutRequest{RefreshToken: laptop.RefreshToken})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), loggedOut.StatusCode)
+
+	var count int64
+	require.Nil(t, userDbConnector.Model(&model.RevokedToken{}).Where("token_id = ?", jwtPrincipal(t, laptopCtx).TokenID).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+	_, err = callWithMetadata(t, service, metadata.Pairs("authorization", "Bearer "+laptop.Token), "/userpb.UserService/ListSessions")
+	assert.NotNil(t, err)
+	refreshed, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: laptop.RefreshToken})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), refreshed.StatusCode)
+
+	// the other session stays logged in
+	_, err = callWithMetadata(t, service, metadata.Pairs("authorization", "Bearer "+phone.Token), "/userpb.UserService/ListSessions")
+	assert.Nil(t, err)
+	refreshed, err = service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: phone.RefreshToken})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), refreshed.StatusCode)
+}
+
+func TestLogoutAllSessions(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	laptop, laptopCtx := loginFrom(t, service, &user, "Laptop", "203.0.113.7")
+	phone, _ := loginFrom(t, service, &user, "Phone", "198.51.100.4")
+
+	loggedOut, err := service.Logout(laptopCtx, &userpb.LogoutRequest{AllSessions: true})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), loggedOut.StatusCode)
+
+	for _, tokens := range []*userpb.Responsedata{laptop, phone} {
+		_, err = callWithMetadata(t, service, metadata.Pairs("authorization", "Bearer "+tokens.Token), "/userpb.UserService/ListSessions")
+		assert.NotNil(t, err)
+		refreshed, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: tokens.RefreshToken})
+		require.Nil(t, err)
+		assert.Equal(t, int64(StatusUnauthorized), refreshed.StatusCode)
+	}
+}
@@ -0,0 +1,126 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails to users.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// LogMailer writes emails to the log instead of sending them, for development
+// only, the log then holds the links sent in the emails.
+type LogMailer struct {
+	logger *zap.Logger
+}
+
+func NewLogMailer(logger *zap.Logger) *LogMailer {
+	return &LogMailer{logger: logger}
+}
+
+func (mailer *LogMailer) Send(ctx context.Context, message Message) error {
+	mailer.logger.Info("Email",
+		zap.String("to", message.To),
+		zap.String("subject", message.Subject),
+		zap.String("body", message.Body))
+	return nil
+}
+
+// FileMailer stores every email as a .eml file in a directory, for development
+// and tests.
+type FileMailer struct {
+	dir string
+}
+
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create mail directory: %w", err)
+	}
+	return &FileMailer{dir: dir}, nil
+}
+
+func (mailer *FileMailer) Send(ctx context.Context, message Message) error {
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(message.To))
+	return os.WriteFile(filepath.Join(mailer.dir, name), formatMessage("", message), 0o644)
+}
+
+// SMTPMailer sends emails through an SMTP server.
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func NewSMTPMailer(host string, port string, username string, password string, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{addr: host + ":" + port, auth: auth, from: from}
+}
+
+func (mailer *SMTPMailer) Send(ctx context.Context, message Message) error {
+	return smtp.SendMail(mailer.addr, mailer.auth, mailer.from, []string{message.To}, formatMessage(mailer.from, message))
+}
+
+// headerValue drops line breaks so values cannot inject extra headers
+var headerValue = strings.NewReplacer("\r", "", "\n", "")
+
+func formatMessage(from string, message Message) []byte {
+	var builder strings.Builder
+	if from != "" {
+		fmt.Fprintf(&builder, "From: %s\r\n", headerValue.Replace(from))
+	}
+	fmt.Fprintf(&builder, "To: %s\r\n", headerValue.Replace(message.To))
+	fmt.Fprintf(&builder, "Subject: %s\r\n", headerValue.Replace(message.Subject))
+	builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	builder.WriteString(message.Body)
+	return []byte(builder.String())
+}
+
+// FakeMailer keeps emails in memory so tests can read them back.
+type FakeMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewFakeMailer() *FakeMailer {
+	return &FakeMailer{}
+}
+
+func (mailer *FakeMailer) Send(ctx context.Context, message Message) error {
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+	mailer.messages = append(mailer.messages, message)
+	return nil
+}
+
+// Messages returns every email sent to the address, oldest first.
+func (mailer *FakeMailer) Messages(to string) []Message {
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+	var messages []Message
+	for _, message := range mailer.messages {
+		if message.To == to {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
@@ -2,15 +2,25 @@ package main
 
 import (
 	"auth-microservice/config"
+	"auth-microservice/federation"
 	"auth-microservice/jwt"
+	"auth-microservice/mailer"
+	"auth-microservice/model"
+	"auth-microservice/password"
+	"auth-microservice/policy"
+	"auth-microservice/sms"
 	userpb "auth-microservice/proto/user"
 	"context"
+	"crypto/rand"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gorilla/handlers"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/joho/godotenv"
@@ -29,7 +39,40 @@ const (
 	StatusNotFound         = 404
 	StatusUnauthorized     = 401
 	StatusForbidden        = 403
+	StatusTooManyRequests  = 429
 )
+
+const (
+	defaultTokenIssuer   = "meal-mingle-auth"
+	defaultTokenAudience = "meal-mingle"
+)
+
+// developmentMode is set with APP_ENV=development, only then may emails and
+// text messages be written to the log or to files instead of being sent
+func developmentMode() bool {
+	return os.Getenv("APP_ENV") == "development"
+}
+
+// getEnv returns the environment variable or fallback when it is not set
+func getEnv(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// signedTokenLifetime is how long the longest lived token signed with the
+// key set stays valid, retired keys have to be kept that long.
+func signedTokenLifetime(jwtManager *jwt.JWTManager) time.Duration {
+	lifetime := jwtManager.TokenDuration()
+	for _, duration := range []time.Duration{emailVerificationTokenDuration, ssoSessionDuration,
+		serviceTokenDuration, mfaChallengeDuration} {
+		if duration > lifetime {
+			lifetime = duration
+		}
+	}
+	return lifetime
+}
 var logger *zap.Logger
 
 func init() {
@@ -47,6 +90,26 @@ var ownerDetailsDbConector *gorm.DB
 type UserService struct {
 	userpb.UnimplementedUserServiceServer
 	jwtManager *jwt.JWTManager
+	mailer     mailer.Mailer
+	smsSender  sms.SmsSender
+	// passwordPolicy is applied whenever a user sets a password
+	passwordPolicy *password.Policy
+	// passwordHashers hash new passwords and verify the stored hashes
+	passwordHashers *password.Hashers
+	loginThrottle   *loginThrottle
+	// otpSecret keys the hashes of the one time codes sent by SMS
+	otpSecret []byte
+	// totpKey encrypts the authenticator secrets stored with the users
+	totpKey []byte
+	// hardenedAuth hides whether an email or phone number is registered
+	hardenedAuth bool
+	webAuthn     *webauthn.WebAuthn
+	// oauthProviders are the identity providers users can login with, by name
+	oauthProviders map[string]federation.Provider
+	// oidcLoginURL is the login page the OpenID provider sends users to
+	oidcLoginURL string
+	// deliveries are the emails and messages still being sent in the background
+	deliveries sync.WaitGroup
 }
 
 // Responsible for starting the server
@@ -63,6 +126,18 @@ func startServer() {
 	// Create a new context
 	userDbConnector, ownerDetailsDbConector = config.ConnectDB()
 
+	// Give the configured account the built-in role for managing roles
+	if roleAdminEmail := os.Getenv("ROLE_ADMIN_EMAIL"); roleAdminEmail != "" {
+		var roleAdmin model.User
+		err := userDbConnector.Where("email = ?", roleAdminEmail).First(&roleAdmin).Error
+		if err == nil {
+			err = assignRole(&roleAdmin, model.RoleAdminRole)
+		}
+		if err != nil {
+			logger.Warn("Failed to assign role-admin role", zap.String("userEmail", roleAdminEmail), zap.Error(err))
+		}
+	}
+
 	// Start the server on port 50051
 	listener, err := net.Listen("tcp", "localhost:50051")
 	if err != nil {
@@ -70,18 +145,141 @@ func startServer() {
 	}
 
 	// Creating a new JWT Manager
-	JwtManager, err := jwt.NewJWTManager(os.Getenv("SECRET_KEY"), 5*time.Hour)
+	JwtManager, err := jwt.NewJWTManager(os.Getenv("SECRET_KEY"), 5*time.Hour, 30*24*time.Hour)
 	if err != nil {
 		logger.Fatal("Failed to create JWT manager", zap.Error(err))
 	}
+	JwtManager.UseClaimsValidation(getEnv("JWT_ISSUER", defaultTokenIssuer),
+		getEnv("JWT_AUDIENCE", defaultTokenAudience), time.Minute)
+	// Tokens issued before the issuer and audience claims stay valid until
+	// JWT_LEGACY_TOKENS_UNTIL, by default for one token lifetime
+	legacyTokensUntil := time.Now().Add(JwtManager.TokenDuration())
+	if until := os.Getenv("JWT_LEGACY_TOKENS_UNTIL"); until != "" {
+		legacyTokensUntil, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			logger.Fatal("Invalid JWT_LEGACY_TOKENS_UNTIL", zap.Error(err))
+		}
+	}
+	JwtManager.AcceptLegacyTokensUntil(legacyTokensUntil)
+	JwtManager.UseRevocationStore(jwt.NewGormRevocationStore(userDbConnector))
+	JwtManager.UseApiKeyStore(jwt.NewGormApiKeyStore(userDbConnector))
+
+	// Sign tokens with asymmetric keys when a key directory is configured
+	if keyDir := os.Getenv("JWT_KEY_DIR"); keyDir != "" {
+		keySet, err := jwt.LoadKeySet(keyDir, getEnv("JWT_SIGNING_ALGORITHM", jwt.AlgorithmRS256))
+		if err != nil {
+			logger.Fatal("Failed to load JWT signing keys", zap.Error(err))
+		}
+		JwtManager.UseKeySet(keySet)
+		if interval := os.Getenv("JWT_KEY_ROTATION_INTERVAL"); interval != "" {
+			rotationInterval, err := time.ParseDuration(interval)
+			if err != nil {
+				logger.Fatal("Invalid JWT_KEY_ROTATION_INTERVAL", zap.Error(err))
+			}
+			keySet.StartRotation(context.Background(), rotationInterval, signedTokenLifetime(JwtManager), func(err error) {
+				logger.Error("Failed to rotate JWT signing key", zap.Error(err))
+			})
+		}
+	}
+
+	// Load the access rules declared in user.proto
+	accessPolicy, err := policy.FromService(userpb.File_user_user_proto.Services().ByName("UserService"))
+	if err != nil {
+		logger.Fatal("Failed to load access policy", zap.Error(err))
+	}
+	accessPolicy.RequireVerifiedEmail(getEnv("REQUIRE_EMAIL_VERIFICATION", "true") == "true")
+
+	// Create the mailer used for verification and password reset emails
+	userMailer, err := newMailer()
+	if err != nil {
+		logger.Fatal("Failed to create mailer", zap.Error(err))
+	}
+
+	// Create the sender used for the one-time codes
+	smsSender, err := newSmsSender()
+	if err != nil {
+		logger.Fatal("Failed to create SMS sender", zap.Error(err))
+	}
+
+	// Configure how passwords are hashed
+	passwordHashers, err := newPasswordHashers()
+	if err != nil {
+		logger.Fatal("Failed to configure password hashing", zap.Error(err))
+	}
+
+	// Load the rules new passwords have to satisfy
+	passwordPolicy, err := newPasswordPolicy()
+	if err != nil {
+		logger.Fatal("Failed to load password policy", zap.Error(err))
+	}
+
+	// Configure the failed login backoff and lockout
+	userLoginThrottle, err := newLoginThrottle()
+	if err != nil {
+		logger.Fatal("Failed to configure login throttling", zap.Error(err))
+	}
+
+	// Codes are hashed with OTP_SECRET, falling back to the JWT secret
+	otpSecret := []byte(getEnv("OTP_SECRET", os.Getenv("SECRET_KEY")))
+	if len(otpSecret) == 0 {
+		logger.Warn("OTP_SECRET is not set, codes sent before a restart will not verify")
+		otpSecret = make([]byte, 32)
+		if _, err := rand.Read(otpSecret); err != nil {
+			logger.Fatal("Failed to generate OTP secret", zap.Error(err))
+		}
+	}
+
+	// Authenticator secrets are encrypted with TOTP_ENCRYPTION_KEY, falling
+	// back to the OTP secret
+	totpKey := []byte(getEnv("TOTP_ENCRYPTION_KEY", string(otpSecret)))
+	if err := sealLegacyTotpSecrets(totpKey); err != nil {
+		logger.Fatal("Failed to encrypt authenticator secrets", zap.Error(err))
+	}
+
+	// Passkeys are bound to the relying party of the frontends
+	webAuthn, err := newWebAuthn()
+	if err != nil {
+		logger.Fatal("Invalid WebAuthn configuration", zap.Error(err))
+	}
+
+	// Identity providers for social login
+	oauthProviders, err := newOAuthProviders(context.Background())
+	if err != nil {
+		logger.Fatal("Failed to configure identity providers", zap.Error(err))
+	}
+
+	// Limit how often a user or client address can call each RPC
+	rateLimiter, err := newRateLimiter()
+	if err != nil {
+		logger.Fatal("Invalid RATE_LIMITS", zap.Error(err))
+	}
+
+	// Delete abandoned passkey challenges, OAuth states and authorization codes,
+	// and revoked tokens that expired
+	startExpirySweep(context.Background(), expirySweepInterval, JwtManager.ClockSkew(), func(err error) {
+		logger.Error("Failed to delete expired records", zap.Error(err))
+	})
 
 	// Create a new gRPC server
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(jwt.UnaryInterceptor),
-	)
+	grpcServer := newGrpcServer(JwtManager, accessPolicy, rateLimiter)
 
 	// Register the service with the server
-	userpb.RegisterUserServiceServer(grpcServer, &UserService{jwtManager: JwtManager})
+	userService := &UserService{
+		jwtManager: JwtManager,
+		mailer:     userMailer,
+		smsSender:  smsSender,
+
+		passwordPolicy:  passwordPolicy,
+		passwordHashers: passwordHashers,
+		loginThrottle:   userLoginThrottle,
+		otpSecret:       otpSecret,
+		totpKey:         totpKey,
+		hardenedAuth:    getEnv("HARDENED_AUTH", "false") == "true",
+		webAuthn:        webAuthn,
+		oauthProviders:  oauthProviders,
+		oidcLoginURL:    getEnv("OIDC_LOGIN_URL", "http://localhost:3000/login"),
+	}
+	userpb.RegisterUserServiceServer(grpcServer, userService)
 
 	// Start the server in a new goroutine
 	go func() {
@@ -102,7 +300,8 @@ func startServer() {
 	}
 
 	// Create a new gRPC-Gateway mux
-	gwmux := runtime.NewServeMux()
+	gwmux := runtime.NewServeMux(runtime.WithOutgoingHeaderMatcher(gatewayOutgoingHeader),
+		runtime.WithIncomingHeaderMatcher(gatewayIncomingHeader))
 
 	// Register the service with the gRPC-Gateway
 	err = userpb.RegisterUserServiceHandler(context.Background(), gwmux, connection)
@@ -110,11 +309,29 @@ func startServer() {
 		logger.Fatal("Failed to register gateway", zap.Error(err))
 	}
 
+	// Publish the token verification keys
+	err = gwmux.HandlePath("GET", "/.well-known/jwks.json", JwtManager.JWKSHandler)
+	if err != nil {
+		logger.Fatal("Failed to register JWKS route", zap.Error(err))
+	}
+
+	// Act as OpenID provider for the frontends, ID tokens are verified with
+	// the published keys and the issuer has to be the public gateway URL
+	if JwtManager.SigningAlgorithm() != "" && strings.HasPrefix(JwtManager.Issuer(), "http") {
+		if err := registerOIDCRoutes(gwmux, userService); err != nil {
+			logger.Fatal("Failed to register OpenID provider routes", zap.Error(err))
+		}
+	} else {
+		logger.Info("OpenID provider is disabled, it needs JWT_KEY_DIR and an http(s) JWT_ISSUER")
+	}
+
 	// Enable CORS
 	corsOrigins := handlers.AllowedOrigins([]string{"http://localhost:3000"})
 	corsMethods := handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "OPTIONS"})
-	corsHeaders := handlers.AllowedHeaders([]string{"Content-Type", "Authorization"})
-	corsHandler := handlers.CORS(corsOrigins, corsMethods, corsHeaders)
+	corsHeaders := handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "X-Api-Key"})
+	corsExposedHeaders := handlers.ExposedHeaders([]string{"Retry-After"})
+	// the login page sends the SSO session cookie along
+	corsHandler := handlers.CORS(corsOrigins, corsMethods, corsHeaders, corsExposedHeaders, handlers.AllowCredentials())
 	wrappedGwmux := corsHandler(gwmux)
 
 	// Create a new HTTP server
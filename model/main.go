@@ -1,5 +1,9 @@
 package model
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 // role as enum for user
 const (
 	AdminRole = "admin"
@@ -14,6 +18,21 @@ type User struct {
 	Address string
 	City string
 	Role string
+	// incremented to invalidate every access token issued before
+	TokenGeneration uint
+	// set for new accounts until the email address is verified
+	EmailVerificationPending bool
+	PhoneVerified            bool
+	// TotpSecret is set by EnrollTotp, sealed with the server key, and
+	// TotpEnabled once a code confirmed it. Enrollment is opt-in, also for
+	// the admin role.
+	TotpSecret  string
+	TotpEnabled bool
+	// TotpLastStep is the time step of the last accepted code, so a code
+	// cannot be used twice
+	TotpLastStep int64
+	// additional roles whose permissions are granted as token scopes
+	Roles []Role `gorm:"many2many:user_roles;"`
 }
 
 type Details struct {
@@ -27,3 +46,240 @@ type Details struct {
 	// foreign key for user table
 	UserId string `gorm:"foreignKey:UserID;unique"`
 }
+
+// RefreshToken is a server-side record of an issued refresh token. Tokens
+// that are rotated from one another share the same FamilyID.
+type RefreshToken struct {
+	gorm.Model
+	UserID         uint   `gorm:"index"`
+	TokenHash      string `gorm:"unique"`
+	FamilyID       string `gorm:"index"`
+	ExpiresAt      time.Time
+	RevokedAt      *time.Time
+	ReplacedByHash string
+	// ClientID is the oauth client the token was issued to through the
+	// authorization code flow, empty for tokens of the login RPCs
+	ClientID string `gorm:"index"`
+}
+
+// RevokedToken is an access token that was logged out before it expired.
+type RevokedToken struct {
+	gorm.Model
+	TokenID   string `gorm:"unique"`
+	ExpiresAt time.Time
+}
+
+// Role groups permissions that can be assigned to users, e.g. staff or
+// delivery partners.
+type Role struct {
+	gorm.Model
+	Name        string       `gorm:"unique"`
+	Description string
+	Permissions []Permission `gorm:"many2many:role_permissions;"`
+}
+
+// Permission is a single capability such as "orders:read", it is put into the
+// access token as a scope.
+type Permission struct {
+	gorm.Model
+	Name string `gorm:"unique"`
+}
+
+// built-in role and permissions required by the RPCs
+const (
+	RoleAdminRole                  = "role-admin"
+	ManageRolesPermission          = "roles:manage"
+	UnlockUsersPermission          = "users:unlock"
+	ManageServiceClientsPermission = "service_clients:manage"
+	ManageOAuthClientsPermission   = "oauth_clients:manage"
+	ReadUsersPermission            = "users:read"
+)
+
+// PhoneOtp is a one-time code sent by SMS, stored as a hash.
+type PhoneOtp struct {
+	gorm.Model
+	Phone      string `gorm:"index"`
+	Purpose    string
+	CodeHash   string
+	ExpiresAt  time.Time
+	Attempts   int
+	ConsumedAt *time.Time
+	// RequestIP is the client address the code was requested from
+	RequestIP string `gorm:"index"`
+}
+
+// OtpRequest is a request for a one-time code. Every request is counted to
+// rate limit them, whether or not a code was sent, so the limit does not tell
+// which phone numbers are registered.
+type OtpRequest struct {
+	ID        uint   `gorm:"primarykey"`
+	Phone     string `gorm:"index"`
+	Purpose   string
+	RequestIP string `gorm:"index"`
+	CreatedAt time.Time
+}
+
+// PasswordResetToken is a single-use token emailed to reset a password. Only
+// the hash of the token is stored.
+type PasswordResetToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	TokenHash string `gorm:"unique"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// LoginThrottle counts the failed logins of an account or client address.
+// Rows are deleted when an account is unlocked, so it has no soft delete.
+type LoginThrottle struct {
+	ID            uint   `gorm:"primarykey"`
+	ThrottleKey   string `gorm:"unique"`
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  time.Time
+	UpdatedAt     time.Time
+}
+
+// RecoveryCode is a single-use code that replaces a TOTP code when the
+// authenticator is lost. Only the hash of the code is stored.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint `gorm:"index"`
+	CodeHash string
+	UsedAt   *time.Time
+}
+
+// PasskeyCredential is a WebAuthn credential a user registered to login
+// without a password. Data holds the credential as the webauthn library
+// serializes it, including the public key and the signature counter.
+type PasskeyCredential struct {
+	gorm.Model
+	UserID uint `gorm:"index"`
+	// CredentialID is the base64url encoded credential id
+	CredentialID string `gorm:"unique"`
+	Name         string
+	Data         string
+	LastUsedAt   *time.Time
+}
+
+// PasskeyChallenge is the state of a passkey registration or login between
+// its begin and finish request. It is deleted when it is used.
+type PasskeyChallenge struct {
+	gorm.Model
+	SessionID string `gorm:"unique"`
+	// UserID is zero for logins, the passkey tells who logs in
+	UserID      uint
+	Purpose     string
+	SessionData string
+	ExpiresAt   time.Time
+}
+
+// FederatedIdentity links a user to their account at an identity provider
+// like Google. Unlinking deletes the row so the account can be linked again,
+// so it has no soft delete.
+type FederatedIdentity struct {
+	ID       uint   `gorm:"primarykey"`
+	UserID   uint   `gorm:"index"`
+	Provider string `gorm:"uniqueIndex:idx_provider_subject"`
+	// Subject identifies the account at the provider
+	Subject   string `gorm:"uniqueIndex:idx_provider_subject"`
+	Email     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// OAuthState is a login or link started at an identity provider, it is
+// deleted when the provider redirects back with the code.
+type OAuthState struct {
+	gorm.Model
+	State    string `gorm:"unique"`
+	Provider string
+	// UserID is set when a logged in user links an identity
+	UserID       uint
+	Purpose      string
+	CodeVerifier string
+	Nonce        string
+	ExpiresAt    time.Time
+}
+
+// OAuthClient is a frontend that signs users in through this service as its
+// OpenID provider. Public clients like single page apps have no secret.
+type OAuthClient struct {
+	gorm.Model
+	ClientID   string `gorm:"unique"`
+	SecretHash string
+	Name       string
+	// RedirectURIs are the space separated URIs codes may be sent to
+	RedirectURIs string
+}
+
+// OAuthAuthorizationCode is a code issued to a client by the authorize
+// endpoint, it is deleted when the client exchanges it for tokens.
+type OAuthAuthorizationCode struct {
+	gorm.Model
+	CodeHash      string `gorm:"unique"`
+	ClientID      string
+	UserID        uint
+	RedirectURI   string
+	CodeChallenge string
+	Nonce         string
+	Scope         string
+	// AuthTime is when the user logged in
+	AuthTime  time.Time
+	ExpiresAt time.Time
+}
+
+// ServiceClient is another backend service, like orders or payments, that
+// gets access tokens with the client credentials grant. Its tokens carry the
+// scopes granted here instead of the permissions of a user.
+type ServiceClient struct {
+	gorm.Model
+	ClientID   string `gorm:"unique"`
+	SecretHash string
+	Name       string
+	// Scopes are the space separated permissions the client may request
+	Scopes string
+	// SecretRotatedAt rejects the tokens issued before the secret was rotated
+	SecretRotatedAt *time.Time
+}
+
+// ApiKey is a long lived key a user created for an integration, like the POS
+// system of a restaurant. Only the hash of the key is stored, Prefix is the
+// start of the key so the user can tell their keys apart.
+type ApiKey struct {
+	gorm.Model
+	UserID  uint   `gorm:"index"`
+	KeyHash string `gorm:"unique"`
+	Prefix  string
+	Name    string
+	// Scopes are the space separated permissions of the user the key may use
+	Scopes     string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// Session is a login of a user on a device. Its id is the family id of the
+// refresh tokens issued for the login and the sid claim of its access tokens,
+// so revoking it logs the device out.
+type Session struct {
+	gorm.Model
+	SessionID  string `gorm:"unique"`
+	UserID     uint   `gorm:"index"`
+	UserAgent  string
+	IP         string
+	LastSeenAt time.Time
+	// ExpiresAt is when the last refresh token of the session expires
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// UserModels are the tables of the user database, in the order they are
+// migrated
+func UserModels() []interface{} {
+	return []interface{}{&User{}, &RefreshToken{}, &RevokedToken{},
+		&Role{}, &Permission{}, &PhoneOtp{}, &OtpRequest{}, &PasswordResetToken{}, &LoginThrottle{},
+		&RecoveryCode{}, &PasskeyCredential{}, &PasskeyChallenge{},
+		&FederatedIdentity{}, &OAuthState{}, &OAuthClient{}, &OAuthAuthorizationCode{},
+		&ServiceClient{}, &ApiKey{}, &Session{}}
+}
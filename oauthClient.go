@@ -0,0 +1,97 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"crypto/subtle"
+	"net/url"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// validRedirectURI accepts absolute https URIs, and http for frontends
+// running on the local machine.
+func validRedirectURI(redirectURI string) bool {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil || parsed.Host == "" || parsed.Fragment != "" {
+		return false
+	}
+	switch parsed.Scheme {
+	case "https":
+		return true
+	case "http":
+		hostname := parsed.Hostname()
+		return hostname == "localhost" || hostname == "127.0.0.1"
+	}
+	return false
+}
+
+// allowsRedirectURI compares redirectURI with the registered URIs exactly, as
+// codes must never be sent anywhere else.
+func allowsRedirectURI(client *model.OAuthClient, redirectURI string) bool {
+	for _, registered := range strings.Fields(client.RedirectURIs) {
+		if registered == redirectURI {
+			return true
+		}
+	}
+	return false
+}
+
+// checkClientSecret reports whether secret belongs to the client. Public
+// clients have no secret, they prove themselves with PKCE alone.
+func checkClientSecret(client *model.OAuthClient, secret string) bool {
+	if client.SecretHash == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(jwt.HashOpaqueToken(secret)), []byte(client.SecretHash)) == 1
+}
+
+func (userServiceManager *UserService) CreateOAuthClient(ctx context.Context, request *userpb.CreateOAuthClientRequest) (*userpb.CreateOAuthClientResponse, error) {
+	logger.Info("Received CreateOAuthClient request", zap.String("clientName", request.Name))
+	valid := request.Name != "" && len(request.RedirectUris) > 0
+	for _, redirectURI := range request.RedirectUris {
+		valid = valid && validRedirectURI(redirectURI)
+	}
+	if !valid {
+		logger.Warn("Invalid request fields", zap.String("clientName", request.Name))
+		return &userpb.CreateOAuthClientResponse{
+			Data:       nil,
+			Message:    "The request contains missing or invalid fields. Redirect URIs must be absolute https URIs.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	clientID, err := jwt.GenerateID()
+	client := &model.OAuthClient{ClientID: clientID, Name: request.Name, RedirectURIs: strings.Join(request.RedirectUris, " ")}
+	var clientSecret string
+	if err == nil && request.Confidential {
+		clientSecret, client.SecretHash, err = jwt.GenerateOpaqueToken()
+	}
+	if err == nil {
+		err = userDbConnector.Create(client).Error
+	}
+	if err != nil {
+		logger.Error("Failed to create oauth client", zap.String("clientName", request.Name), zap.Error(err))
+		return &userpb.CreateOAuthClientResponse{
+			Data:       nil,
+			Message:    "Failed to create client",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Oauth client created successfully", zap.String("clientName", client.Name), zap.String("clientId", client.ClientID))
+	return &userpb.CreateOAuthClientResponse{
+		Data: &userpb.OAuthClient{
+			ClientId:     client.ClientID,
+			ClientSecret: clientSecret,
+			Name:         client.Name,
+			RedirectUris: request.RedirectUris,
+		},
+		Message:    "Client created successfully, the secret is only shown once.",
+		Error:      "",
+		StatusCode: StatusCreated,
+	}, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+
+	"go.uber.org/zap"
+)
+
+func (userServiceManager *UserService) StartOAuthLink(ctx context.Context, request *userpb.StartOAuthLinkRequest) (*userpb.StartOAuthLinkResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.StartOAuthLinkResponse{
+			Data:       nil,
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received StartOAuthLink request", zap.String("userEmail", userEmail), zap.String("provider", request.Provider))
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.StartOAuthLinkResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	data, err := userServiceManager.startOAuth(request.Provider, user.ID, oauthPurposeLink)
+	if err == errUnknownOAuthProvider {
+		logger.Warn("Unknown identity provider", zap.String("provider", request.Provider))
+		return &userpb.StartOAuthLinkResponse{
+			Data:       nil,
+			Message:    "Unknown identity provider",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if err != nil {
+		logger.Error("Failed to start oauth link", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.StartOAuthLinkResponse{
+			Data:       nil,
+			Message:    "Failed to link the account, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	return &userpb.StartOAuthLinkResponse{
+		Data:       data,
+		Message:    "Sign in at the provider, then send the code and state it redirects back with.",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+func (userServiceManager *UserService) CompleteOAuthLink(ctx context.Context, request *userpb.CompleteOAuthLinkRequest) (*userpb.CompleteOAuthLinkResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.CompleteOAuthLinkResponse{
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received CompleteOAuthLink request", zap.String("userEmail", userEmail), zap.String("provider", request.Provider))
+	if request.State == "" || request.Code == "" {
+		logger.Warn("Invalid request fields", zap.String("userEmail", userEmail))
+		return &userpb.CompleteOAuthLinkResponse{
+			Message:    "The request contains missing or invalid fields.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.CompleteOAuthLinkResponse{
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	identity, err := userServiceManager.completeOAuth(ctx, request.Provider, request.State, request.Code, user.ID, oauthPurposeLink)
+	if err == errUnknownOAuthProvider {
+		logger.Warn("Unknown identity provider", zap.String("provider", request.Provider))
+		return &userpb.CompleteOAuthLinkResponse{
+			Message:    "Unknown identity provider",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if err != nil {
+		logger.Warn("Oauth link failed", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.CompleteOAuthLinkResponse{
+			Message:    "The sign in at the provider failed or expired, Please start again.",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	var count int64
+	err = userDbConnector.Model(&model.FederatedIdentity{}).
+		Where("(provider = ? AND subject = ?) OR (user_id = ? AND provider = ?)", identity.Provider, identity.Subject, user.ID, identity.Provider).
+		Count(&count).Error
+	if err == nil && count > 0 {
+		logger.Warn("Identity or provider already linked", zap.String("userEmail", userEmail), zap.String("provider", identity.Provider))
+		return &userpb.CompleteOAuthLinkResponse{
+			Message:    "The " + identity.Provider + " account or this user is already linked, Please unlink it first.",
+			Error:      "Conflict",
+			StatusCode: StatusConflict,
+		}, nil
+	}
+	if err == nil {
+		// the unique index on provider and subject rejects a concurrent link
+		err = userDbConnector.Create(&model.FederatedIdentity{
+			UserID:   user.ID,
+			Provider: identity.Provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		}).Error
+	}
+	if err != nil {
+		logger.Error("Failed to link identity", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.CompleteOAuthLinkResponse{
+			Message:    "Failed to link the account, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Identity linked", zap.String("userEmail", userEmail), zap.String("provider", identity.Provider))
+	return &userpb.CompleteOAuthLinkResponse{
+		Message:    "The " + identity.Provider + " account was linked successfully",
+		Error:      "",
+		StatusCode: StatusCreated,
+	}, nil
+}
+
+func (userServiceManager *UserService) UnlinkOAuthIdentity(ctx context.Context, request *userpb.UnlinkOAuthIdentityRequest) (*userpb.UnlinkOAuthIdentityResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.UnlinkOAuthIdentityResponse{
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received UnlinkOAuthIdentity request", zap.String("userEmail", userEmail), zap.String("provider", request.Provider))
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.UnlinkOAuthIdentityResponse{
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	result := userDbConnector.Where("user_id = ? AND provider = ?", user.ID, request.Provider).Delete(&model.FederatedIdentity{})
+	if result.Error != nil {
+		logger.Error("Failed to unlink identity", zap.String("userEmail", userEmail), zap.Error(result.Error))
+		return &userpb.UnlinkOAuthIdentityResponse{
+			Message:    "Failed to unlink the account, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if result.RowsAffected == 0 {
+		logger.Warn("No identity linked", zap.String("userEmail", userEmail), zap.String("provider", request.Provider))
+		return &userpb.UnlinkOAuthIdentityResponse{
+			Message:    "No " + request.Provider + " account is linked",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	logger.Info("Identity unlinked", zap.String("userEmail", userEmail), zap.String("provider", request.Provider))
+	return &userpb.UnlinkOAuthIdentityResponse{
+		Message:    "The " + request.Provider + " account was unlinked successfully",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
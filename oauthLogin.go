@@ -0,0 +1,223 @@
+package main
+
+import (
+	"auth-microservice/federation"
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	// oauthStateDuration is how long the user has to sign in at the provider
+	oauthStateDuration = 10 * time.Minute
+
+	oauthPurposeLogin = "login"
+	oauthPurposeLink  = "link"
+)
+
+var (
+	errUnknownOAuthProvider = errors.New("unknown identity provider")
+	errOAuthStateNotFound   = errors.New("oauth state not found or expired")
+)
+
+// newOAuthProviders configures the identity providers that have a client id
+// in OAUTH_<PROVIDER>_CLIENT_ID. Providers redirect back to OAUTH_REDIRECT_URL.
+func newOAuthProviders(ctx context.Context) (map[string]federation.Provider, error) {
+	providers := map[string]federation.Provider{}
+	redirectURL := getEnv("OAUTH_REDIRECT_URL", "http://localhost:3000/oauth/callback")
+	if clientID := getEnv("OAUTH_GOOGLE_CLIENT_ID", ""); clientID != "" {
+		google, err := federation.NewOIDCProvider(ctx, "google", getEnv("OAUTH_GOOGLE_ISSUER", "https://accounts.google.com"),
+			oauth2.Config{ClientID: clientID, ClientSecret: getEnv("OAUTH_GOOGLE_CLIENT_SECRET", ""), RedirectURL: redirectURL})
+		if err != nil {
+			return nil, err
+		}
+		providers[google.Name()] = google
+	}
+	if clientID := getEnv("OAUTH_GITHUB_CLIENT_ID", ""); clientID != "" {
+		github := federation.NewGitHubProvider(
+			oauth2.Config{ClientID: clientID, ClientSecret: getEnv("OAUTH_GITHUB_CLIENT_SECRET", ""), RedirectURL: redirectURL},
+			federation.GitHubAPI)
+		providers[github.Name()] = github
+	}
+	return providers, nil
+}
+
+// startOAuth stores the state, PKCE verifier and nonce of a new login or link
+// and returns where to send the user.
+func (userServiceManager *UserService) startOAuth(providerName string, userID uint, purpose string) (*userpb.StartOAuthResponseData, error) {
+	provider, ok := userServiceManager.oauthProviders[providerName]
+	if !ok {
+		return nil, errUnknownOAuthProvider
+	}
+	state, err := jwt.GenerateID()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := jwt.GenerateID()
+	if err != nil {
+		return nil, err
+	}
+	record := model.OAuthState{
+		State:        state,
+		Provider:     providerName,
+		UserID:       userID,
+		Purpose:      purpose,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(oauthStateDuration),
+	}
+	if err := userDbConnector.Create(&record).Error; err != nil {
+		return nil, err
+	}
+	return &userpb.StartOAuthResponseData{
+		AuthorizationUrl: provider.AuthCodeURL(state, nonce, record.CodeVerifier),
+		State:            state,
+	}, nil
+}
+
+// completeOAuth uses up the state and exchanges the code at the provider.
+func (userServiceManager *UserService) completeOAuth(ctx context.Context, providerName string, state string, code string, userID uint, purpose string) (*federation.Identity, error) {
+	provider, ok := userServiceManager.oauthProviders[providerName]
+	if !ok {
+		return nil, errUnknownOAuthProvider
+	}
+	var record model.OAuthState
+	err := consumeOnce(&record, errOAuthStateNotFound, "state = ? AND provider = ? AND user_id = ? AND purpose = ? AND expires_at > ?",
+		state, providerName, userID, purpose, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return provider.Exchange(ctx, code, record.CodeVerifier, record.Nonce)
+}
+
+func (userServiceManager *UserService) StartOAuthLogin(ctx context.Context, request *userpb.StartOAuthLoginRequest) (*userpb.StartOAuthLoginResponse, error) {
+	logger.Info("Received StartOAuthLogin request", zap.String("provider", request.Provider))
+	data, err := userServiceManager.startOAuth(request.Provider, 0, oauthPurposeLogin)
+	if err == errUnknownOAuthProvider {
+		logger.Warn("Unknown identity provider", zap.String("provider", request.Provider))
+		return &userpb.StartOAuthLoginResponse{
+			Data:       nil,
+			Message:    "Unknown identity provider",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if err != nil {
+		logger.Error("Failed to start oauth login", zap.String("provider", request.Provider), zap.Error(err))
+		return &userpb.StartOAuthLoginResponse{
+			Data:       nil,
+			Message:    "Failed to login, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	return &userpb.StartOAuthLoginResponse{
+		Data:       data,
+		Message:    "Sign in at the provider, then send the code and state it redirects back with.",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+func (userServiceManager *UserService) CompleteOAuthLogin(ctx context.Context, request *userpb.CompleteOAuthLoginRequest) (*userpb.CompleteOAuthLoginResponse, error) {
+	logger.Info("Received CompleteOAuthLogin request", zap.String("provider", request.Provider))
+	if request.State == "" || request.Code == "" {
+		logger.Warn("Invalid request fields", zap.String("provider", request.Provider))
+		return &userpb.CompleteOAuthLoginResponse{
+			Data:       nil,
+			Message:    "The request contains missing or invalid fields.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	identity, err := userServiceManager.completeOAuth(ctx, request.Provider, request.State, request.Code, 0, oauthPurposeLogin)
+	if err == errUnknownOAuthProvider {
+		logger.Warn("Unknown identity provider", zap.String("provider", request.Provider))
+		return &userpb.CompleteOAuthLoginResponse{
+			Data:       nil,
+			Message:    "Unknown identity provider",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if err != nil {
+		logger.Warn("Oauth login failed", zap.String("provider", request.Provider), zap.Error(err))
+		return &userpb.CompleteOAuthLoginResponse{
+			Data:       nil,
+			Message:    "The sign in at the provider failed or expired, Please start again.",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	// accounts are only found by the linked identity, never by email, so an
+	// account at a provider cannot take over a local account with its address
+	var user model.User
+	err = userDbConnector.Joins("JOIN federated_identities ON federated_identities.user_id = users.id").
+		Where("federated_identities.provider = ? AND federated_identities.subject = ?", identity.Provider, identity.Subject).
+		First(&user).Error
+	if err == gorm.ErrRecordNotFound {
+		logger.Warn("No account linked to identity", zap.String("provider", identity.Provider), zap.String("email", identity.Email))
+		return &userpb.CompleteOAuthLoginResponse{
+			Data:       nil,
+			Message:    "No account is linked to this " + identity.Provider + " account, Please login and link it first.",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if err != nil {
+		logger.Error("Failed to find linked account", zap.String("provider", identity.Provider), zap.Error(err))
+		return &userpb.CompleteOAuthLoginResponse{
+			Data:       nil,
+			Message:    "Failed to login, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	// the provider checked the password, users with two-factor authentication
+	// still need their authenticator
+	if user.TotpEnabled {
+		mfaToken, err := userServiceManager.issueMfaChallenge(&user)
+		if err != nil {
+			logger.Error("Error in generating mfa token", zap.String("userEmail", user.Email), zap.Error(err))
+			return &userpb.CompleteOAuthLoginResponse{
+				Data:       nil,
+				Message:    "Security Issues, Please try again later.",
+				Error:      "Internal Server Error",
+				StatusCode: StatusInternalServerError,
+			}, nil
+		}
+		logger.Info("Oauth login accepted, second factor required", zap.String("userEmail", user.Email))
+		return &userpb.CompleteOAuthLoginResponse{
+			Data:       nil,
+			Message:    "Two-factor authentication required, Please send a code with the mfa token.",
+			Error:      "",
+			StatusCode: StatusOK,
+			MfaToken:   mfaToken,
+		}, nil
+	}
+	data, err := userServiceManager.issueTokens(ctx, &user, "")
+	if err != nil {
+		logger.Error("Error in generating token", zap.String("userEmail", user.Email), zap.Error(err))
+		return &userpb.CompleteOAuthLoginResponse{
+			Data:       nil,
+			Message:    "Security Issues, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("User authenticated with identity provider successfully",
+		zap.String("userEmail", user.Email), zap.String("provider", identity.Provider))
+	return &userpb.CompleteOAuthLoginResponse{
+		Data:       data,
+		Message:    "User authenticated successfully",
+		Error:      "",
+		StatusCode: StatusCreated,
+	}, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"auth-microservice/federation"
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestOAuthService(t *testing.T) (*UserService, *federation.FakeProvider) {
+	service, _ := newTestUserService(t)
+	google := federation.NewFakeProvider("google")
+	service.oauthProviders = map[string]federation.Provider{"google": google}
+	return service, google
+}
+
+func TestOAuthLinkAndLogin(t *testing.T) {
+	service, google := newTestOAuthService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	userCtx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: user.ID, Email: user.Email})
+	identity := federation.Identity{Subject: "google-subject", Email: "someone@gmail.com", EmailVerified: true}
+
+	// nobody is linked to the identity yet
+	start, err := service.StartOAuthLogin(context.Background(), &userpb.StartOAuthLoginRequest{Provider: "google"})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), start.StatusCode)
+	google.AddCode("code-1", identity)
+	login, err := service.CompleteOAuthLogin(context.Background(), &userpb.CompleteOAuthLoginRequest{
+		Provider: "google", State: start.Data.State, Code: "code-1"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), login.StatusCode)
+
+	link, err := service.StartOAuthLink(userCtx, &userpb.StartOAuthLinkRequest{Provider: "google"})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), link.StatusCode)
+	google.AddCode("code-2", identity)
+	linked, err := service.CompleteOAuthLink(userCtx, &userpb.CompleteOAuthLinkRequest{
+		Provider: "google", State: link.Data.State, Code: "code-2"})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), linked.StatusCode)
+
+	start, err = service.StartOAuthLogin(context.Background(), &userpb.StartOAuthLoginRequest{Provider: "google"})
+	require.Nil(t, err)
+	google.AddCode("code-3", identity)
+	login, err = service.CompleteOAuthLogin(context.Background(), &userpb.CompleteOAuthLoginRequest{
+		Provider: "google", State: start.Data.State, Code: "code-3"})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), login.StatusCode)
+	assert.Equal(t, user.Email, login.Data.User.UserEmail)
+
+	// the state is used up
+	google.AddCode("code-4", identity)
+	login, err = service.CompleteOAuthLogin(context.Background(), &userpb.CompleteOAuthLoginRequest{
+		Provider: "google", State: start.Data.State, Code: "code-4"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), login.StatusCode)
+
+	unlinked, err := service.UnlinkOAuthIdentity(userCtx, &userpb.UnlinkOAuthIdentityRequest{Provider: "google"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), unlinked.StatusCode)
+	unlinked, err = service.UnlinkOAuthIdentity(userCtx, &userpb.UnlinkOAuthIdentityRequest{Provider: "google"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), unlinked.StatusCode)
+}
+
+func TestCompleteOAuthLinkRejectsIdentityOfOtherUser(t *testing.T) {
+	service, google := newTestOAuthService(t)
+	owner := model.User{Name: "owner", Email: "owner@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&owner).Error)
+	other := model.User{Name: "other", Email: "other@example.com", Phone: "9876543211", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&other).Error)
+	require.Nil(t, userDbConnector.Create(&model.FederatedIdentity{UserID: owner.ID, Provider: "google", Subject: "google-subject"}).Error)
+	ctx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: other.ID, Email: other.Email})
+
+	link, err := service.StartOAuthLink(ctx, &userpb.StartOAuthLinkRequest{Provider: "google"})
+	require.Nil(t, err)
+	google.AddCode("code", federation.Identity{Subject: "google-subject"})
+	linked, err := service.CompleteOAuthLink(ctx, &userpb.CompleteOAuthLinkRequest{
+		Provider: "google", State: link.Data.State, Code: "code"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusConflict), linked.StatusCode)
+}
+
+func TestStartOAuthLoginUnknownProvider(t *testing.T) {
+	service, _ := newTestOAuthService(t)
+
+	start, err := service.StartOAuthLogin(context.Background(), &userpb.StartOAuthLoginRequest{Provider: "myspace"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), start.StatusCode)
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// authorizationCodeDuration is how long a client has to exchange a code
+	authorizationCodeDuration = time.Minute
+	// ssoSessionDuration is how long a login is shared between the frontends
+	ssoSessionDuration = 12 * time.Hour
+	ssoCookieName      = "mm_sso"
+)
+
+// oidcScopes are the scopes this provider understands, others are ignored.
+var oidcScopes = map[string]bool{"openid": true, "email": true, "profile": true}
+
+var errInvalidGrant = errors.New("invalid authorization code")
+
+// registerOIDCRoutes mounts the OpenID provider on the gateway. Frontends send
+// the user to authorize, which redirects to OIDC_LOGIN_URL unless the user
+// already has an SSO session. The login page creates the session with the
+// access token it got from the login RPCs, then returns to authorize.
+func registerOIDCRoutes(gwmux *runtime.ServeMux, userServiceManager *UserService) error {
+	routes := []struct {
+		method  string
+		path    string
+		handler runtime.HandlerFunc
+	}{
+		{"GET", "/.well-known/openid-configuration", userServiceManager.openIDConfiguration},
+		{"GET", "/oauth2/authorize", userServiceManager.authorize},
+		{"POST", "/oauth2/token", userServiceManager.token},
+		{"GET", "/oauth2/userinfo", userServiceManager.userInfo},
+		{"POST", "/oauth2/userinfo", userServiceManager.userInfo},
+		{"POST", "/oauth2/session", userServiceManager.startSsoSession},
+		{"DELETE", "/oauth2/session", userServiceManager.endSsoSession},
+	}
+	for _, route := range routes {
+		if err := gwmux.HandlePath(route.method, route.path, route.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oidcEndpoint returns the URL of path below the issuer, which is the public
+// URL of the gateway.
+func (userServiceManager *UserService) oidcEndpoint(path string) string {
+	return strings.TrimSuffix(userServiceManager.jwtManager.Issuer(), "/") + path
+}
+
+func writeOAuthJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeOAuthError(w http.ResponseWriter, statusCode int, code string, description string) {
+	writeOAuthJSON(w, statusCode, map[string]string{"error": code, "error_description": description})
+}
+
+// redirectWithParams sends the user back to the client's redirect URI.
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params map[string]string) {
+	target, _ := url.Parse(redirectURI)
+	query := target.Query()
+	for key, value := range params {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// requestContext carries the user agent and address of the browser like the
+// gateway does for RPCs, so sessions started here show the device too.
+func requestContext(r *http.Request) context.Context {
+	md := metadata.Pairs("user-agent", r.UserAgent())
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		md.Set("x-forwarded-for", host)
+	}
+	return metadata.NewIncomingContext(r.Context(), md)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	return strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func (userServiceManager *UserService) openIDConfiguration(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                userServiceManager.jwtManager.Issuer(),
+		"authorization_endpoint":                userServiceManager.oidcEndpoint("/oauth2/authorize"),
+		"token_endpoint":                        userServiceManager.oidcEndpoint("/oauth2/token"),
+		"userinfo_endpoint":                     userServiceManager.oidcEndpoint("/oauth2/userinfo"),
+		"jwks_uri":                              userServiceManager.oidcEndpoint("/.well-known/jwks.json"),
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{userServiceManager.jwtManager.SigningAlgorithm()},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "email", "email_verified", "name", "auth_time", "nonce"},
+	})
+}
+
+// ssoSessionUser returns the user of the SSO session cookie, if any.
+func (userServiceManager *UserService) ssoSessionUser(r *http.Request, user *model.User) (*jwt.Principal, error) {
+	cookie, err := r.Cookie(ssoCookieName)
+	if err != nil {
+		return nil, err
+	}
+	principal, err := userServiceManager.jwtManager.VerifySessionToken(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	if err := userDbConnector.First(user, principal.UserID).Error; err != nil {
+		return nil, err
+	}
+	return principal, nil
+}
+
+func (userServiceManager *UserService) authorize(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	query := r.URL.Query()
+	clientID := query.Get("client_id")
+	redirectURI := query.Get("redirect_uri")
+	logger.Info("Received authorize request", zap.String("clientId", clientID))
+	// without a known client and redirect URI there is nowhere safe to send
+	// the user back to, so the error is shown to them instead
+	var client model.OAuthClient
+	if err := userDbConnector.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		logger.Warn("Unknown oauth client", zap.String("clientId", clientID), zap.Error(err))
+		writeOAuthError(w, http.StatusBadRequest, "invalid_client", "Unknown client")
+		return
+	}
+	if !allowsRedirectURI(&client, redirectURI) {
+		logger.Warn("Redirect uri is not registered", zap.String("clientId", clientID), zap.String("redirectUri", redirectURI))
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "The redirect_uri is not registered for the client")
+		return
+	}
+	state := query.Get("state")
+	if query.Get("response_type") != "code" {
+		redirectWithParams(w, r, redirectURI, map[string]string{"error": "unsupported_response_type", "state": state})
+		return
+	}
+	var scopes []string
+	openID := false
+	for _, scope := range strings.Fields(query.Get("scope")) {
+		if oidcScopes[scope] {
+			scopes = append(scopes, scope)
+			openID = openID || scope == "openid"
+		}
+	}
+	if !openID {
+		redirectWithParams(w, r, redirectURI, map[string]string{"error": "invalid_scope",
+			"error_description": "The openid scope is required", "state": state})
+		return
+	}
+	codeChallenge := query.Get("code_challenge")
+	if codeChallenge == "" || query.Get("code_challenge_method") != "S256" {
+		redirectWithParams(w, r, redirectURI, map[string]string{"error": "invalid_request",
+			"error_description": "PKCE with the S256 method is required", "state": state})
+		return
+	}
+
+	var user model.User
+	principal, err := userServiceManager.ssoSessionUser(r, &user)
+	if err != nil {
+		if query.Get("prompt") == "none" {
+			redirectWithParams(w, r, redirectURI, map[string]string{"error": "login_required", "state": state})
+			return
+		}
+		loginURL, _ := url.Parse(userServiceManager.oidcLoginURL)
+		loginQuery := loginURL.Query()
+		loginQuery.Set("return_to", userServiceManager.oidcEndpoint(r.URL.RequestURI()))
+		loginURL.RawQuery = loginQuery.Encode()
+		http.Redirect(w, r, loginURL.String(), http.StatusFound)
+		return
+	}
+
+	code, codeHash, err := jwt.GenerateOpaqueToken()
+	if err == nil {
+		err = userDbConnector.Create(&model.OAuthAuthorizationCode{
+			CodeHash:      codeHash,
+			ClientID:      client.ClientID,
+			UserID:        user.ID,
+			RedirectURI:   redirectURI,
+			CodeChallenge: codeChallenge,
+			Nonce:         query.Get("nonce"),
+			Scope:         strings.Join(scopes, " "),
+			AuthTime:      principal.AuthTime,
+			ExpiresAt:     time.Now().Add(authorizationCodeDuration),
+		}).Error
+	}
+	if err != nil {
+		logger.Error("Failed to issue authorization code", zap.String("clientId", clientID), zap.Error(err))
+		redirectWithParams(w, r, redirectURI, map[string]string{"error": "server_error", "state": state})
+		return
+	}
+	logger.Info("Authorization code issued", zap.String("userEmail", user.Email), zap.String("clientId", clientID))
+	redirectWithParams(w, r, redirectURI, map[string]string{"code": code, "state": state})
+}
+
+// authenticateClient reads the client credentials from basic authentication
+// or the form body.
+func authenticateClient(r *http.Request) (*model.OAuthClient, bool) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		// the credentials are form encoded before they are put into the header
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	var client model.OAuthClient
+	if err := userDbConnector.Where("client_id = ?", clientID).First(&client).Error; err != nil {
+		return nil, false
+	}
+	return &client, checkClientSecret(&client, clientSecret)
+}
+
+func (userServiceManager *UserService) token(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "The request body is not a valid form")
+		return
+	}
+	client, ok := authenticateClient(r)
+	if !ok {
+		logger.Warn("Oauth client authentication failed", zap.String("clientId", r.PostForm.Get("client_id")))
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth2"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+	logger.Info("Received token request", zap.String("clientId", client.ClientID), zap.String("grantType", r.PostForm.Get("grant_type")))
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		userServiceManager.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		// refresh tokens can only be redeemed by the client they were issued to
+		response := userServiceManager.redeemRefreshToken(requestContext(r), r.PostForm.Get("refresh_token"), client.ClientID)
+		if response.StatusCode != StatusOK {
+			writeOAuthError(w, http.StatusBadRequest, "invalid_grant", response.Message)
+			return
+		}
+		writeOAuthJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token":  response.Data.Token,
+			"token_type":    "Bearer",
+			"expires_in":    int64(userServiceManager.jwtManager.TokenDuration().Seconds()),
+			"refresh_token": response.Data.RefreshToken,
+		})
+	default:
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Use authorization_code or refresh_token")
+	}
+}
+
+// consumeAuthorizationCode uses up the code, checking it was issued to the
+// client for redirectURI and that codeVerifier matches its challenge.
+func consumeAuthorizationCode(code string, clientID string, redirectURI string, codeVerifier string) (*model.OAuthAuthorizationCode, error) {
+	var record model.OAuthAuthorizationCode
+	err := consumeOnce(&record, errInvalidGrant, "code_hash = ? AND expires_at > ?", jwt.HashOpaqueToken(code), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if record.ClientID != clientID || record.RedirectURI != redirectURI ||
+		oauth2.S256ChallengeFromVerifier(codeVerifier) != record.CodeChallenge {
+		return nil, errInvalidGrant
+	}
+	return &record, nil
+}
+
+func (userServiceManager *UserService) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client *model.OAuthClient) {
+	record, err := consumeAuthorizationCode(r.PostForm.Get("code"), client.ClientID,
+		r.PostForm.Get("redirect_uri"), r.PostForm.Get("code_verifier"))
+	if err == errInvalidGrant {
+		logger.Warn("Invalid authorization code", zap.String("clientId", client.ClientID))
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "The code is invalid, expired or was issued to another client")
+		return
+	}
+	var user model.User
+	if err == nil {
+		err = userDbConnector.First(&user, record.UserID).Error
+	}
+	var data *userpb.Responsedata
+	if err == nil {
+		data, err = userServiceManager.issueClientTokens(requestContext(r), &user, "", client.ClientID)
+	}
+	var idToken string
+	if err == nil {
+		idToken, err = userServiceManager.jwtManager.GenerateIDToken(&user, client.ClientID, record.Nonce, record.AuthTime)
+	}
+	if err != nil {
+		logger.Error("Failed to exchange authorization code", zap.String("clientId", client.ClientID), zap.Error(err))
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Please try again later")
+		return
+	}
+	logger.Info("Tokens issued to oauth client", zap.String("userEmail", user.Email), zap.String("clientId", client.ClientID))
+	writeOAuthJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  data.Token,
+		"token_type":    "Bearer",
+		"expires_in":    int64(userServiceManager.jwtManager.TokenDuration().Seconds()),
+		"refresh_token": data.RefreshToken,
+		"id_token":      idToken,
+		"scope":         record.Scope,
+	})
+}
+
+func (userServiceManager *UserService) userInfo(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var user model.User
+	accessToken, ok := bearerToken(r)
+	principal, err := userServiceManager.jwtManager.Authenticate(accessToken)
+	if ok && err == nil {
+		err = loadPrincipalUser(principal, &user)
+	}
+	if !ok || err != nil {
+		logger.Warn("Invalid userinfo access token", zap.Error(err))
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "The access token is invalid")
+		return
+	}
+	writeOAuthJSON(w, http.StatusOK, map[string]interface{}{
+		"sub":            strconv.FormatUint(uint64(user.ID), 10),
+		"email":          user.Email,
+		"email_verified": !user.EmailVerificationPending,
+		"name":           user.Name,
+	})
+}
+
+// startSsoSession stores the login of the access token in a cookie, so the
+// authorize endpoint recognizes the user.
+func (userServiceManager *UserService) startSsoSession(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	var user model.User
+	accessToken, ok := bearerToken(r)
+	principal, err := userServiceManager.jwtManager.Authenticate(accessToken)
+	if ok && err == nil {
+		err = loadPrincipalUser(principal, &user)
+	}
+	if !ok || err != nil {
+		logger.Warn("Invalid access token for sso session", zap.Error(err))
+		writeOAuthError(w, http.StatusUnauthorized, "invalid_token", "The access token is invalid")
+		return
+	}
+	sessionToken, err := userServiceManager.jwtManager.GenerateSessionToken(&user, ssoSessionDuration)
+	if err != nil {
+		logger.Error("Failed to start sso session", zap.String("userEmail", user.Email), zap.Error(err))
+		writeOAuthError(w, http.StatusInternalServerError, "server_error", "Please try again later")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoCookieName,
+		Value:    sessionToken,
+		Path:     "/oauth2",
+		MaxAge:   int(ssoSessionDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(userServiceManager.jwtManager.Issuer(), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	logger.Info("Sso session started", zap.String("userEmail", user.Email))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// endSsoSession revokes the session of the cookie, the frontends keep their
+// own tokens until they log out.
+func (userServiceManager *UserService) endSsoSession(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+	if cookie, err := r.Cookie(ssoCookieName); err == nil {
+		principal, err := userServiceManager.jwtManager.VerifySessionToken(cookie.Value)
+		if err == nil {
+			err = userServiceManager.jwtManager.RevokeToken(principal.TokenID, principal.TokenExpiresAt)
+		}
+		if err != nil {
+			logger.Warn("Failed to revoke sso session", zap.Error(err))
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoCookieName,
+		Path:     "/oauth2",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(userServiceManager.jwtManager.Issuer(), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"auth-microservice/federation"
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+const testClientRedirectURI = "http://localhost:3001/callback"
+
+// newTestOIDCServer serves the OpenID provider routes, with the server URL
+// as issuer like in production.
+func newTestOIDCServer(t *testing.T) (*UserService, *httptest.Server) {
+	service, _ := newTestUserService(t)
+	keySet, err := jwt.LoadKeySet(t.TempDir(), jwt.AlgorithmRS256)
+	require.Nil(t, err)
+	service.jwtManager.UseKeySet(keySet)
+	service.oidcLoginURL = "http://localhost:3000/login"
+	gwmux := runtime.NewServeMux()
+	require.Nil(t, gwmux.HandlePath("GET", "/.well-known/jwks.json", service.jwtManager.JWKSHandler))
+	require.Nil(t, registerOIDCRoutes(gwmux, service))
+	server := httptest.NewServer(gwmux)
+	t.Cleanup(server.Close)
+	service.jwtManager.UseClaimsValidation(server.URL, defaultTokenAudience, time.Minute)
+	return service, server
+}
+
+// noRedirectClient returns redirects instead of following them.
+var noRedirectClient = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}}
+
+func startTestSsoSession(t *testing.T, service *UserService, server *httptest.Server, user *model.User) *http.Cookie {
+	accessToken, err := service.jwtManager.GenerateToken(user, "")
+	require.Nil(t, err)
+	request, err := http.NewRequest("POST", server.URL+"/oauth2/session", nil)
+	require.Nil(t, err)
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+	response, err := http.DefaultClient.Do(request)
+	require.Nil(t, err)
+	response.Body.Close()
+	require.Equal(t, http.StatusNoContent, response.StatusCode)
+	require.Len(t, response.Cookies(), 1)
+	return response.Cookies()[0]
+}
+
+func authorizeRedirect(t *testing.T, authCodeURL string, cookie *http.Cookie) *url.URL {
+	request, err := http.NewRequest("GET", authCodeURL, nil)
+	require.Nil(t, err)
+	if cookie != nil {
+		request.AddCookie(cookie)
+	}
+	response, err := noRedirectClient.Do(request)
+	require.Nil(t, err)
+	response.Body.Close()
+	require.Equal(t, http.StatusFound, response.StatusCode)
+	location, err := url.Parse(response.Header.Get("Location"))
+	require.Nil(t, err)
+	return location
+}
+
+func TestOIDCProviderLogin(t *testing.T) {
+	service, server := newTestOIDCServer(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	created, err := service.CreateOAuthClient(context.Background(), &userpb.CreateOAuthClientRequest{
+		Name: "Owner dashboard", RedirectUris: []string{testClientRedirectURI}, Confidential: true})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), created.StatusCode)
+
+	// the frontend is a relying party like any other
+	ctx := context.Background()
+	provider, err := federation.NewOIDCProvider(ctx, "meal-mingle", server.URL, oauth2.Config{
+		ClientID: created.Data.ClientId, ClientSecret: created.Data.ClientSecret, RedirectURL: testClientRedirectURI})
+	require.Nil(t, err)
+	verifier := oauth2.GenerateVerifier()
+	authCodeURL := provider.AuthCodeURL("state-1", "nonce-1", verifier)
+
+	// without an SSO session the user is sent to the login page
+	location := authorizeRedirect(t, authCodeURL, nil)
+	assert.Equal(t, "localhost:3000", location.Host)
+	assert.Equal(t, authCodeURL, location.Query().Get("return_to"))
+
+	cookie := startTestSsoSession(t, service, server, &user)
+	location = authorizeRedirect(t, authCodeURL, cookie)
+	assert.Equal(t, "localhost:3001", location.Host)
+	assert.Equal(t, "state-1", location.Query().Get("state"))
+	code := location.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	identity, err := provider.Exchange(ctx, code, verifier, "nonce-1")
+	require.Nil(t, err)
+	assert.Equal(t, user.Email, identity.Email)
+	assert.Equal(t, "1", identity.Subject)
+
+	// the code is used up
+	_, err = provider.Exchange(ctx, code, verifier, "nonce-1")
+	assert.NotNil(t, err)
+
+	// a code only works with the verifier of its challenge
+	location = authorizeRedirect(t, provider.AuthCodeURL("state-2", "nonce-2", verifier), cookie)
+	_, err = provider.Exchange(ctx, location.Query().Get("code"), oauth2.GenerateVerifier(), "nonce-2")
+	assert.NotNil(t, err)
+
+	// the session ends with a logout
+	request, err := http.NewRequest("DELETE", server.URL+"/oauth2/session", nil)
+	require.Nil(t, err)
+	request.AddCookie(cookie)
+	response, err := http.DefaultClient.Do(request)
+	require.Nil(t, err)
+	response.Body.Close()
+	location = authorizeRedirect(t, authCodeURL, cookie)
+	assert.Equal(t, "localhost:3000", location.Host)
+}
+
+func TestOIDCProviderTokenAndUserInfo(t *testing.T) {
+	service, server := newTestOIDCServer(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	created, err := service.CreateOAuthClient(context.Background(), &userpb.CreateOAuthClientRequest{
+		Name: "Customer app", RedirectUris: []string{testClientRedirectURI}})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), created.StatusCode)
+	assert.Empty(t, created.Data.ClientSecret)
+
+	config := oauth2.Config{ClientID: created.Data.ClientId, RedirectURL: testClientRedirectURI,
+		Endpoint: oauth2.Endpoint{AuthURL: server.URL + "/oauth2/authorize", TokenURL: server.URL + "/oauth2/token",
+			AuthStyle: oauth2.AuthStyleInParams},
+		Scopes: []string{"openid", "email"}}
+	verifier := oauth2.GenerateVerifier()
+	cookie := startTestSsoSession(t, service, server, &user)
+	location := authorizeRedirect(t, config.AuthCodeURL("state", oauth2.S256ChallengeOption(verifier)), cookie)
+	token, err := config.Exchange(context.Background(), location.Query().Get("code"), oauth2.VerifierOption(verifier))
+	require.Nil(t, err)
+	assert.Equal(t, "openid email", token.Extra("scope"))
+
+	response, err := config.Client(context.Background(), token).Get(server.URL + "/oauth2/userinfo")
+	require.Nil(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	var claims map[string]interface{}
+	require.Nil(t, json.NewDecoder(response.Body).Decode(&claims))
+	assert.Equal(t, user.Email, claims["email"])
+
+	// the refresh token is rotated like the one from the login RPCs
+	refreshed, err := config.TokenSource(context.Background(), &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+	require.Nil(t, err)
+	assert.NotEqual(t, token.RefreshToken, refreshed.RefreshToken)
+}
+
+func TestOIDCRefreshTokenIsBoundToTheClient(t *testing.T) {
+	service, server := newTestOIDCServer(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	clientConfig := func(name string) oauth2.Config {
+		created, err := service.CreateOAuthClient(context.Background(), &userpb.CreateOAuthClientRequest{
+			Name: name, RedirectUris: []string{testClientRedirectURI}})
+		require.Nil(t, err)
+		require.Equal(t, int64(StatusCreated), created.StatusCode)
+		return oauth2.Config{ClientID: created.Data.ClientId, RedirectURL: testClientRedirectURI,
+			Endpoint: oauth2.Endpoint{AuthURL: server.URL + "/oauth2/authorize", TokenURL: server.URL + "/oauth2/token",
+				AuthStyle: oauth2.AuthStyleInParams},
+			Scopes: []string{"openid"}}
+	}
+	clientA, clientB := clientConfig("Customer app"), clientConfig("Owner dashboard")
+	verifier := oauth2.GenerateVerifier()
+	cookie := startTestSsoSession(t, service, server, &user)
+	location := authorizeRedirect(t, clientA.AuthCodeURL("state", oauth2.S256ChallengeOption(verifier)), cookie)
+	token, err := clientA.Exchange(context.Background(), location.Query().Get("code"), oauth2.VerifierOption(verifier))
+	require.Nil(t, err)
+
+	// client B cannot redeem the refresh token of client A
+	_, err = clientB.TokenSource(context.Background(), &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+	var retrieveError *oauth2.RetrieveError
+	require.ErrorAs(t, err, &retrieveError)
+	assert.Equal(t, "invalid_grant", retrieveError.ErrorCode)
+
+	// nor can the login RPCs, and the token still works for client A
+	response, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: token.RefreshToken})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), response.StatusCode)
+	refreshed, err := clientA.TokenSource(context.Background(), &oauth2.Token{RefreshToken: token.RefreshToken}).Token()
+	require.Nil(t, err)
+
+	// the rotated token stays bound to client A
+	_, err = clientB.TokenSource(context.Background(), &oauth2.Token{RefreshToken: refreshed.RefreshToken}).Token()
+	assert.NotNil(t, err)
+
+	// refresh tokens of the login RPCs are not accepted at the token endpoint
+	login, err := service.issueTokens(context.Background(), &user, "")
+	require.Nil(t, err)
+	_, err = clientA.TokenSource(context.Background(), &oauth2.Token{RefreshToken: login.RefreshToken}).Token()
+	require.ErrorAs(t, err, &retrieveError)
+	assert.Equal(t, "invalid_grant", retrieveError.ErrorCode)
+}
+
+func TestAuthorizeRejectsUnregisteredRedirectURI(t *testing.T) {
+	service, server := newTestOIDCServer(t)
+	created, err := service.CreateOAuthClient(context.Background(), &userpb.CreateOAuthClientRequest{
+		Name: "Customer app", RedirectUris: []string{testClientRedirectURI}})
+	require.Nil(t, err)
+
+	query := url.Values{"client_id": {created.Data.ClientId}, "redirect_uri": {"https://evil.example.com/callback"},
+		"response_type": {"code"}, "scope": {"openid"}}
+	response, err := noRedirectClient.Get(server.URL + "/oauth2/authorize?" + query.Encode())
+	require.Nil(t, err)
+	response.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	// PKCE is required, the error goes back to the client
+	query.Set("redirect_uri", testClientRedirectURI)
+	location := authorizeRedirect(t, server.URL+"/oauth2/authorize?"+query.Encode(), nil)
+	assert.Equal(t, "invalid_request", location.Query().Get("error"))
+}
+
+func TestCreateOAuthClientValidatesRedirectURIs(t *testing.T) {
+	service, _ := newTestUserService(t)
+	for _, redirectURI := range []string{"", "/callback", "http://example.com/callback", "https://example.com/#fragment"} {
+		response, err := service.CreateOAuthClient(context.Background(), &userpb.CreateOAuthClientRequest{
+			Name: "client", RedirectUris: []string{redirectURI}})
+		require.Nil(t, err)
+		assert.Equal(t, int64(StatusBadRequest), response.StatusCode, redirectURI)
+	}
+	var count int64
+	userDbConnector.Model(&model.OAuthClient{}).Count(&count)
+	assert.Zero(t, count)
+}
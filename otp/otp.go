@@ -0,0 +1,35 @@
+package otp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+)
+
+// GenerateCode returns a random numeric code with the given number of digits.
+func GenerateCode(digits int) (string, error) {
+	code := make([]byte, digits)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
+
+// HashCode returns the value under which a code is stored. The phone number
+// and purpose are part of the MAC so a stored hash only matches its own code.
+func HashCode(secret []byte, phone string, purpose string, code string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(phone + "|" + purpose + "|" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckCode compares code against a stored hash in constant time.
+func CheckCode(secret []byte, phone string, purpose string, code string, hash string) bool {
+	return hmac.Equal([]byte(HashCode(secret, phone, purpose, code)), []byte(hash))
+}
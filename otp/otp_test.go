@@ -0,0 +1,27 @@
+package otp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateCode(t *testing.T) {
+	code, err := GenerateCode(6)
+	assert.Nil(t, err)
+	assert.Len(t, code, 6)
+	for _, digit := range code {
+		assert.True(t, digit >= '0' && digit <= '9')
+	}
+}
+
+func TestCheckCode(t *testing.T) {
+	secret := []byte("secret")
+	hash := HashCode(secret, "9876543210", "phone_verification", "123456")
+
+	assert.True(t, CheckCode(secret, "9876543210", "phone_verification", "123456", hash))
+	assert.False(t, CheckCode(secret, "9876543210", "phone_verification", "654321", hash))
+	assert.False(t, CheckCode(secret, "9876543211", "phone_verification", "123456", hash))
+	assert.False(t, CheckCode(secret, "9876543210", "login", "123456", hash))
+	assert.False(t, CheckCode([]byte("other"), "9876543210", "phone_verification", "123456", hash))
+}
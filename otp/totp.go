@@ -0,0 +1,151 @@
+package otp
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TotpDigits and TotpPeriod are the defaults every authenticator app
+	// supports, RFC 6238 with HMAC-SHA1.
+	TotpDigits = 6
+	TotpPeriod = 30 * time.Second
+
+	totpSecretLength = 20
+	// sealedTotpPrefix marks secrets sealed by SealTotpSecret, the base32
+	// secrets stored before never contain it
+	sealedTotpPrefix = "sealed:"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTotpSecret returns a random base32 secret to share with an
+// authenticator app.
+func GenerateTotpSecret() (string, error) {
+	secret := make([]byte, totpSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// totpCipher returns the AES-256-GCM cipher keyed from key. The key is
+// derived so the server key can also key other hashes.
+func totpCipher(key []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("totp-secret"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealTotpSecret encrypts secret with the server key, so the stored value
+// does not reveal the seed of the authenticator.
+func SealTotpSecret(key []byte, secret string) (string, error) {
+	aead, err := totpCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), nil)
+	return sealedTotpPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenTotpSecret decrypts a secret sealed by SealTotpSecret. Secrets stored
+// before they were sealed are returned as they are.
+func OpenTotpSecret(key []byte, stored string) (string, error) {
+	if !IsSealedTotpSecret(stored) {
+		return stored, nil
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedTotpPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid sealed totp secret: %w", err)
+	}
+	aead, err := totpCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid sealed totp secret")
+	}
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid sealed totp secret: %w", err)
+	}
+	return string(secret), nil
+}
+
+// IsSealedTotpSecret tells if a stored secret was sealed by SealTotpSecret.
+func IsSealedTotpSecret(stored string) bool {
+	return strings.HasPrefix(stored, sealedTotpPrefix)
+}
+
+// TotpStep returns the number of the time step t falls into.
+func TotpStep(t time.Time) int64 {
+	return t.Unix() / int64(TotpPeriod/time.Second)
+}
+
+// TotpCode returns the code of secret for a time step.
+func TotpCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid totp secret: %w", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TotpDigits, value%1000000), nil
+}
+
+// ValidateTotp checks code against the steps around t, allowing skew steps of
+// clock drift either way. It returns the matching step, callers reject steps
+// that are not after the last accepted one so a code cannot be replayed.
+func ValidateTotp(secret string, code string, t time.Time, skew int64) (int64, bool) {
+	if len(code) != TotpDigits {
+		return 0, false
+	}
+	current := TotpStep(t)
+	for step := current - skew; step <= current+skew; step++ {
+		expected, err := TotpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TotpURI returns the otpauth:// URI authenticator apps read from a QR code.
+func TotpURI(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TotpDigits))
+	query.Set("period", fmt.Sprint(int(TotpPeriod.Seconds())))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
@@ -0,0 +1,75 @@
+package otp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the SHA1 test vectors of RFC 6238, truncated to six digits
+func TestTotpCodeRFC6238(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	for unix, expected := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		code, err := TotpCode(secret, TotpStep(time.Unix(unix, 0)))
+		require.Nil(t, err)
+		assert.Equal(t, expected, code)
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	secret, err := GenerateTotpSecret()
+	require.Nil(t, err)
+	now := time.Now()
+	code, err := TotpCode(secret, TotpStep(now.Add(-TotpPeriod)))
+	require.Nil(t, err)
+
+	step, ok := ValidateTotp(secret, code, now, 1)
+	assert.True(t, ok)
+	assert.Equal(t, TotpStep(now)-1, step)
+
+	_, ok = ValidateTotp(secret, code, now, 0)
+	assert.False(t, ok)
+	_, ok = ValidateTotp(secret, "12345", now, 1)
+	assert.False(t, ok)
+}
+
+func TestTotpURI(t *testing.T) {
+	uri, err := url.Parse(TotpURI("Meal Mingle", "owner@example.com", "JBSWY3DPEHPK3PXP"))
+	require.Nil(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/Meal Mingle:owner@example.com", uri.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+	assert.Equal(t, "Meal Mingle", uri.Query().Get("issuer"))
+}
+
+func TestSealTotpSecret(t *testing.T) {
+	secret, err := GenerateTotpSecret()
+	require.Nil(t, err)
+	sealed, err := SealTotpSecret([]byte("server-key"), secret)
+	require.Nil(t, err)
+	assert.True(t, IsSealedTotpSecret(sealed))
+	assert.NotContains(t, sealed, secret)
+
+	opened, err := OpenTotpSecret([]byte("server-key"), sealed)
+	require.Nil(t, err)
+	assert.Equal(t, secret, opened)
+
+	_, err = OpenTotpSecret([]byte("other-key"), sealed)
+	assert.NotNil(t, err)
+
+	// secrets stored before sealing are read as they are
+	opened, err = OpenTotpSecret([]byte("server-key"), secret)
+	require.Nil(t, err)
+	assert.Equal(t, secret, opened)
+	assert.False(t, IsSealedTotpSecret(secret))
+}
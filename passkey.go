@@ -0,0 +1,129 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	passkeyChallengeDuration = 5 * time.Minute
+
+	passkeyPurposeRegistration = "registration"
+	passkeyPurposeLogin        = "login"
+)
+
+var errPasskeyChallengeNotFound = errors.New("passkey challenge not found or expired")
+
+// newWebAuthn reads the relying party from WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME
+// and WEBAUTHN_RP_ORIGINS, a comma separated list of the origins of the
+// frontends.
+func newWebAuthn() (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          getEnv("WEBAUTHN_RP_ID", "localhost"),
+		RPDisplayName: getEnv("WEBAUTHN_RP_NAME", "Meal Mingle"),
+		RPOrigins:     strings.Split(getEnv("WEBAUTHN_RP_ORIGINS", "http://localhost:3000"), ","),
+		// passkeys are discoverable and verify the user, so they replace the
+		// password and the second factor
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+		AttestationPreference: protocol.PreferNoAttestation,
+	})
+}
+
+// passkeyUser adapts a user and their passkeys to the webauthn library.
+type passkeyUser struct {
+	user        *model.User
+	credentials []webauthn.Credential
+}
+
+// passkeyUserHandle is the id the authenticator stores with the passkey and
+// returns on login.
+func passkeyUserHandle(userID uint) []byte {
+	return []byte(strconv.FormatUint(uint64(userID), 10))
+}
+
+func (user *passkeyUser) WebAuthnID() []byte {
+	return passkeyUserHandle(user.user.ID)
+}
+
+func (user *passkeyUser) WebAuthnName() string {
+	return user.user.Email
+}
+
+func (user *passkeyUser) WebAuthnDisplayName() string {
+	return user.user.Name
+}
+
+func (user *passkeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (user *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	return user.credentials
+}
+
+// loadPasskeyUser loads the passkeys of user.
+func loadPasskeyUser(user *model.User) (*passkeyUser, error) {
+	var records []model.PasskeyCredential
+	if err := userDbConnector.Where("user_id = ?", user.ID).Find(&records).Error; err != nil {
+		return nil, err
+	}
+	credentials := make([]webauthn.Credential, len(records))
+	for i, record := range records {
+		if err := json.Unmarshal([]byte(record.Data), &credentials[i]); err != nil {
+			return nil, err
+		}
+	}
+	return &passkeyUser{user: user, credentials: credentials}, nil
+}
+
+func encodeCredentialID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// savePasskeyChallenge stores the session of a begin request and returns the
+// id the finish request has to send.
+func savePasskeyChallenge(userID uint, purpose string, session *webauthn.SessionData) (string, error) {
+	sessionID, err := jwt.GenerateID()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	return sessionID, userDbConnector.Create(&model.PasskeyChallenge{
+		SessionID:   sessionID,
+		UserID:      userID,
+		Purpose:     purpose,
+		SessionData: string(data),
+		ExpiresAt:   time.Now().Add(passkeyChallengeDuration),
+	}).Error
+}
+
+// consumePasskeyChallenge deletes a challenge and returns its session, so
+// every challenge is answered at most once.
+func consumePasskeyChallenge(sessionID string, userID uint, purpose string) (*webauthn.SessionData, error) {
+	var challenge model.PasskeyChallenge
+	err := consumeOnce(&challenge, errPasskeyChallengeNotFound, "session_id = ? AND user_id = ? AND purpose = ? AND expires_at > ?",
+		sessionID, userID, purpose, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(challenge.SessionData), &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.uber.org/zap"
+)
+
+func (userServiceManager *UserService) BeginPasskeyLogin(ctx context.Context, request *userpb.BeginPasskeyLoginRequest) (*userpb.BeginPasskeyLoginResponse, error) {
+	logger.Info("Received BeginPasskeyLogin request")
+	// the login is discoverable, the passkey the user picks tells who logs in
+	var options []byte
+	var sessionID string
+	assertion, session, err := userServiceManager.webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err == nil {
+		options, err = json.Marshal(assertion)
+	}
+	if err == nil {
+		sessionID, err = savePasskeyChallenge(0, passkeyPurposeLogin, session)
+	}
+	if err != nil {
+		logger.Error("Failed to begin passkey login", zap.Error(err))
+		return &userpb.BeginPasskeyLoginResponse{
+			Data:       nil,
+			Message:    "Failed to login, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	return &userpb.BeginPasskeyLoginResponse{
+		Data: &userpb.BeginPasskeyResponseData{
+			SessionId: sessionID,
+			Options:   string(options),
+		},
+		Message:    "Sign the challenge with a passkey and send it to finish the login.",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+func (userServiceManager *UserService) FinishPasskeyLogin(ctx context.Context, request *userpb.FinishPasskeyLoginRequest) (*userpb.FinishPasskeyLoginResponse, error) {
+	logger.Info("Received FinishPasskeyLogin request")
+	if request.SessionId == "" || request.Credential == "" {
+		logger.Warn("Invalid request fields")
+		return &userpb.FinishPasskeyLoginResponse{
+			Data:       nil,
+			Message:    "The request contains missing or invalid fields.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	session, err := consumePasskeyChallenge(request.SessionId, 0, passkeyPurposeLogin)
+	if err == errPasskeyChallengeNotFound {
+		logger.Warn("Passkey login challenge not found")
+		return &userpb.FinishPasskeyLoginResponse{
+			Data:       nil,
+			Message:    "The login expired, Please start again.",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	if err != nil {
+		logger.Error("Failed to finish passkey login", zap.Error(err))
+		return &userpb.FinishPasskeyLoginResponse{
+			Data:       nil,
+			Message:    "Failed to login, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	var user model.User
+	var record model.PasskeyCredential
+	// findUser looks up the owner of the passkey the authenticator answered with
+	findUser := func(rawID []byte, userHandle []byte) (webauthn.User, error) {
+		if err := userDbConnector.Where("credential_id = ?", encodeCredentialID(rawID)).First(&record).Error; err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(userHandle, passkeyUserHandle(record.UserID)) {
+			return nil, errors.New("user handle does not match the passkey")
+		}
+		if err := userDbConnector.First(&user, record.UserID).Error; err != nil {
+			return nil, err
+		}
+		return loadPasskeyUser(&user)
+	}
+	var credential *webauthn.Credential
+	parsed, err := protocol.ParseCredentialRequestResponseBody(strings.NewReader(request.Credential))
+	if err == nil {
+		credential, err = userServiceManager.webAuthn.ValidateDiscoverableLogin(findUser, *session, parsed)
+	}
+	// a counter that went backwards means the passkey was cloned
+	if err == nil && credential.Authenticator.CloneWarning {
+		err = errors.New("signature counter went backwards")
+	}
+	if err != nil {
+		logger.Warn("Passkey login failed", zap.String("userEmail", user.Email), zap.Error(err))
+		return &userpb.FinishPasskeyLoginResponse{
+			Data:       nil,
+			Message:    "The passkey could not be verified",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	data, err := json.Marshal(credential)
+	if err == nil {
+		err = userDbConnector.Model(&record).Updates(map[string]interface{}{
+			"data":         string(data),
+			"last_used_at": time.Now(),
+		}).Error
+	}
+	if err != nil {
+		logger.Error("Failed to update passkey", zap.String("userEmail", user.Email), zap.Error(err))
+		return &userpb.FinishPasskeyLoginResponse{
+			Data:       nil,
+			Message:    "Failed to login, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	tokens, err := userServiceManager.issueTokens(ctx, &user, "")
+	if err != nil {
+		logger.Error("Error in generating token", zap.String("userEmail", user.Email), zap.Error(err))
+		return &userpb.FinishPasskeyLoginResponse{
+			Data:       nil,
+			Message:    "Security Issues, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("User authenticated with passkey successfully", zap.String("userEmail", user.Email))
+	return &userpb.FinishPasskeyLoginResponse{
+		Data:       tokens,
+		Message:    "User authenticated successfully",
+		Error:      "",
+		StatusCode: StatusCreated,
+	}, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.uber.org/zap"
+)
+
+func (userServiceManager *UserService) BeginPasskeyRegistration(ctx context.Context, request *userpb.BeginPasskeyRegistrationRequest) (*userpb.BeginPasskeyRegistrationResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.BeginPasskeyRegistrationResponse{
+			Data:       nil,
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received BeginPasskeyRegistration request", zap.String("userEmail", userEmail))
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.BeginPasskeyRegistrationResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	var options []byte
+	var sessionID string
+	webAuthnUser, err := loadPasskeyUser(&user)
+	if err == nil {
+		// the authenticator refuses to create a second passkey for this account
+		exclusions := make([]protocol.CredentialDescriptor, len(webAuthnUser.credentials))
+		for i, credential := range webAuthnUser.credentials {
+			exclusions[i] = credential.Descriptor()
+		}
+		var creation *protocol.CredentialCreation
+		var session *webauthn.SessionData
+		creation, session, err = userServiceManager.webAuthn.BeginRegistration(webAuthnUser, webauthn.WithExclusions(exclusions))
+		if err == nil {
+			options, err = json.Marshal(creation)
+		}
+		if err == nil {
+			sessionID, err = savePasskeyChallenge(user.ID, passkeyPurposeRegistration, session)
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to begin passkey registration", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.BeginPasskeyRegistrationResponse{
+			Data:       nil,
+			Message:    "Failed to register the passkey, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	return &userpb.BeginPasskeyRegistrationResponse{
+		Data: &userpb.BeginPasskeyResponseData{
+			SessionId: sessionID,
+			Options:   string(options),
+		},
+		Message:    "Create the passkey with the options and send it to finish the registration.",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+func (userServiceManager *UserService) FinishPasskeyRegistration(ctx context.Context, request *userpb.FinishPasskeyRegistrationRequest) (*userpb.FinishPasskeyRegistrationResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.FinishPasskeyRegistrationResponse{
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received FinishPasskeyRegistration request", zap.String("userEmail", userEmail))
+	if request.SessionId == "" || request.Credential == "" {
+		logger.Warn("Invalid request fields", zap.String("userEmail", userEmail))
+		return &userpb.FinishPasskeyRegistrationResponse{
+			Message:    "The request contains missing or invalid fields.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.FinishPasskeyRegistrationResponse{
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	session, err := consumePasskeyChallenge(request.SessionId, user.ID, passkeyPurposeRegistration)
+	if err == errPasskeyChallengeNotFound {
+		logger.Warn("Passkey registration challenge not found", zap.String("userEmail", userEmail))
+		return &userpb.FinishPasskeyRegistrationResponse{
+			Message:    "The registration expired, Please start again.",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	var webAuthnUser *passkeyUser
+	if err == nil {
+		webAuthnUser, err = loadPasskeyUser(&user)
+	}
+	if err != nil {
+		logger.Error("Failed to finish passkey registration", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.FinishPasskeyRegistrationResponse{
+			Message:    "Failed to register the passkey, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	var credential *webauthn.Credential
+	parsed, err := protocol.ParseCredentialCreationResponseBody(strings.NewReader(request.Credential))
+	if err == nil {
+		credential, err = userServiceManager.webAuthn.CreateCredential(webAuthnUser, *session, parsed)
+	}
+	if err != nil {
+		logger.Warn("Invalid passkey", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.FinishPasskeyRegistrationResponse{
+			Message:    "The passkey could not be verified",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	name := request.Name
+	if name == "" {
+		name = "Passkey"
+	}
+	data, err := json.Marshal(credential)
+	if err == nil {
+		err = userDbConnector.Create(&model.PasskeyCredential{
+			UserID:       user.ID,
+			CredentialID: encodeCredentialID(credential.ID),
+			Name:         name,
+			Data:         string(data),
+		}).Error
+	}
+	if err != nil {
+		logger.Error("Failed to save passkey", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.FinishPasskeyRegistrationResponse{
+			Message:    "Failed to register the passkey, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Passkey registered", zap.String("userEmail", userEmail))
+	return &userpb.FinishPasskeyRegistrationResponse{
+		Message:    "Passkey registered successfully",
+		Error:      "",
+		StatusCode: StatusCreated,
+	}, nil
+}
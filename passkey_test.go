@@ -0,0 +1,202 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPasskeyOrigin = "http://localhost:3000"
+
+func newTestWebAuthn(t *testing.T) *webauthn.WebAuthn {
+	t.Setenv("WEBAUTHN_RP_ID", "localhost")
+	t.Setenv("WEBAUTHN_RP_ORIGINS", testPasskeyOrigin)
+	webAuthn, err := newWebAuthn()
+	require.Nil(t, err)
+	return webAuthn
+}
+
+// virtualAuthenticator creates and signs with a single ES256 passkey, like a
+// platform authenticator would.
+type virtualAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newVirtualAuthenticator(t *testing.T) *virtualAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	credentialID := make([]byte, 16)
+	_, err = rand.Read(credentialID)
+	require.Nil(t, err)
+	return &virtualAuthenticator{key: key, credentialID: credentialID}
+}
+
+var b64 = base64.RawURLEncoding
+
+func clientData(t *testing.T, ceremony string, challenge string) []byte {
+	data, err := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": testPasskeyOrigin})
+	require.Nil(t, err)
+	return data
+}
+
+// authenticatorData returns the rp id hash, the user present and verified
+// flags and the counter, followed by attested credential data if any.
+func (authenticator *virtualAuthenticator) authenticatorData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte("localhost"))
+	data := append(rpIDHash[:], flags|0x05)
+	data = binary.BigEndian.AppendUint32(data, authenticator.signCount)
+	return append(data, attested...)
+}
+
+func (authenticator *virtualAuthenticator) create(t *testing.T, options string) string {
+	var creation struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	require.Nil(t, json.Unmarshal([]byte(options), &creation))
+	userHandle, err := b64.DecodeString(creation.PublicKey.User.ID)
+	require.Nil(t, err)
+	authenticator.userHandle = userHandle
+
+	publicKey, err := cbor.Marshal(map[int]interface{}{
+		1: 2, 3: -7, -1: 1,
+		-2: authenticator.key.X.FillBytes(make([]byte, 32)),
+		-3: authenticator.key.Y.FillBytes(make([]byte, 32)),
+	})
+	require.Nil(t, err)
+	attested := make([]byte, 16)
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(authenticator.credentialID)))
+	attested = append(attested, authenticator.credentialID...)
+	attested = append(attested, publicKey...)
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authenticator.authenticatorData(0x40, attested),
+	})
+	require.Nil(t, err)
+
+	credential, err := json.Marshal(map[string]interface{}{
+		"id":    b64.EncodeToString(authenticator.credentialID),
+		"rawId": b64.EncodeToString(authenticator.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData(t, "webauthn.create", creation.PublicKey.Challenge)),
+			"attestationObject": b64.EncodeToString(attestation),
+		},
+	})
+	require.Nil(t, err)
+	return string(credential)
+}
+
+func (authenticator *virtualAuthenticator) sign(t *testing.T, options string) string {
+	var assertion struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	require.Nil(t, json.Unmarshal([]byte(options), &assertion))
+	authenticator.signCount++
+	authData := authenticator.authenticatorData(0, nil)
+	clientDataJSON := clientData(t, "webauthn.get", assertion.PublicKey.Challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, authenticator.key, digest[:])
+	require.Nil(t, err)
+
+	credential, err := json.Marshal(map[string]interface{}{
+		"id":    b64.EncodeToString(authenticator.credentialID),
+		"rawId": b64.EncodeToString(authenticator.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientDataJSON),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(authenticator.userHandle),
+		},
+	})
+	require.Nil(t, err)
+	return string(credential)
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	service, _ := newTestUserService(t)
+	service.webAuthn = newTestWebAuthn(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	ctx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: user.ID, Email: user.Email})
+	authenticator := newVirtualAuthenticator(t)
+
+	begin, err := service.BeginPasskeyRegistration(ctx, &userpb.BeginPasskeyRegistrationRequest{})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), begin.StatusCode)
+	credential := authenticator.create(t, begin.Data.Options)
+	finish, err := service.FinishPasskeyRegistration(ctx, &userpb.FinishPasskeyRegistrationRequest{
+		SessionId: begin.Data.SessionId, Credential: credential, Name: "Laptop"})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), finish.StatusCode)
+
+	// the registration challenge is gone once it was used
+	finish, err = service.FinishPasskeyRegistration(ctx, &userpb.FinishPasskeyRegistrationRequest{
+		SessionId: begin.Data.SessionId, Credential: credential})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), finish.StatusCode)
+
+	loginBegin, err := service.BeginPasskeyLogin(context.Background(), &userpb.BeginPasskeyLoginRequest{})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), loginBegin.StatusCode)
+	assertion := authenticator.sign(t, loginBegin.Data.Options)
+	login, err := service.FinishPasskeyLogin(context.Background(), &userpb.FinishPasskeyLoginRequest{
+		SessionId: loginBegin.Data.SessionId, Credential: assertion})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), login.StatusCode)
+	assert.Equal(t, user.Email, login.Data.User.UserEmail)
+	assert.NotEmpty(t, login.Data.Token)
+
+	var stored model.PasskeyCredential
+	require.Nil(t, userDbConnector.Where("user_id = ?", user.ID).First(&stored).Error)
+	assert.Equal(t, "Laptop", stored.Name)
+	assert.NotNil(t, stored.LastUsedAt)
+
+	// a replayed assertion does not match a new challenge
+	loginBegin, err = service.BeginPasskeyLogin(context.Background(), &userpb.BeginPasskeyLoginRequest{})
+	require.Nil(t, err)
+	login, err = service.FinishPasskeyLogin(context.Background(), &userpb.FinishPasskeyLoginRequest{
+		SessionId: loginBegin.Data.SessionId, Credential: assertion})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), login.StatusCode)
+}
+
+func TestFinishPasskeyLoginUnknownPasskey(t *testing.T) {
+	service, _ := newTestUserService(t)
+	service.webAuthn = newTestWebAuthn(t)
+	authenticator := newVirtualAuthenticator(t)
+	authenticator.userHandle = passkeyUserHandle(1)
+
+	begin, err := service.BeginPasskeyLogin(context.Background(), &userpb.BeginPasskeyLoginRequest{})
+	require.Nil(t, err)
+	login, err := service.FinishPasskeyLogin(context.Background(), &userpb.FinishPasskeyLoginRequest{
+		SessionId: begin.Data.SessionId, Credential: authenticator.sign(t, begin.Data.Options)})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), login.StatusCode)
+}
@@ -0,0 +1,74 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prefixLength is the length of the SHA-1 prefix used by the k-anonymity
+// range format of Pwned Passwords.
+const prefixLength = 5
+
+// BreachedRanges is a local copy of the breached password hashes in the
+// k-anonymity range format of Pwned Passwords, as written by its downloader:
+// one file per 5 character SHA-1 prefix, named like "5BAA6.txt", with one
+// "SUFFIX:count" line per hash that starts with the prefix. The full list is
+// too large to hold in memory, so a lookup only reads the range of the
+// password's prefix, the same way the range API answers.
+type BreachedRanges struct {
+	dir string
+}
+
+// OpenBreachedRanges uses the range files in dir.
+func OpenBreachedRanges(dir string) (*BreachedRanges, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not open breached password ranges: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached password ranges %s is not a directory", dir)
+	}
+	return &BreachedRanges{dir: dir}, nil
+}
+
+// IsBreached looks the password up in the range file of its prefix. A missing
+// range file means no breached password has the prefix.
+func (ranges *BreachedRanges) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+	f, err := os.Open(filepath.Join(ranges.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not open breached password range %s: %w", prefix, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rangeSuffix, _, _ := strings.Cut(text, ":")
+		if len(rangeSuffix) != sha1.Size*2-prefixLength {
+			return false, fmt.Errorf("breached password range %s line %d is not a SHA-1 suffix", prefix, line)
+		}
+		if strings.EqualFold(rangeSuffix, suffix) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("could not read breached password range %s: %w", prefix, err)
+	}
+	return false, nil
+}
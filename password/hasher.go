@@ -0,0 +1,203 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrMismatch is returned when a password does not match its hash.
+	ErrMismatch = errors.New("password does not match")
+	// ErrUnknownHash is returned for hashes no configured hasher can read.
+	ErrUnknownHash = errors.New("unknown password hash format")
+)
+
+// PasswordHasher hashes passwords into self-describing strings, so hashes of
+// different algorithms and parameters can be stored side by side.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Compare returns ErrMismatch if password does not match encoded.
+	Compare(encoded string, password string) error
+	// Identifies reports whether encoded was produced by this algorithm.
+	Identifies(encoded string) bool
+	// NeedsRehash reports whether encoded was produced with other parameters
+	// than the hasher is configured with.
+	NeedsRehash(encoded string) bool
+}
+
+// BcryptHasher produces the $2a$ hashes bcrypt has always stored.
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) (*BcryptHasher, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return &BcryptHasher{cost: cost}, nil
+}
+
+func (hasher *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), hasher.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (hasher *BcryptHasher) Compare(encoded string, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrMismatch
+	}
+	return err
+}
+
+func (hasher *BcryptHasher) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (hasher *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != hasher.cost
+}
+
+// Argon2Params are the argon2id cost parameters, Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id.
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{Memory: 19 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+// Argon2idHasher produces PHC strings like
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>.
+type Argon2idHasher struct {
+	params Argon2Params
+}
+
+func NewArgon2idHasher(params Argon2Params) (*Argon2idHasher, error) {
+	if params.Memory < 8*uint32(params.Parallelism) || params.Iterations < 1 || params.Parallelism < 1 ||
+		params.SaltLength < 8 || params.KeyLength < 16 {
+		return nil, fmt.Errorf("invalid argon2id parameters")
+	}
+	return &Argon2idHasher{params: params}, nil
+}
+
+func (hasher *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, hasher.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("could not generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, hasher.params.Iterations, hasher.params.Memory,
+		hasher.params.Parallelism, hasher.params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+		hasher.params.Memory, hasher.params.Iterations, hasher.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// decodeArgon2id parses a PHC string into its parameters, salt and key.
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, ErrUnknownHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+func (hasher *Argon2idHasher) Compare(encoded string, password string) error {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+	derived := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, derived) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (hasher *Argon2idHasher) Identifies(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (hasher *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, _, err := decodeArgon2id(encoded)
+	return err != nil || params != hasher.params
+}
+
+// Hashers hashes new passwords with the preferred hasher and verifies stored
+// hashes with whichever hasher produced them.
+type Hashers struct {
+	preferred PasswordHasher
+	hashers   []PasswordHasher
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+func NewHashers(preferred PasswordHasher, others ...PasswordHasher) *Hashers {
+	return &Hashers{preferred: preferred, hashers: append([]PasswordHasher{preferred}, others...)}
+}
+
+func (hashers *Hashers) Hash(password string) (string, error) {
+	return hashers.preferred.Hash(password)
+}
+
+func (hashers *Hashers) Compare(encoded string, password string) error {
+	for _, hasher := range hashers.hashers {
+		if hasher.Identifies(encoded) {
+			return hasher.Compare(encoded, password)
+		}
+	}
+	return ErrUnknownHash
+}
+
+// CompareDummy compares password against a hash of a random password. It
+// takes as long as Compare, for requests that have no stored hash to compare.
+func (hashers *Hashers) CompareDummy(password string) {
+	hashers.dummyOnce.Do(func() {
+		buf := make([]byte, 16)
+		rand.Read(buf)
+		hashers.dummyHash, _ = hashers.preferred.Hash(base64.RawStdEncoding.EncodeToString(buf))
+	})
+	hashers.preferred.Compare(hashers.dummyHash, password)
+}
+
+// NeedsRehash reports whether encoded should be replaced by a hash of the
+// preferred hasher, because it uses another algorithm or outdated parameters.
+func (hashers *Hashers) NeedsRehash(encoded string) bool {
+	return !hashers.preferred.Identifies(encoded) || hashers.preferred.NeedsRehash(encoded)
+}
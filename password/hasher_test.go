@@ -0,0 +1,86 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testArgon2Params() Argon2Params {
+	return Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher, err := NewArgon2idHasher(testArgon2Params())
+	require.Nil(t, err)
+
+	encoded, err := hasher.Hash("correct horse battery")
+	require.Nil(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	assert.True(t, hasher.Identifies(encoded))
+	assert.False(t, hasher.NeedsRehash(encoded))
+	assert.Nil(t, hasher.Compare(encoded, "correct horse battery"))
+	assert.Equal(t, ErrMismatch, hasher.Compare(encoded, "wrong horse battery"))
+
+	other, err := hasher.Hash("correct horse battery")
+	require.Nil(t, err)
+	assert.NotEqual(t, encoded, other, "hashes must be salted")
+
+	stronger, err := NewArgon2idHasher(Argon2Params{Memory: 2048, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	require.Nil(t, err)
+	assert.True(t, stronger.NeedsRehash(encoded))
+	assert.Nil(t, stronger.Compare(encoded, "correct horse battery"))
+}
+
+func TestArgon2idHasherInvalidHash(t *testing.T) {
+	hasher, err := NewArgon2idHasher(testArgon2Params())
+	require.Nil(t, err)
+
+	for _, encoded := range []string{"", "$argon2id$v=19$m=1024,t=1,p=1$salt", "$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5", "$argon2i$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5"} {
+		assert.Equal(t, ErrUnknownHash, hasher.Compare(encoded, "password"), encoded)
+	}
+}
+
+func TestBcryptHasher(t *testing.T) {
+	hasher, err := NewBcryptHasher(bcrypt.MinCost)
+	require.Nil(t, err)
+
+	encoded, err := hasher.Hash("correct horse battery")
+	require.Nil(t, err)
+	assert.True(t, hasher.Identifies(encoded))
+	assert.False(t, hasher.NeedsRehash(encoded))
+	assert.Nil(t, hasher.Compare(encoded, "correct horse battery"))
+	assert.Equal(t, ErrMismatch, hasher.Compare(encoded, "wrong horse battery"))
+
+	stronger, err := NewBcryptHasher(bcrypt.MinCost + 1)
+	require.Nil(t, err)
+	assert.True(t, stronger.NeedsRehash(encoded))
+
+	_, err = NewBcryptHasher(bcrypt.MaxCost + 1)
+	assert.NotNil(t, err)
+}
+
+func TestHashers(t *testing.T) {
+	argon2idHasher, err := NewArgon2idHasher(testArgon2Params())
+	require.Nil(t, err)
+	bcryptHasher, err := NewBcryptHasher(bcrypt.MinCost)
+	require.Nil(t, err)
+	hashers := NewHashers(argon2idHasher, bcryptHasher)
+
+	legacy, err := bcryptHasher.Hash("correct horse battery")
+	require.Nil(t, err)
+	assert.Nil(t, hashers.Compare(legacy, "correct horse battery"))
+	assert.Equal(t, ErrMismatch, hashers.Compare(legacy, "wrong horse battery"))
+	assert.True(t, hashers.NeedsRehash(legacy))
+
+	encoded, err := hashers.Hash("correct horse battery")
+	require.Nil(t, err)
+	assert.True(t, argon2idHasher.Identifies(encoded))
+	assert.Nil(t, hashers.Compare(encoded, "correct horse battery"))
+	assert.False(t, hashers.NeedsRehash(encoded))
+
+	assert.Equal(t, ErrUnknownHash, hashers.Compare("plaintext", "plaintext"))
+}
@@ -0,0 +1,144 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rules reported in a Violation.
+const (
+	RuleMinLength        = "min_length"
+	RuleMaxLength        = "max_length"
+	RuleUppercase        = "uppercase"
+	RuleLowercase        = "lowercase"
+	RuleDigit            = "digit"
+	RuleSymbol           = "symbol"
+	RuleContainsUserInfo = "contains_user_info"
+	RuleBreached         = "breached"
+)
+
+// bcryptMaxLength is the number of bytes bcrypt hashes, anything after is
+// silently ignored.
+const bcryptMaxLength = 72
+
+// Violation is a rule the password does not satisfy.
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// BreachChecker reports whether a password is known from a data breach.
+type BreachChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// Policy is the set of rules a new password has to satisfy.
+type Policy struct {
+	// MinLength and MaxLength are counted in characters, MaxLength is also
+	// capped at the 72 bytes bcrypt can hash.
+	MinLength        int
+	MaxLength        int
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	// RejectUserInfo rejects passwords that contain the user's name, email
+	// or phone number.
+	RejectUserInfo bool
+	// Breached is consulted when set.
+	Breached BreachChecker
+}
+
+// DefaultPolicy follows the NIST guidelines: long passwords that are not
+// known from breaches, without composition rules.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		MinLength:      8,
+		MaxLength:      bcryptMaxLength,
+		RejectUserInfo: true,
+	}
+}
+
+// Validate returns every rule password violates. userInfo are values like the
+// user's name and email that must not be part of the password. The error is
+// only set when the breached password check failed.
+func (policy *Policy) Validate(password string, userInfo ...string) ([]Violation, error) {
+	var violations []Violation
+	length := utf8.RuneCountInString(password)
+	if length < policy.MinLength {
+		violations = append(violations, Violation{RuleMinLength,
+			fmt.Sprintf("The password must be at least %d characters long.", policy.MinLength)})
+	}
+	if policy.MaxLength > 0 && length > policy.MaxLength {
+		violations = append(violations, Violation{RuleMaxLength,
+			fmt.Sprintf("The password must be at most %d characters long.", policy.MaxLength)})
+	} else if len(password) > bcryptMaxLength {
+		violations = append(violations, Violation{RuleMaxLength,
+			fmt.Sprintf("The password must be at most %d bytes long.", bcryptMaxLength)})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, char := range password {
+		switch {
+		case unicode.IsUpper(char):
+			hasUpper = true
+		case unicode.IsLower(char):
+			hasLower = true
+		case unicode.IsDigit(char):
+			hasDigit = true
+		case unicode.IsPunct(char) || unicode.IsSymbol(char) || unicode.IsSpace(char):
+			hasSymbol = true
+		}
+	}
+	if policy.RequireUppercase && !hasUpper {
+		violations = append(violations, Violation{RuleUppercase, "The password must contain an uppercase letter."})
+	}
+	if policy.RequireLowercase && !hasLower {
+		violations = append(violations, Violation{RuleLowercase, "The password must contain a lowercase letter."})
+	}
+	if policy.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{RuleDigit, "The password must contain a digit."})
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{RuleSymbol, "The password must contain a symbol."})
+	}
+
+	if policy.RejectUserInfo && containsUserInfo(password, userInfo) {
+		violations = append(violations, Violation{RuleContainsUserInfo,
+			"The password must not contain your name, email or phone number."})
+	}
+
+	if policy.Breached != nil {
+		breached, err := policy.Breached.IsBreached(password)
+		if err != nil {
+			return violations, err
+		}
+		if breached {
+			violations = append(violations, Violation{RuleBreached,
+				"The password appeared in a data breach, please choose a different one."})
+		}
+	}
+	return violations, nil
+}
+
+// containsUserInfo reports whether password contains one of the values, or
+// the local part of an email. Values shorter than 3 characters are ignored.
+func containsUserInfo(password string, userInfo []string) bool {
+	password = strings.ToLower(password)
+	for _, value := range userInfo {
+		value = strings.ToLower(strings.TrimSpace(value))
+		candidates := []string{value}
+		if local, _, found := strings.Cut(value, "@"); found {
+			candidates = append(candidates, local)
+		}
+		candidates = append(candidates, strings.Fields(value)...)
+		for _, candidate := range candidates {
+			if utf8.RuneCountInString(candidate) >= 3 && strings.Contains(password, candidate) {
+				return true
+			}
+		}
+	}
+	return false
+}
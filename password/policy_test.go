@@ -0,0 +1,120 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func violatedRules(violations []Violation) []string {
+	rules := []string{}
+	for _, violation := range violations {
+		rules = append(rules, violation.Rule)
+	}
+	return rules
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	policy := DefaultPolicy()
+
+	violations, err := policy.Validate("correct horse battery")
+	assert.Nil(t, err)
+	assert.Empty(t, violations)
+
+	violations, err = policy.Validate("short")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RuleMinLength}, violatedRules(violations))
+
+	violations, err = policy.Validate(strings.Repeat("a", 73))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RuleMaxLength}, violatedRules(violations))
+
+	// 30 characters but 90 bytes, bcrypt would ignore the end
+	violations, err = policy.Validate(strings.Repeat("€", 30))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RuleMaxLength}, violatedRules(violations))
+}
+
+func TestCharacterClasses(t *testing.T) {
+	policy := &Policy{MinLength: 1, RequireUppercase: true, RequireLowercase: true, RequireDigit: true, RequireSymbol: true}
+
+	violations, err := policy.Validate("password")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RuleUppercase, RuleDigit, RuleSymbol}, violatedRules(violations))
+
+	violations, err = policy.Validate("Passw0rd!")
+	assert.Nil(t, err)
+	assert.Empty(t, violations)
+}
+
+func TestRejectUserInfo(t *testing.T) {
+	policy := DefaultPolicy()
+
+	for _, password := range []string{"JohnSmith2024", "my-smith-password", "john.doe@example.com!", "9876543210xyz"} {
+		violations, err := policy.Validate(password, "John Smith", "john.doe@example.com", "9876543210")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{RuleContainsUserInfo}, violatedRules(violations), password)
+	}
+
+	violations, err := policy.Validate("correct horse battery", "Jo", "john.doe@example.com")
+	assert.Nil(t, err)
+	assert.Empty(t, violations)
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func writeBreachedRange(t *testing.T, dir string, hashes ...string) {
+	var content strings.Builder
+	for _, hash := range hashes {
+		content.WriteString(hash[prefixLength:] + ":12\r\n")
+	}
+	require.Nil(t, os.WriteFile(filepath.Join(dir, strings.ToUpper(hashes[0][:prefixLength])+".txt"), []byte(content.String()), 0o644))
+}
+
+func TestBreachedRanges(t *testing.T) {
+	dir := t.TempDir()
+	writeBreachedRange(t, dir, sha1Hex("password123"))
+	writeBreachedRange(t, dir, strings.ToLower(sha1Hex("letmein1234")))
+
+	ranges, err := OpenBreachedRanges(dir)
+	require.Nil(t, err)
+	policy := DefaultPolicy()
+	policy.Breached = ranges
+	violations, err := policy.Validate("password123")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RuleBreached}, violatedRules(violations))
+	violations, err = policy.Validate("letmein1234")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{RuleBreached}, violatedRules(violations))
+	violations, err = policy.Validate("correct horse battery")
+	assert.Nil(t, err)
+	assert.Empty(t, violations)
+}
+
+func TestBreachedRangesReportReadErrors(t *testing.T) {
+	_, err := OpenBreachedRanges(filepath.Join(t.TempDir(), "missing"))
+	assert.NotNil(t, err)
+
+	dir := t.TempDir()
+	hash := sha1Hex("password123")
+	require.Nil(t, os.WriteFile(filepath.Join(dir, hash[:prefixLength]+".txt"), []byte("not-a-suffix:12\n"), 0o644))
+	ranges, err := OpenBreachedRanges(dir)
+	require.Nil(t, err)
+	_, err = ranges.IsBreached("password123")
+	assert.NotNil(t, err)
+
+	// a range that cannot be read fails the check instead of passing it
+	require.Nil(t, os.Remove(filepath.Join(dir, hash[:prefixLength]+".txt")))
+	require.Nil(t, os.Mkdir(filepath.Join(dir, hash[:prefixLength]+".txt"), 0o755))
+	_, err = ranges.IsBreached("password123")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"auth-microservice/model"
+	"auth-microservice/password"
+	userpb "auth-microservice/proto/user"
+	"fmt"
+	"os"
+	"strconv"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newPasswordPolicy builds the password policy from the PASSWORD_* variables,
+// starting from the default policy.
+func newPasswordPolicy() (*password.Policy, error) {
+	policy := password.DefaultPolicy()
+	for key, value := range map[string]*int{
+		"PASSWORD_MIN_LENGTH": &policy.MinLength,
+		"PASSWORD_MAX_LENGTH": &policy.MaxLength,
+	} {
+		if env := os.Getenv(key); env != "" {
+			length, err := strconv.Atoi(env)
+			if err != nil || length < 0 {
+				return nil, fmt.Errorf("invalid %s %q", key, env)
+			}
+			*value = length
+		}
+	}
+	for key, value := range map[string]*bool{
+		"PASSWORD_REQUIRE_UPPERCASE": &policy.RequireUppercase,
+		"PASSWORD_REQUIRE_LOWERCASE": &policy.RequireLowercase,
+		"PASSWORD_REQUIRE_DIGIT":     &policy.RequireDigit,
+		"PASSWORD_REQUIRE_SYMBOL":    &policy.RequireSymbol,
+		"PASSWORD_REJECT_USER_INFO":  &policy.RejectUserInfo,
+	} {
+		if env := os.Getenv(key); env != "" {
+			*value = env == "true"
+		}
+	}
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		breached, err := password.OpenBreachedRanges(dir)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Checking passwords against the breached password ranges", zap.String("dir", dir))
+		policy.Breached = breached
+	}
+	return policy, nil
+}
+
+// newPasswordHashers builds the password hashers from PASSWORD_HASHER and the
+// BCRYPT_* and ARGON2_* cost variables. Hashes of the other algorithm are
+// still verified and upgraded on the next login.
+func newPasswordHashers() (*password.Hashers, error) {
+	bcryptCost, err := strconv.Atoi(getEnv("BCRYPT_COST", strconv.Itoa(bcrypt.DefaultCost)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid BCRYPT_COST: %w", err)
+	}
+	bcryptHasher, err := password.NewBcryptHasher(bcryptCost)
+	if err != nil {
+		return nil, err
+	}
+	params := password.DefaultArgon2Params()
+	for key, value := range map[string]*uint32{
+		"ARGON2_MEMORY_KIB": &params.Memory,
+		"ARGON2_ITERATIONS": &params.Iterations,
+	} {
+		if env := os.Getenv(key); env != "" {
+			parsed, err := strconv.ParseUint(env, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q", key, env)
+			}
+			*value = uint32(parsed)
+		}
+	}
+	if env := os.Getenv("ARGON2_PARALLELISM"); env != "" {
+		parsed, err := strconv.ParseUint(env, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARGON2_PARALLELISM %q", env)
+		}
+		params.Parallelism = uint8(parsed)
+	}
+	argon2idHasher, err := password.NewArgon2idHasher(params)
+	if err != nil {
+		return nil, err
+	}
+	switch getEnv("PASSWORD_HASHER", "argon2id") {
+	case "argon2id":
+		return password.NewHashers(argon2idHasher, bcryptHasher), nil
+	case "bcrypt":
+		return password.NewHashers(bcryptHasher, argon2idHasher), nil
+	default:
+		return nil, fmt.Errorf("unknown PASSWORD_HASHER %q", os.Getenv("PASSWORD_HASHER"))
+	}
+}
+
+const weakPasswordMessage = "The password does not meet the password requirements."
+
+// validateNewPassword checks a password the user wants to set against the
+// password policy, the violations are returned to the client.
+func (userServiceManager *UserService) validateNewPassword(newPassword string, user *model.User) ([]*userpb.PasswordViolation, error) {
+	violations, err := userServiceManager.passwordPolicy.Validate(newPassword, user.Name, user.Email, user.Phone)
+	if err != nil {
+		return nil, err
+	}
+	var response []*userpb.PasswordViolation
+	for _, violation := range violations {
+		response = append(response, &userpb.PasswordViolation{Rule: violation.Rule, Message: violation.Message})
+	}
+	return response, nil
+}
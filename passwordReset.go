@@ -0,0 +1,179 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/mailer"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const passwordResetTokenDuration = time.Hour
+
+var errPasswordResetTokenInvalid = errors.New("password reset token is invalid, expired or was already used")
+
+// sendPasswordResetEmail voids the user's earlier reset links and emails a new one.
+func (userServiceManager *UserService) sendPasswordResetEmail(ctx context.Context, user *model.User) error {
+	token, tokenHash, err := jwt.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	err = userDbConnector.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&model.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&model.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: tokenHash,
+			ExpiresAt: now.Add(passwordResetTokenDuration),
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+	link := getEnv("PASSWORD_RESET_URL", "http://localhost:3000/reset-password") + "?token=" + url.QueryEscape(token)
+	return userServiceManager.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Meal Mingle password",
+		Body: fmt.Sprintf("Hi %s,\n\nWe received a request to reset your password. Open the link below to choose "+
+			"a new one. The link expires in 1 hour and can only be used once.\n\n%s\n\n"+
+			"If you did not ask to reset your password, you can ignore this email.\n", user.Name, link),
+	})
+}
+
+// findPasswordResetToken returns the stored token if it is unused and not expired.
+func findPasswordResetToken(token string) (*model.PasswordResetToken, error) {
+	var stored model.PasswordResetToken
+	err := userDbConnector.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", jwt.HashOpaqueToken(token), time.Now()).
+		First(&stored).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, errPasswordResetTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// resetPassword uses the reset token, sets the new password and logs out every
+// session, whoever knew the old password must not stay logged in. Opening the
+// emailed link also proves the user owns the address.
+func resetPassword(stored *model.PasswordResetToken, hashedPassword string) error {
+	return userDbConnector.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", stored.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errPasswordResetTokenInvalid
+		}
+		err := tx.Model(&model.User{}).Where("id = ?", stored.UserID).Updates(map[string]interface{}{
+			"password":                   hashedPassword,
+			"email_verification_pending": false,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return revokeAllSessionsIn(tx, stored.UserID)
+	})
+}
+
+func (userServiceManager *UserService) RequestPasswordReset(ctx context.Context, request *userpb.RequestPasswordResetRequest) (*userpb.RequestPasswordResetResponse, error) {
+	userEmail := request.UserEmail
+	logger.Info("Received RequestPasswordReset request", zap.String("userEmail", userEmail))
+	if userEmail == "" {
+		return &userpb.RequestPasswordResetResponse{
+			Message:    "The request contains missing or invalid fields.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var user model.User
+	if err := userDbConnector.Where("email = ?", userEmail).First(&user).Error; err != nil {
+		logger.Warn("Password reset requested for unknown email", zap.String("userEmail", userEmail), zap.Error(err))
+	} else {
+		userServiceManager.deliverInBackground(ctx, "password reset email", func(ctx context.Context) error {
+			return userServiceManager.sendPasswordResetEmail(ctx, &user)
+		})
+	}
+	// the response is the same, and as fast, whether or not the email is
+	// registered and the email could be sent
+	return &userpb.RequestPasswordResetResponse{
+		Message:    "If the email is registered, a link to reset the password has been sent.",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+func (userServiceManager *UserService) ResetPassword(ctx context.Context, request *userpb.ResetPasswordRequest) (*userpb.ResetPasswordResponse, error) {
+	logger.Info("Received ResetPassword request")
+	if request.Token == "" || request.NewPassword == "" {
+		logger.Warn("Invalid request fields")
+		return &userpb.ResetPasswordResponse{
+			Message:    "The request contains missing or invalid fields.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var user model.User
+	stored, err := findPasswordResetToken(request.Token)
+	if err == nil {
+		err = userDbConnector.First(&user, stored.UserID).Error
+	}
+	if err == nil {
+		// the link stays usable until a password satisfies the policy
+		var violations []*userpb.PasswordViolation
+		violations, err = userServiceManager.validateNewPassword(request.NewPassword, &user)
+		if err == nil && len(violations) > 0 {
+			logger.Warn("New password violates the password policy", zap.String("userEmail", user.Email))
+			return &userpb.ResetPasswordResponse{
+				Message:    weakPasswordMessage,
+				Error:      "Weak Password",
+				StatusCode: StatusBadRequest,
+				Violations: violations,
+			}, nil
+		}
+	}
+	var hashedPassword string
+	if err == nil {
+		hashedPassword, err = userServiceManager.passwordHashers.Hash(request.NewPassword)
+	}
+	if err == nil {
+		err = resetPassword(stored, hashedPassword)
+	}
+	if err == errPasswordResetTokenInvalid {
+		logger.Warn("Invalid password reset token")
+		return &userpb.ResetPasswordResponse{
+			Message:    "The reset link is invalid, expired or was already used.",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	if err != nil {
+		logger.Error("Failed to reset password", zap.Error(err))
+		return &userpb.ResetPasswordResponse{
+			Message:    "Failed to reset password, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Password reset successfully", zap.String("userEmail", user.Email))
+	return &userpb.ResetPasswordResponse{
+		Message:    "Password reset successfully. Please login with your new password.",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"auth-microservice/mailer"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var resetLinkPattern = regexp.MustCompile(`\?token=(\S+)`)
+
+func lastResetToken(t *testing.T, service *UserService, email string) string {
+	service.deliveries.Wait()
+	messages := service.mailer.(*mailer.FakeMailer).Messages(email)
+	require.NotEmpty(t, messages)
+	match := resetLinkPattern.FindStringSubmatch(messages[len(messages)-1].Body)
+	require.Len(t, match, 2)
+	token, err := url.QueryUnescape(match[1])
+	require.Nil(t, err)
+	return token
+}
+
+func TestResetPassword(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210",
+		Password: hashPassword(t, "old-password"), EmailVerificationPending: true}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	session, err := service.issueTokens(context.Background(), &user, "")
+	require.Nil(t, err)
+	ctx := context.Background()
+
+	response, err := service.RequestPasswordReset(ctx, &userpb.RequestPasswordResetRequest{UserEmail: user.Email})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), response.StatusCode)
+	token := lastResetToken(t, service, user.Email)
+
+	reset, err := service.ResetPassword(ctx, &userpb.ResetPasswordRequest{Token: token, NewPassword: "new-password"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), reset.StatusCode)
+
+	var stored model.User
+	require.Nil(t, userDbConnector.First(&stored, user.ID).Error)
+	assert.Nil(t, service.passwordHashers.Compare(stored.Password, "new-password"))
+	assert.NotNil(t, service.passwordHashers.Compare(stored.Password, "old-password"))
+	assert.False(t, stored.EmailVerificationPending)
+	assert.Equal(t, user.TokenGeneration+1, stored.TokenGeneration)
+
+	refreshed, err := service.RefreshToken(ctx, &userpb.RefreshTokenRequest{RefreshToken: session.RefreshToken})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), refreshed.StatusCode)
+
+	// the link can only be used once
+	reset, err = service.ResetPassword(ctx, &userpb.ResetPasswordRequest{Token: token, NewPassword: "other-password"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), reset.StatusCode)
+}
+
+func TestRequestPasswordResetVoidsEarlierLinks(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210",
+		Password: hashPassword(t, "old-password")}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	ctx := context.Background()
+
+	_, err := service.RequestPasswordReset(ctx, &userpb.RequestPasswordResetRequest{UserEmail: user.Email})
+	require.Nil(t, err)
+	first := lastResetToken(t, service, user.Email)
+	_, err = service.RequestPasswordReset(ctx, &userpb.RequestPasswordResetRequest{UserEmail: user.Email})
+	require.Nil(t, err)
+	second := lastResetToken(t, service, user.Email)
+
+	reset, err := service.ResetPassword(ctx, &userpb.ResetPasswordRequest{Token: first, NewPassword: "new-password"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), reset.StatusCode)
+	reset, err = service.ResetPassword(ctx, &userpb.ResetPasswordRequest{Token: second, NewPassword: "new-password"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), reset.StatusCode)
+}
+
+func TestRequestPasswordResetUnknownEmail(t *testing.T) {
+	service, _ := newTestUserService(t)
+
+	response, err := service.RequestPasswordReset(context.Background(), &userpb.RequestPasswordResetRequest{UserEmail: "nobody@example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), response.StatusCode)
+	service.deliveries.Wait()
+	assert.Empty(t, service.mailer.(*mailer.FakeMailer).Messages("nobody@example.com"))
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, message mailer.Message) error {
+	return errors.New("mail server unavailable")
+}
+
+func TestRequestPasswordResetMailFailureLooksAlike(t *testing.T) {
+	service, _ := newTestUserService(t)
+	service.mailer = failingMailer{}
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210"}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+
+	registered, err := service.RequestPasswordReset(context.Background(), &userpb.RequestPasswordResetRequest{UserEmail: user.Email})
+	assert.Nil(t, err)
+	unknown, err := service.RequestPasswordReset(context.Background(), &userpb.RequestPasswordResetRequest{UserEmail: "nobody@example.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, unknown, registered)
+	assert.Equal(t, int64(StatusOK), registered.StatusCode)
+	service.deliveries.Wait()
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	"auth-microservice/otp"
+	userpb "auth-microservice/proto/user"
+	"auth-microservice/ratelimit"
+	"auth-microservice/sms"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"gorm.io/gorm"
+)
+
+const (
+	otpLength      = 6
+	otpDuration    = 5 * time.Minute
+	maxOtpAttempts = 5
+	// a new verification code can be requested otpResendCooldown after the last one
+	otpResendCooldown = time.Minute
+
+	otpPurposePhoneVerification = "phone_verification"
+)
+
+var (
+	errOtpInvalid          = errors.New("invalid code")
+	errOtpTooManyAttempts  = errors.New("too many attempts")
+	errOtpNotFoundOrExpiry = errors.New("no valid code, request a new one")
+)
+
+// newSmsSender picks the sender configured through the SMS_SENDER environment
+// variable. The messages carry login codes, so the log sender is only allowed
+// in development.
+func newSmsSender() (sms.SmsSender, error) {
+	name := getEnv("SMS_SENDER", "log")
+	switch name {
+	case "twilio":
+		return sms.NewTwilioSender(os.Getenv("TWILIO_ACCOUNT_SID"), os.Getenv("TWILIO_AUTH_TOKEN"),
+			os.Getenv("TWILIO_FROM"), getEnv("SMS_COUNTRY_CODE", "+91")), nil
+	case "log":
+		if !developmentMode() {
+			return nil, fmt.Errorf("SMS_SENDER %q is only allowed with APP_ENV=development, set SMS_SENDER=twilio", name)
+		}
+		return sms.NewLogSender(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_SENDER %q", name)
+	}
+}
+
+// otpResendWait returns how long phone has to wait before another code of
+// purpose is sent to it.
+func otpResendWait(phone string, purpose string) (time.Duration, error) {
+	var last model.PhoneOtp
+	err := userDbConnector.Where("phone = ? AND purpose = ?", phone, purpose).Order("id desc").First(&last).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Until(last.CreatedAt.Add(otpResendCooldown)), nil
+}
+
+// issuePhoneOtp sends a new code to phone and voids the codes sent before for
+// the same purpose.
+func (userServiceManager *UserService) issuePhoneOtp(ctx context.Context, phone string, purpose string, requestIP string) error {
+	code, err := otp.GenerateCode(otpLength)
+	if err != nil {
+		return err
+	}
+	err = userDbConnector.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&model.PhoneOtp{}).
+			Where("phone = ? AND purpose = ? AND consumed_at IS NULL", phone, purpose).
+			Update("consumed_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&model.PhoneOtp{
+			Phone:     phone,
+			Purpose:   purpose,
+			CodeHash:  otp.HashCode(userServiceManager.otpSecret, phone, purpose, code),
+			ExpiresAt: now.Add(otpDuration),
+			RequestIP: requestIP,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("%s is your Meal Mingle verification code. It expires in %d minutes. Do not share it with anyone.",
+		code, int(otpDuration.Minutes()))
+	return userServiceManager.smsSender.Send(ctx, phone, message)
+}
+
+// checkPhoneOtp validates code against the latest code sent to phone and
+// consumes it on success. Every guess counts as an attempt.
+func (userServiceManager *UserService) checkPhoneOtp(phone string, purpose string, code string) error {
+	var stored model.PhoneOtp
+	err := userDbConnector.
+		Where("phone = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > ?", phone, purpose, time.Now()).
+		Order("id desc").First(&stored).Error
+	if err == gorm.ErrRecordNotFound {
+		return errOtpNotFoundOrExpiry
+	}
+	if err != nil {
+		return err
+	}
+	result := userDbConnector.Model(&model.PhoneOtp{}).
+		Where("id = ? AND attempts < ?", stored.ID, maxOtpAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errOtpTooManyAttempts
+	}
+	if !otp.CheckCode(userServiceManager.otpSecret, phone, purpose, code, stored.CodeHash) {
+		return errOtpInvalid
+	}
+	result = userDbConnector.Model(&model.PhoneOtp{}).
+		Where("id = ? AND consumed_at IS NULL", stored.ID).
+		Update("consumed_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errOtpNotFoundOrExpiry
+	}
+	return nil
+}
+
+// otpErrorMessage turns a checkPhoneOtp error into a message for the client.
+func otpErrorMessage(err error) string {
+	switch err {
+	case errOtpInvalid:
+		return "The code is incorrect."
+	case errOtpTooManyAttempts:
+		return "Too many incorrect attempts. Please request a new code."
+	default:
+		return "The code has expired or was already used. Please request a new code."
+	}
+}
+
+func (userServiceManager *UserService) SendPhoneOtp(ctx context.Context, request *userpb.SendPhoneOtpRequest) (*userpb.SendPhoneOtpResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.SendPhoneOtpResponse{
+			Data:       nil,
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Received SendPhoneOtp request", zap.String("userEmail", principal.Email))
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", principal.Email), zap.Error(err))
+		return &userpb.SendPhoneOtpResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if user.PhoneVerified {
+		return &userpb.SendPhoneOtpResponse{
+			Data:       nil,
+			Message:    "Phone number is already verified",
+			Error:      "Conflict",
+			StatusCode: StatusConflict,
+		}, nil
+	}
+	wait, err := otpResendWait(user.Phone, otpPurposePhoneVerification)
+	if err == nil && wait > 0 {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		grpc.SetHeader(ctx, metadata.Pairs(ratelimit.RetryAfterHeader, strconv.Itoa(retryAfter)))
+		logger.Warn("Phone otp requested again too soon", zap.String("phone", user.Phone))
+		return &userpb.SendPhoneOtpResponse{
+			Data:       nil,
+			Message:    fmt.Sprintf("A code was sent recently, Please wait %d seconds before requesting a new one.", retryAfter),
+			Error:      "Too Many Requests",
+			StatusCode: StatusTooManyRequests,
+		}, nil
+	}
+	if err == nil {
+		err = userServiceManager.issuePhoneOtp(ctx, user.Phone, otpPurposePhoneVerification, clientIP(ctx))
+	}
+	if err != nil {
+		logger.Error("Failed to send phone otp", zap.String("phone", user.Phone), zap.Error(err))
+		return &userpb.SendPhoneOtpResponse{
+			Data:       nil,
+			Message:    "Failed to send the code, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Phone otp sent", zap.String("phone", user.Phone))
+	return &userpb.SendPhoneOtpResponse{
+		Data: &userpb.SendPhoneOtpResponseData{
+			Phone:            user.Phone,
+			ExpiresInSeconds: int64(otpDuration.Seconds()),
+		},
+		Message:    "Verification code sent",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+func (userServiceManager *UserService) VerifyPhoneOtp(ctx context.Context, request *userpb.VerifyPhoneOtpRequest) (*userpb.VerifyPhoneOtpResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.VerifyPhoneOtpResponse{
+			Data:       nil,
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Received VerifyPhoneOtp request", zap.String("userEmail", principal.Email))
+	if len(request.Code) != otpLength {
+		return &userpb.VerifyPhoneOtpResponse{
+			Data:       nil,
+			Message:    fmt.Sprintf("The code must be %d digits long.", otpLength),
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", principal.Email), zap.Error(err))
+		return &userpb.VerifyPhoneOtpResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	err := userServiceManager.checkPhoneOtp(user.Phone, otpPurposePhoneVerification, request.Code)
+	if err == errOtpInvalid || err == errOtpTooManyAttempts || err == errOtpNotFoundOrExpiry {
+		logger.Warn("Phone otp verification failed", zap.String("phone", user.Phone), zap.Error(err))
+		return &userpb.VerifyPhoneOtpResponse{
+			Data:       nil,
+			Message:    otpErrorMessage(err),
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	if err == nil {
+		err = userDbConnector.Model(&user).Update("phone_verified", true).Error
+	}
+	if err != nil {
+		logger.Error("Failed to verify phone", zap.String("phone", user.Phone), zap.Error(err))
+		return &userpb.VerifyPhoneOtpResponse{
+			Data:       nil,
+			Message:    "Failed to verify phone number, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Phone verified successfully", zap.String("phone", user.Phone))
+	return &userpb.VerifyPhoneOtpResponse{
+		Data: &userpb.PhoneVerificationResponseData{
+			Phone: user.Phone,
+		},
+		Message:    "Phone number verified successfully",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
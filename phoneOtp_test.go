@@ -0,0 +1,84 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"auth-microservice/sms"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyPhoneOtp(t *testing.T) {
+	service, sender := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	ctx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: user.ID, Email: user.Email})
+
+	response, err := service.SendPhoneOtp(ctx, &userpb.SendPhoneOtpRequest{})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), response.StatusCode)
+	code := lastOtpCode(t, sender, user.Phone)
+
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+	verified, err := service.VerifyPhoneOtp(ctx, &userpb.VerifyPhoneOtpRequest{Code: wrongCode})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), verified.StatusCode)
+
+	verified, err = service.VerifyPhoneOtp(ctx, &userpb.VerifyPhoneOtpRequest{Code: code})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), verified.StatusCode)
+	var stored model.User
+	require.Nil(t, userDbConnector.First(&stored, user.ID).Error)
+	assert.True(t, stored.PhoneVerified)
+}
+
+func TestSendPhoneOtpCooldown(t *testing.T) {
+	service, sender := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	ctx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: user.ID, Email: user.Email})
+
+	response, err := service.SendPhoneOtp(ctx, &userpb.SendPhoneOtpRequest{})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), response.StatusCode)
+	response, err = service.SendPhoneOtp(ctx, &userpb.SendPhoneOtpRequest{})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusTooManyRequests), response.StatusCode)
+	assert.Len(t, sender.Messages(user.Phone), 1)
+
+	// once the cooldown passed a new code is sent
+	require.Nil(t, userDbConnector.Model(&model.PhoneOtp{}).Where("phone = ?", user.Phone).
+		Update("created_at", time.Now().Add(-otpResendCooldown)).Error)
+	response, err = service.SendPhoneOtp(ctx, &userpb.SendPhoneOtpRequest{})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), response.StatusCode)
+
+	verified, err := service.VerifyPhoneOtp(ctx, &userpb.VerifyPhoneOtpRequest{Code: lastOtpCode(t, sender, user.Phone)})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), verified.StatusCode)
+}
+
+func TestNewSmsSenderNeedsRealSenderOutsideDevelopment(t *testing.T) {
+	t.Setenv("APP_ENV", "")
+	t.Setenv("SMS_SENDER", "")
+	_, err := newSmsSender()
+	assert.NotNil(t, err)
+	t.Setenv("SMS_SENDER", "twilio")
+	smsSender, err := newSmsSender()
+	assert.Nil(t, err)
+	assert.IsType(t, &sms.TwilioSender{}, smsSender)
+
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("SMS_SENDER", "")
+	smsSender, err = newSmsSender()
+	assert.Nil(t, err)
+	assert.IsType(t, &sms.LogSender{}, smsSender)
+}
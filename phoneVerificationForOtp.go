@@ -9,7 +9,11 @@ import (
 	"go.uber.org/zap"
 )
 
-func (*UserService) PhoneVerification(ctx context.Context, request *userpb.PhoneVerificationRequest) (*userpb.PhoneVerificationResponse, error) {
+// otpPurposePhoneCheck codes prove the caller owns a phone before hardened
+// auth tells whether the phone is registered
+const otpPurposePhoneCheck = "phone_check"
+
+func (userServiceManager *UserService) PhoneVerification(ctx context.Context, request *userpb.PhoneVerificationRequest) (*userpb.PhoneVerificationResponse, error) {
 	logger.Info("Received PhoneVerification request", zap.String("phone", request.Phone))
 	phone := request.Phone
 	if !config.ValidatePhone(phone) {
@@ -21,6 +25,30 @@ func (*UserService) PhoneVerification(ctx context.Context, request *userpb.Phone
 			StatusCode: StatusBadRequest,
 		}, nil
 	}
+	if userServiceManager.hardenedAuth {
+		if request.Code == "" {
+			return userServiceManager.sendPhoneCheckOtp(ctx, phone), nil
+		}
+		err := userServiceManager.checkPhoneOtp(phone, otpPurposePhoneCheck, request.Code)
+		if err == errOtpInvalid || err == errOtpTooManyAttempts || err == errOtpNotFoundOrExpiry {
+			logger.Warn("Phone check otp verification failed", zap.String("phone", phone), zap.Error(err))
+			return &userpb.PhoneVerificationResponse{
+				Data:       nil,
+				Message:    otpErrorMessage(err),
+				Error:      "Unauthorized",
+				StatusCode: StatusUnauthorized,
+			}, nil
+		}
+		if err != nil {
+			logger.Error("Failed to verify phone check otp", zap.String("phone", phone), zap.Error(err))
+			return &userpb.PhoneVerificationResponse{
+				Data:       nil,
+				Message:    "Failed to verify the code, Please try again later.",
+				Error:      "Internal Server Error",
+				StatusCode: StatusInternalServerError,
+			}, nil
+		}
+	}
 	// check if the phone number is already registered
 	var existingUser model.User
 	userNotFoundError := userDbConnector.Where("phone = ?", phone).First(&existingUser).Error
@@ -42,3 +70,40 @@ func (*UserService) PhoneVerification(ctx context.Context, request *userpb.Phone
 		StatusCode: StatusNotFound,
 	}, nil
 }
+
+// sendPhoneCheckOtp sends a code to phone whether it is registered or not, and
+// answers the same either way.
+func (userServiceManager *UserService) sendPhoneCheckOtp(ctx context.Context, phone string) *userpb.PhoneVerificationResponse {
+	requestIP := clientIP(ctx)
+	limited, err := otpRateLimited(phone, otpPurposePhoneCheck, requestIP)
+	if err == nil && !limited {
+		err = userServiceManager.issuePhoneOtp(ctx, phone, otpPurposePhoneCheck, requestIP)
+	}
+	if err != nil {
+		logger.Error("Failed to send phone check otp", zap.String("phone", phone), zap.Error(err))
+		return &userpb.PhoneVerificationResponse{
+			Data:       nil,
+			Message:    "Failed to send the code, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}
+	}
+	if limited {
+		logger.Warn("Too many phone check otp requests", zap.String("phone", phone), zap.String("ip", requestIP))
+		return &userpb.PhoneVerificationResponse{
+			Data:       nil,
+			Message:    "Too many codes requested, Please try again later.",
+			Error:      "Too Many Requests",
+			StatusCode: StatusTooManyRequests,
+		}
+	}
+	logger.Info("Phone check otp sent", zap.String("phone", phone))
+	return &userpb.PhoneVerificationResponse{
+		Data: &userpb.PhoneVerificationResponseData{
+			Phone: phone,
+		},
+		Message:    "A verification code was sent to the phone, send it back to check the phone number.",
+		Error:      "",
+		StatusCode: StatusOK,
+	}
+}
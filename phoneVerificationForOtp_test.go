@@ -0,0 +1,78 @@
+package main
+
+import (
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPhoneVerificationHardened(t *testing.T) {
+	service, sender := newTestUserService(t)
+	service.hardenedAuth = true
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	ctx := context.Background()
+
+	// registered and unknown phones get the same answer until the code comes back
+	registered, err := service.PhoneVerification(ctx, &userpb.PhoneVerificationRequest{Phone: user.Phone})
+	assert.Nil(t, err)
+	unknown, err := service.PhoneVerification(ctx, &userpb.PhoneVerificationRequest{Phone: "9123456789"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), registered.StatusCode)
+	assert.Equal(t, registered.Message, unknown.Message)
+
+	response, err := service.PhoneVerification(ctx, &userpb.PhoneVerificationRequest{Phone: user.Phone, Code: "000000"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), response.StatusCode)
+
+	response, err = service.PhoneVerification(ctx, &userpb.PhoneVerificationRequest{
+		Phone: user.Phone, Code: lastOtpCode(t, sender, user.Phone)})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), response.StatusCode)
+	assert.Equal(t, "The phone number is already registered.", response.Message)
+
+	response, err = service.PhoneVerification(ctx, &userpb.PhoneVerificationRequest{
+		Phone: "9123456789", Code: lastOtpCode(t, sender, "9123456789")})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), response.StatusCode)
+}
+
+func TestRequestLoginOtpHardenedUnknownPhone(t *testing.T) {
+	service, sender := newTestUserService(t)
+	service.hardenedAuth = true
+
+	response, err := service.RequestLoginOtp(context.Background(), &userpb.RequestLoginOtpRequest{Phone: "9123456789"})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), response.StatusCode)
+	service.deliveries.Wait()
+	assert.Empty(t, sender.Messages("9123456789"))
+}
+
+func TestRequestLoginOtpHardenedRateLimitLooksAlike(t *testing.T) {
+	service, sender := newTestUserService(t)
+	service.hardenedAuth = true
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+
+	for _, phone := range []string{user.Phone, "9123456789"} {
+		var responses []*userpb.RequestLoginOtpResponse
+		for i := 0; i <= otpPhoneLimit; i++ {
+			ctx := contextFromIP(fmt.Sprintf("203.0.113.%d", i+1))
+			response, err := service.RequestLoginOtp(ctx, &userpb.RequestLoginOtpRequest{Phone: phone})
+			assert.Nil(t, err)
+			responses = append(responses, response)
+		}
+		for _, response := range responses[:otpPhoneLimit] {
+			assert.Equal(t, int64(StatusOK), response.StatusCode)
+		}
+		assert.Equal(t, int64(StatusTooManyRequests), responses[otpPhoneLimit].StatusCode)
+	}
+	service.deliveries.Wait()
+	assert.Len(t, sender.Messages(user.Phone), otpPhoneLimit)
+	assert.Empty(t, sender.Messages("9123456789"))
+}
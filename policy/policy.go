@@ -0,0 +1,113 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+
+	"auth-microservice/jwt"
+	authpb "auth-microservice/proto/auth"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Rule is the access rule declared for a single RPC in the proto file.
+type Rule struct {
+	Public             bool
+	RequiredRole       string
+	RequiredPermission string
+	AllowUnverified    bool
+	AllowService       bool
+	AllowApiKey        bool
+}
+
+// Policy holds the access rules of every RPC of a service, keyed by the full
+// gRPC method name.
+type Policy struct {
+	rules                map[string]Rule
+	requireVerifiedEmail bool
+}
+
+// FromService reads the (auth.*) method options of every RPC of service.
+func FromService(service protoreflect.ServiceDescriptor) (*Policy, error) {
+	policy := &Policy{rules: map[string]Rule{}, requireVerifiedEmail: true}
+	methods := service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		fullMethod := fmt.Sprintf("/%s/%s", service.FullName(), method.Name())
+		rule := Rule{}
+		if options, ok := method.Options().(*descriptorpb.MethodOptions); ok && options != nil {
+			rule.Public = proto.GetExtension(options, authpb.E_Public).(bool)
+			rule.RequiredRole = proto.GetExtension(options, authpb.E_RequiredRole).(string)
+			rule.RequiredPermission = proto.GetExtension(options, authpb.E_RequiredPermission).(string)
+			rule.AllowUnverified = proto.GetExtension(options, authpb.E_AllowUnverified).(bool)
+			rule.AllowService = proto.GetExtension(options, authpb.E_AllowService).(bool)
+			rule.AllowApiKey = proto.GetExtension(options, authpb.E_AllowApiKey).(bool)
+		}
+		if rule.Public && (rule.RequiredRole != "" || rule.RequiredPermission != "") {
+			return nil, fmt.Errorf("%s cannot be public and require a role or permission", fullMethod)
+		}
+		policy.rules[fullMethod] = rule
+	}
+	return policy, nil
+}
+
+// Rule returns the rule of fullMethod and whether the method is known.
+func (policy *Policy) Rule(fullMethod string) (Rule, bool) {
+	rule, ok := policy.rules[fullMethod]
+	return rule, ok
+}
+
+// RequireVerifiedEmail sets whether users with an unverified email can only
+// call the RPCs declared with (auth.allow_unverified). It is on by default.
+func (policy *Policy) RequireVerifiedEmail(require bool) {
+	policy.requireVerifiedEmail = require
+}
+
+// PublicMethods lists the RPCs that can be called without an access token.
+func (policy *Policy) PublicMethods() []string {
+	var methods []string
+	for method, rule := range policy.rules {
+		if rule.Public {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// UnaryInterceptor enforces the rules on every call. It must run after the
+// jwt interceptor so the caller is available in the context.
+func (policy *Policy) UnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	rule, ok := policy.rules[info.FullMethod]
+	if !ok {
+		return nil, status.Errorf(403, "no access rule declared for %s", info.FullMethod)
+	}
+	if rule.Public {
+		return handler(ctx, req)
+	}
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(401, "authentication required")
+	}
+	if principal.IsService() {
+		// service clients have no account, so they can only call the RPCs
+		// declared for them and are limited by their granted scopes
+		if !rule.AllowService {
+			return nil, status.Errorf(403, "service clients cannot call %s", info.FullMethod)
+		}
+	} else if principal.IsApiKey() && !rule.AllowApiKey {
+		return nil, status.Errorf(403, "api keys cannot be used for %s", info.FullMethod)
+	} else if policy.requireVerifiedEmail && !principal.EmailVerified && !rule.AllowUnverified {
+		return nil, status.Errorf(403, "please verify your email address first")
+	}
+	if rule.RequiredRole != "" && principal.Role != rule.RequiredRole {
+		return nil, status.Errorf(403, "you do not have permission to perform this action, %s role required", rule.RequiredRole)
+	}
+	if rule.RequiredPermission != "" && !principal.HasScope(rule.RequiredPermission) {
+		return nil, status.Errorf(403, "you do not have permission to perform this action, %s permission required", rule.RequiredPermission)
+	}
+	return handler(ctx, req)
+}
@@ -0,0 +1,115 @@
+package policy
+
+import (
+	"context"
+	"testing"
+
+	"auth-microservice/jwt"
+	userpb "auth-microservice/proto/user"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestFromServiceReadsProtoOptions(t *testing.T) {
+	policy, err := FromService(userpb.File_user_user_proto.Services().ByName("UserService"))
+	assert.Nil(t, err)
+
+	rule, ok := policy.Rule("/userpb.UserService/AuthenticateUser")
+	assert.True(t, ok)
+	assert.True(t, rule.Public)
+
+	rule, ok = policy.Rule("/userpb.UserService/GetUserDetails")
+	assert.True(t, ok)
+	assert.Equal(t, "admin", rule.RequiredRole)
+
+	assert.Contains(t, policy.PublicMethods(), "/userpb.UserService/AddUser")
+	assert.NotContains(t, policy.PublicMethods(), "/userpb.UserService/Logout")
+}
+
+func TestUnaryInterceptorEnforcesRole(t *testing.T) {
+	policy, err := FromService(userpb.File_user_user_proto.Services().ByName("UserService"))
+	assert.Nil(t, err)
+	info := &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/GetUserDetails"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	_, err = policy.UnaryInterceptor(context.Background(), nil, info, handler)
+	assert.NotNil(t, err)
+
+	userCtx := jwt.NewContext(context.Background(), &jwt.Principal{Role: "user", EmailVerified: true})
+	_, err = policy.UnaryInterceptor(userCtx, nil, info, handler)
+	assert.NotNil(t, err)
+
+	adminCtx := jwt.NewContext(context.Background(), &jwt.Principal{Role: "admin", EmailVerified: true})
+	resp, err := policy.UnaryInterceptor(adminCtx, nil, info, handler)
+	assert.Nil(t, err)
+	assert.Equal(t, "ok", resp)
+
+	_, err = policy.UnaryInterceptor(adminCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/Unknown"}, handler)
+	assert.NotNil(t, err)
+}
+
+func TestUnaryInterceptorEnforcesPermission(t *testing.T) {
+	policy, err := FromService(userpb.File_user_user_proto.Services().ByName("UserService"))
+	assert.Nil(t, err)
+	info := &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/CreateRole"}
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	adminCtx := jwt.NewContext(context.Background(), &jwt.Principal{Role: "admin", EmailVerified: true})
+	_, err = policy.UnaryInterceptor(adminCtx, nil, info, handler)
+	assert.NotNil(t, err)
+
+	roleAdminCtx := jwt.NewContext(context.Background(), &jwt.Principal{Role: "user", Scopes: []string{"roles:manage"}, EmailVerified: true})
+	_, err = policy.UnaryInterceptor(roleAdminCtx, nil, info, handler)
+	assert.Nil(t, err)
+}
+
+func TestUnaryInterceptorRestrictsUnverifiedUsers(t *testing.T) {
+	policy, err := FromService(userpb.File_user_user_proto.Services().ByName("UserService"))
+	assert.Nil(t, err)
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	unverifiedCtx := jwt.NewContext(context.Background(), &jwt.Principal{Role: "admin"})
+
+	_, err = policy.UnaryInterceptor(unverifiedCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/GetUserDetails"}, handler)
+	assert.NotNil(t, err)
+	_, err = policy.UnaryInterceptor(unverifiedCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/ResendVerificationEmail"}, handler)
+	assert.Nil(t, err)
+
+	policy.RequireVerifiedEmail(false)
+	_, err = policy.UnaryInterceptor(unverifiedCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/GetUserDetails"}, handler)
+	assert.Nil(t, err)
+}
+
+func TestUnaryInterceptorRestrictsServiceClients(t *testing.T) {
+	policy, err := FromService(userpb.File_user_user_proto.Services().ByName("UserService"))
+	assert.Nil(t, err)
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	serviceCtx := jwt.NewContext(context.Background(), &jwt.Principal{ClientID: "orders", Scopes: []string{"users:read"}})
+
+	_, err = policy.UnaryInterceptor(serviceCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/GetUserProfile"}, handler)
+	assert.Nil(t, err)
+	_, err = policy.UnaryInterceptor(serviceCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/Logout"}, handler)
+	assert.NotNil(t, err)
+
+	otherCtx := jwt.NewContext(context.Background(), &jwt.Principal{ClientID: "payments"})
+	_, err = policy.UnaryInterceptor(otherCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/GetUserProfile"}, handler)
+	assert.NotNil(t, err)
+}
+
+func TestUnaryInterceptorRestrictsApiKeys(t *testing.T) {
+	policy, err := FromService(userpb.File_user_user_proto.Services().ByName("UserService"))
+	assert.Nil(t, err)
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+	apiKeyCtx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: 1, Role: "admin", EmailVerified: true, ApiKeyID: 1})
+
+	_, err = policy.UnaryInterceptor(apiKeyCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/GetUserProfile"}, handler)
+	assert.NotNil(t, err)
+	scopedCtx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: 1, Role: "admin", EmailVerified: true, ApiKeyID: 1,
+		Scopes: []string{"users:read"}})
+	_, err = policy.UnaryInterceptor(scopedCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/GetUserProfile"}, handler)
+	assert.Nil(t, err)
+	_, err = policy.UnaryInterceptor(scopedCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/GetUserDetails"}, handler)
+	assert.NotNil(t, err)
+	_, err = policy.UnaryInterceptor(apiKeyCtx, nil, &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/ChangePassword"}, handler)
+	assert.NotNil(t, err)
+}
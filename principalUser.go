@@ -0,0 +1,28 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	"errors"
+)
+
+var errServicePrincipal = errors.New("the caller is a service client, not a user")
+
+// loadPrincipalUser resolves the caller by primary key. Tokens issued before
+// the sub claim was added only carry the email, those are still resolved by
+// email until they expire.
+func loadPrincipalUser(principal *jwt.Principal, user *model.User) error {
+	if principal.IsService() {
+		return errServicePrincipal
+	}
+	if principal.UserID != 0 {
+		return userDbConnector.First(user, principal.UserID).Error
+	}
+	return userDbConnector.Where("email = ?", principal.Email).First(user).Error
+}
+
+// loadUserRoles loads the user's roles with their permissions, which end up
+// as scopes in the access token.
+func loadUserRoles(user *model.User) error {
+	return userDbConnector.Model(user).Preload("Permissions").Association("Roles").Find(&user.Roles)
+}
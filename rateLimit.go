@@ -0,0 +1,59 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/ratelimit"
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+// defaultRateLimits keep the anonymous RPCs from being hammered, for example
+// to enumerate registered emails and phone numbers. RATE_LIMITS overrides
+// single entries in the same format.
+const defaultRateLimits = "default=20/s:40," +
+	"AddUser=10/h:5,AuthenticateUser=30/m:10,PhoneVerification=20/m:10," +
+	"RequestLoginOtp=10/m:5,LoginWithOtp=30/m:10,RequestPasswordReset=10/m:5," +
+	"ResetPassword=30/m:10,VerifyEmail=30/m:10,RefreshToken=60/m:20"
+
+func newRateLimiter() (*ratelimit.Limiter, error) {
+	limits, err := ratelimit.ParseLimits(defaultRateLimits)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := ratelimit.ParseLimits(os.Getenv("RATE_LIMITS"))
+	if err != nil {
+		return nil, err
+	}
+	for name, limit := range overrides {
+		limits[name] = limit
+	}
+	return ratelimit.New(limits), nil
+}
+
+// rateLimitKey counts authenticated requests against the user and anonymous
+// requests against the client address. The gRPC server limits requests before
+// the token is verified, so there every request counts against its address.
+func rateLimitKey(ctx context.Context) string {
+	if principal, ok := jwt.FromContext(ctx); ok {
+		if principal.IsService() {
+			return "service:" + principal.ClientID
+		}
+		if principal.UserID != 0 {
+			return "user:" + strconv.FormatUint(uint64(principal.UserID), 10)
+		}
+		return "user:" + principal.Email
+	}
+	return "ip:" + clientIP(ctx)
+}
+
+// gatewayOutgoingHeader forwards the retry-after header of rate limited
+// requests as Retry-After, other headers keep the gateway's default prefix.
+func gatewayOutgoingHeader(key string) (string, bool) {
+	if key == ratelimit.RetryAfterHeader {
+		return "Retry-After", true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
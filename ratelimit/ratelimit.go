@@ -0,0 +1,167 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultLimit is the name under which the limit of every method without its
+// own limit is configured.
+const DefaultLimit = "default"
+
+// RetryAfterHeader is the response header telling the client how many
+// seconds to wait, the gateway forwards it as Retry-After.
+const RetryAfterHeader = "retry-after"
+
+// Limit is a token bucket that refills Rate tokens per second up to Burst.
+// A Rate of zero disables the limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// KeyFunc returns who a request is counted against, like the client address
+// or the authenticated user.
+type KeyFunc func(ctx context.Context) string
+
+// Limiter keeps a token bucket per method limit and key. Buckets live in
+// memory, so every replica enforces the limits on its own.
+type Limiter struct {
+	mu        sync.Mutex
+	limits    map[string]Limit
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// New returns a limiter for limits, keyed by method name or DefaultLimit.
+func New(limits map[string]Limit) *Limiter {
+	return &Limiter{limits: limits, buckets: map[string]*bucket{}, now: time.Now}
+}
+
+// limitFor returns the limit of a full gRPC method name and the name it is
+// configured under.
+func (limiter *Limiter) limitFor(fullMethod string) (string, Limit) {
+	method := fullMethod[strings.LastIndex(fullMethod, "/")+1:]
+	if limit, ok := limiter.limits[method]; ok {
+		return method, limit
+	}
+	return DefaultLimit, limiter.limits[DefaultLimit]
+}
+
+// Allow takes a token from the bucket of key for fullMethod. If the bucket is
+// empty it returns false and how long until the next token.
+func (limiter *Limiter) Allow(fullMethod string, key string) (bool, time.Duration) {
+	name, limit := limiter.limitFor(fullMethod)
+	if limit.Rate <= 0 {
+		return true, 0
+	}
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	now := limiter.now()
+	limiter.sweep(now)
+
+	bucketKey := name + "|" + key
+	b, ok := limiter.buckets[bucketKey]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		limiter.buckets[bucketKey] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops the buckets that refilled completely, they behave like new ones.
+func (limiter *Limiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < time.Minute {
+		return
+	}
+	limiter.lastSweep = now
+	for bucketKey, b := range limiter.buckets {
+		limit := limiter.limits[bucketKey[:strings.Index(bucketKey, "|")]]
+		if limit.Rate <= 0 || b.tokens+now.Sub(b.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(limiter.buckets, bucketKey)
+		}
+	}
+}
+
+// UnaryInterceptor rejects requests over the limit with RESOURCE_EXHAUSTED and
+// a retry-after header.
+func (limiter *Limiter) UnaryInterceptor(keyFunc KeyFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		allowed, wait := limiter.Allow(info.FullMethod, keyFunc(ctx))
+		if !allowed {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			grpc.SetHeader(ctx, metadata.Pairs(RetryAfterHeader, strconv.Itoa(retryAfter)))
+			return nil, status.Errorf(codes.ResourceExhausted, "too many requests, retry after %d seconds", retryAfter)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// ParseLimits reads limits written as "name=count/unit:burst" separated by
+// commas, like "default=10/s:20,AuthenticateUser=5/m:5". The unit is s, m or h
+// and the burst defaults to the count.
+func ParseLimits(spec string) (map[string]Limit, error) {
+	limits := map[string]Limit{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, found := strings.Cut(entry, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid rate limit %q", entry)
+		}
+		rate, burst, hasBurst := strings.Cut(value, ":")
+		countText, unit, found := strings.Cut(rate, "/")
+		if !found {
+			return nil, fmt.Errorf("invalid rate limit %q", entry)
+		}
+		count, err := strconv.ParseFloat(countText, 64)
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("invalid rate limit %q", entry)
+		}
+		var per time.Duration
+		switch unit {
+		case "s":
+			per = time.Second
+		case "m":
+			per = time.Minute
+		case "h":
+			per = time.Hour
+		default:
+			return nil, fmt.Errorf("invalid rate limit unit in %q", entry)
+		}
+		limit := Limit{Rate: count / per.Seconds(), Burst: int(math.Ceil(count))}
+		if hasBurst {
+			limit.Burst, err = strconv.Atoi(burst)
+			if err != nil || limit.Burst < 1 {
+				return nil, fmt.Errorf("invalid rate limit burst in %q", entry)
+			}
+		}
+		limits[strings.TrimSpace(name)] = limit
+	}
+	return limits, nil
+}
@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestLimiter(limits map[string]Limit) (*Limiter, *time.Time) {
+	now := time.Unix(1700000000, 0)
+	limiter := New(limits)
+	limiter.now = func() time.Time { return now }
+	return limiter, &now
+}
+
+func TestAllow(t *testing.T) {
+	limiter, now := newTestLimiter(map[string]Limit{"AuthenticateUser": {Rate: 1, Burst: 2}})
+
+	allowed, _ := limiter.Allow("/userpb.UserService/AuthenticateUser", "ip:203.0.113.7")
+	assert.True(t, allowed)
+	allowed, _ = limiter.Allow("/userpb.UserService/AuthenticateUser", "ip:203.0.113.7")
+	assert.True(t, allowed)
+	allowed, wait := limiter.Allow("/userpb.UserService/AuthenticateUser", "ip:203.0.113.7")
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, wait)
+
+	// other keys have their own bucket
+	allowed, _ = limiter.Allow("/userpb.UserService/AuthenticateUser", "ip:198.51.100.1")
+	assert.True(t, allowed)
+
+	*now = now.Add(500 * time.Millisecond)
+	allowed, wait = limiter.Allow("/userpb.UserService/AuthenticateUser", "ip:203.0.113.7")
+	assert.False(t, allowed)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	*now = now.Add(500 * time.Millisecond)
+	allowed, _ = limiter.Allow("/userpb.UserService/AuthenticateUser", "ip:203.0.113.7")
+	assert.True(t, allowed)
+}
+
+func TestAllowDefaultLimit(t *testing.T) {
+	limiter, _ := newTestLimiter(map[string]Limit{DefaultLimit: {Rate: 1, Burst: 1}, "GetUserDetails": {}})
+
+	allowed, _ := limiter.Allow("/userpb.UserService/AddUser", "ip:203.0.113.7")
+	assert.True(t, allowed)
+	// methods without their own limit share the default bucket
+	allowed, _ = limiter.Allow("/userpb.UserService/PhoneVerification", "ip:203.0.113.7")
+	assert.False(t, allowed)
+
+	// a zero rate disables the limit
+	for i := 0; i < 10; i++ {
+		allowed, _ = limiter.Allow("/userpb.UserService/GetUserDetails", "ip:203.0.113.7")
+		assert.True(t, allowed)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	limiter, now := newTestLimiter(map[string]Limit{DefaultLimit: {Rate: 1, Burst: 5}})
+
+	limiter.Allow("/userpb.UserService/AddUser", "ip:203.0.113.7")
+	*now = now.Add(2 * time.Minute)
+	limiter.Allow("/userpb.UserService/AddUser", "ip:198.51.100.1")
+	assert.Len(t, limiter.buckets, 1)
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	limiter, _ := newTestLimiter(map[string]Limit{DefaultLimit: {Rate: 0.1, Burst: 1}})
+	interceptor := limiter.UnaryInterceptor(func(ctx context.Context) string { return "ip:203.0.113.7" })
+	info := &grpc.UnaryServerInfo{FullMethod: "/userpb.UserService/AddUser"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+
+	response, err := interceptor(context.Background(), nil, info, handler)
+	require.Nil(t, err)
+	assert.Equal(t, "ok", response)
+
+	_, err = interceptor(context.Background(), nil, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "retry after 10 seconds")
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("default=10/s:20, AuthenticateUser=30/m ,AddUser=10/h:5")
+	require.Nil(t, err)
+	assert.Equal(t, Limit{Rate: 10, Burst: 20}, limits[DefaultLimit])
+	assert.Equal(t, Limit{Rate: 0.5, Burst: 30}, limits["AuthenticateUser"])
+	assert.Equal(t, 5, limits["AddUser"].Burst)
+	assert.InDelta(t, 10.0/3600, limits["AddUser"].Rate, 1e-9)
+
+	limits, err = ParseLimits("")
+	require.Nil(t, err)
+	assert.Empty(t, limits)
+
+	for _, spec := range []string{"AddUser", "AddUser=10", "AddUser=10/d", "AddUser=x/s", "AddUser=10/s:0", "=10/s"} {
+		_, err := ParseLimits(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+	"gorm.io/gorm"
+)
+
+var errRefreshTokenReused = errors.New("refresh token was already used")
+
+func userpbUser(user *model.User) *userpb.User {
+	return &userpb.User{
+		UserId:    strconv.FormatUint(uint64(user.ID), 10),
+		UserName:  user.Name,
+		UserEmail: user.Email,
+		UserPhone: user.Phone,
+	}
+}
+
+// userAgent returns the user agent of the client, as forwarded by the gateway
+// or sent by gRPC clients.
+func userAgent(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("grpcgateway-user-agent")
+	if len(values) == 0 {
+		values = md.Get("user-agent")
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	if len(values[0]) > 255 {
+		return values[0][:255]
+	}
+	return values[0]
+}
+
+// issueTokens generates an access token and a refresh token for the user. An
+// empty familyID starts a new refresh token family, which is a new session on
+// the client of ctx.
+func (userServiceManager *UserService) issueTokens(ctx context.Context, user *model.User, familyID string) (*userpb.Responsedata, error) {
+	return userServiceManager.issueClientTokens(ctx, user, familyID, "")
+}
+
+// issueClientTokens is issueTokens for the oauth client clientID, which is
+// the only client that can redeem the refresh token.
+func (userServiceManager *UserService) issueClientTokens(ctx context.Context, user *model.User, familyID string, clientID string) (*userpb.Responsedata, error) {
+	if err := loadUserRoles(user); err != nil {
+		return nil, err
+	}
+	var err error
+	newSession := familyID == ""
+	if newSession {
+		familyID, err = jwt.GenerateID()
+		if err != nil {
+			return nil, err
+		}
+	}
+	token, err := userServiceManager.jwtManager.GenerateToken(user, familyID)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshTokenHash, err := userServiceManager.jwtManager.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	expiresAt := now.Add(userServiceManager.jwtManager.RefreshTokenDuration())
+	err = userDbConnector.Transaction(func(tx *gorm.DB) error {
+		if newSession {
+			err := tx.Create(&model.Session{
+				SessionID:  familyID,
+				UserID:     user.ID,
+				UserAgent:  userAgent(ctx),
+				IP:         clientIP(ctx),
+				LastSeenAt: now,
+				ExpiresAt:  expiresAt,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Create(&model.RefreshToken{
+			UserID:    user.ID,
+			TokenHash: refreshTokenHash,
+			FamilyID:  familyID,
+			ExpiresAt: expiresAt,
+			ClientID:  clientID,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &userpb.Responsedata{
+		User:         userpbUser(user),
+		Token:        token,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// rotateRefreshToken marks the stored token as used and issues its successor
+// in the same family. It fails with errRefreshTokenReused if another request
+// rotated the token first.
+func (userServiceManager *UserService) rotateRefreshToken(ctx context.Context, stored *model.RefreshToken, user *model.User) (*userpb.Responsedata, error) {
+	if err := loadUserRoles(user); err != nil {
+		return nil, err
+	}
+	token, err := userServiceManager.jwtManager.GenerateToken(user, stored.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, refreshTokenHash, err := userServiceManager.jwtManager.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	err = userDbConnector.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&model.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", stored.ID).
+			Updates(map[string]interface{}{"revoked_at": now, "replaced_by_hash": refreshTokenHash})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+		expiresAt := now.Add(userServiceManager.jwtManager.RefreshTokenDuration())
+		result = tx.Model(&model.Session{}).Where("session_id = ?", stored.FamilyID).
+			Updates(map[string]interface{}{"last_seen_at": now, "expires_at": expiresAt})
+		if result.Error != nil {
+			return result.Error
+		}
+		// families from before sessions were kept get their session now
+		if result.RowsAffected == 0 {
+			err := tx.Create(&model.Session{
+				SessionID:  stored.FamilyID,
+				UserID:     user.ID,
+				UserAgent:  userAgent(ctx),
+				IP:         clientIP(ctx),
+				LastSeenAt: now,
+				ExpiresAt:  expiresAt,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Create(&model.RefreshToken{
+			UserID:    user.ID,
+			TokenHash: refreshTokenHash,
+			FamilyID:  stored.FamilyID,
+			ExpiresAt: expiresAt,
+			ClientID:  stored.ClientID,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &userpb.Responsedata{
+		User:         userpbUser(user),
+		Token:        token,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// revokeRefreshTokenFamily revokes every token that was rotated from the same
+// login, used when an already rotated token is presented again. The session
+// of the family is revoked too, which rejects its access tokens.
+func revokeRefreshTokenFamily(familyID string) error {
+	return userDbConnector.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		err := tx.Model(&model.Session{}).
+			Where("session_id = ? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&model.RefreshToken{}).
+			Where("family_id = ? AND revoked_at IS NULL", familyID).
+			Update("revoked_at", now).Error
+	})
+}
+
+func (userServiceManager *UserService) RefreshToken(ctx context.Context, request *userpb.RefreshTokenRequest) (*userpb.RefreshTokenResponse, error) {
+	logger.Info("Received RefreshToken request")
+	return userServiceManager.redeemRefreshToken(ctx, request.RefreshToken, ""), nil
+}
+
+// redeemRefreshToken rotates refreshToken if it was issued to the oauth
+// client clientID, or through the login RPCs when clientID is empty.
+func (userServiceManager *UserService) redeemRefreshToken(ctx context.Context, refreshToken string, clientID string) *userpb.RefreshTokenResponse {
+	if refreshToken == "" {
+		logger.Warn("Refresh token is missing")
+		return &userpb.RefreshTokenResponse{
+			Data:       nil,
+			Message:    "The request contains missing or invalid fields.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}
+	}
+	var stored model.RefreshToken
+	if err := userDbConnector.Where("token_hash = ?", jwt.HashOpaqueToken(refreshToken)).First(&stored).Error; err != nil {
+		logger.Warn("Refresh token not found", zap.Error(err))
+		return &userpb.RefreshTokenResponse{
+			Data:       nil,
+			Message:    "Invalid refresh token",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}
+	}
+	if stored.ClientID != clientID {
+		logger.Warn("Refresh token was issued to another client",
+			zap.Uint("userId", stored.UserID), zap.String("clientId", clientID))
+		return &userpb.RefreshTokenResponse{
+			Data:       nil,
+			Message:    "Invalid refresh token",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}
+	}
+	if stored.RevokedAt != nil {
+		// A rotated token is being replayed, so the family may be compromised.
+		logger.Warn("Refresh token reuse detected, revoking token family",
+			zap.Uint("userId", stored.UserID), zap.String("familyId", stored.FamilyID))
+		if err := revokeRefreshTokenFamily(stored.FamilyID); err != nil {
+			logger.Error("Failed to revoke refresh token family", zap.String("familyId", stored.FamilyID), zap.Error(err))
+		}
+		return &userpb.RefreshTokenResponse{
+			Data:       nil,
+			Message:    "Invalid refresh token",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		logger.Warn("Refresh token expired", zap.Uint("userId", stored.UserID))
+		return &userpb.RefreshTokenResponse{
+			Data:       nil,
+			Message:    "Refresh token has expired, please login again",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}
+	}
+	var user model.User
+	if err := userDbConnector.First(&user, stored.UserID).Error; err != nil {
+		logger.Warn("User for refresh token not found", zap.Uint("userId", stored.UserID), zap.Error(err))
+		return &userpb.RefreshTokenResponse{
+			Data:       nil,
+			Message:    "Invalid refresh token",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}
+	}
+	data, err := userServiceManager.rotateRefreshToken(ctx, &stored, &user)
+	if errors.Is(err, errRefreshTokenReused) {
+		logger.Warn("Refresh token reuse detected, revoking token family",
+			zap.Uint("userId", stored.UserID), zap.String("familyId", stored.FamilyID))
+		if err := revokeRefreshTokenFamily(stored.FamilyID); err != nil {
+			logger.Error("Failed to revoke refresh token family", zap.String("familyId", stored.FamilyID), zap.Error(err))
+		}
+		return &userpb.RefreshTokenResponse{
+			Data:       nil,
+			Message:    "Invalid refresh token",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to rotate refresh token", zap.Uint("userId", stored.UserID), zap.Error(err))
+		return &userpb.RefreshTokenResponse{
+			Data:       nil,
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+			Message:    "Security Issues, Please try again later.",
+		}
+	}
+	logger.Info("Refresh token rotated successfully", zap.Uint("userId", user.ID))
+	return &userpb.RefreshTokenResponse{
+		Data:       data,
+		Message:    "Token refreshed successfully",
+		Error:      "",
+		StatusCode: StatusOK,
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestRefreshTokenRotates(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	tokens, _ := loginFrom(t, service, &user, "Laptop", "203.0.113.7")
+
+	refreshed, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: tokens.RefreshToken})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), refreshed.StatusCode)
+	assert.NotEmpty(t, refreshed.Data.Token)
+	assert.NotEqual(t, tokens.RefreshToken, refreshed.Data.RefreshToken)
+	assert.Equal(t, user.Email, refreshed.Data.User.UserEmail)
+	_, err = callWithMetadata(t, service, metadata.Pairs("authorization", "Bearer "+refreshed.Data.Token), "/userpb.UserService/ListSessions")
+	assert.Nil(t, err)
+
+	// the successor can be rotated again
+	rotatedAgain, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: refreshed.Data.RefreshToken})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), rotatedAgain.StatusCode)
+}
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	tokens, _ := loginFrom(t, service, &user, "Laptop", "203.0.113.7")
+	other, _ := loginFrom(t, service, &user, "Phone", "198.51.100.4")
+
+	refreshed, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: tokens.RefreshToken})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), refreshed.StatusCode)
+
+	// presenting the rotated token again looks like a stolen token
+	reused, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: tokens.RefreshToken})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), reused.StatusCode)
+
+	// so the whole family is revoked, including the successor
+	successor, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: refreshed.Data.RefreshToken})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), successor.StatusCode)
+	_, err = callWithMetadata(t, service, metadata.Pairs("authorization", "Bearer "+refreshed.Data.Token), "/userpb.UserService/ListSessions")
+	assert.NotNil(t, err)
+
+	// other sessions of the user are not affected
+	otherRefreshed, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: other.RefreshToken})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), otherRefreshed.StatusCode)
+}
+
+func TestRefreshTokenRejectsUnknownAndExpired(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	tokens, _ := loginFrom(t, service, &user, "Laptop", "203.0.113.7")
+
+	missing, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusBadRequest), missing.StatusCode)
+	unknown, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: "unknown"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), unknown.StatusCode)
+
+	require.Nil(t, userDbConnector.Model(&model.RefreshToken{}).Where("user_id = ?", user.ID).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	expired, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: tokens.RefreshToken})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), expired.StatusCode)
+}
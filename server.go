@@ -0,0 +1,22 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/policy"
+	"auth-microservice/ratelimit"
+
+	"google.golang.org/grpc"
+)
+
+// newGrpcServer creates the gRPC server with the interceptors every RPC
+// passes through. The rate limiter comes first, so requests with invalid
+// tokens are limited by client address before any token is verified.
+func newGrpcServer(jwtManager *jwt.JWTManager, accessPolicy *policy.Policy, rateLimiter *ratelimit.Limiter) *grpc.Server {
+	return grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			rateLimiter.UnaryInterceptor(rateLimitKey),
+			jwtManager.UnaryServerInterceptor(accessPolicy.PublicMethods()...),
+			accessPolicy.UnaryInterceptor,
+		),
+	)
+}
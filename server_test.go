@@ -0,0 +1,176 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	"auth-microservice/policy"
+	userpb "auth-microservice/proto/user"
+	"auth-microservice/ratelimit"
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// stubUserService answers without a database, so only the interceptors of
+// the server are exercised.
+type stubUserService struct {
+	userpb.UnimplementedUserServiceServer
+}
+
+func (stubUserService) AddUser(ctx context.Context, request *userpb.AddUserRequest) (*userpb.AddUserResponse, error) {
+	return &userpb.AddUserResponse{StatusCode: StatusCreated}, nil
+}
+
+func (stubUserService) GetUserDetails(ctx context.Context, request *userpb.GetUserDetailsRequest) (*userpb.GetUserDetailsResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		return &userpb.GetUserDetailsResponse{StatusCode: StatusUnauthorized}, nil
+	}
+	return &userpb.GetUserDetailsResponse{
+		Data: &userpb.GetUserDetailsResponseData{User: &userpb.User{
+			UserId:    strconv.FormatUint(uint64(principal.UserID), 10),
+			UserEmail: principal.Email,
+		}},
+		StatusCode: StatusOK,
+	}, nil
+}
+
+// startTestServer serves the stub service through server in memory and
+// returns a client connected to it.
+func startTestServer(t *testing.T, server *grpc.Server) userpb.UserServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	userpb.RegisterUserServiceServer(server, stubUserService{})
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	connection, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	t.Cleanup(func() { connection.Close() })
+	return userpb.NewUserServiceClient(connection)
+}
+
+// loadAccessPolicy loads the rules declared in user.proto, as main does.
+func loadAccessPolicy(t *testing.T) *policy.Policy {
+	accessPolicy, err := policy.FromService(userpb.File_user_user_proto.Services().ByName("UserService"))
+	require.Nil(t, err)
+	return accessPolicy
+}
+
+// loadRateLimiter builds the limiter from RATE_LIMITS, as main does.
+func loadRateLimiter(t *testing.T) *ratelimit.Limiter {
+	rateLimiter, err := newRateLimiter()
+	require.Nil(t, err)
+	return rateLimiter
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestServerVerifiesTokensOfTheKeySet(t *testing.T) {
+	keySet, err := jwt.LoadKeySet(t.TempDir(), jwt.AlgorithmEdDSA)
+	require.Nil(t, err)
+	manager, _ := jwt.NewJWTManager("", time.Hour, 24*time.Hour)
+	manager.UseKeySet(keySet)
+	manager.UseClaimsValidation(defaultTokenIssuer, defaultTokenAudience, time.Minute)
+	client := startTestServer(t, newGrpcServer(manager, loadAccessPolicy(t), loadRateLimiter(t)))
+
+	admin := &model.User{Email: "admin@example.com", Role: model.AdminRole}
+	admin.ID = 7
+	token, err := manager.GenerateToken(admin, "")
+	require.Nil(t, err)
+	response, err := client.GetUserDetails(withToken(token), &userpb.GetUserDetailsRequest{})
+	require.Nil(t, err)
+	assert.Equal(t, "7", response.Data.User.UserId)
+	assert.Equal(t, "admin@example.com", response.Data.User.UserEmail)
+
+	// tokens of other keys or audiences and calls without a token are rejected
+	otherKeySet, err := jwt.LoadKeySet(t.TempDir(), jwt.AlgorithmEdDSA)
+	require.Nil(t, err)
+	other, _ := jwt.NewJWTManager("", time.Hour, 24*time.Hour)
+	other.UseKeySet(otherKeySet)
+	other.UseClaimsValidation(defaultTokenIssuer, defaultTokenAudience, time.Minute)
+	token, err = other.GenerateToken(&model.User{Email: "admin@example.com", Role: model.AdminRole}, "")
+	require.Nil(t, err)
+	_, err = client.GetUserDetails(withToken(token), &userpb.GetUserDetailsRequest{})
+	assert.NotNil(t, err)
+	manager.UseClaimsValidation(defaultTokenIssuer, "orders", time.Minute)
+	token, err = manager.GenerateToken(&model.User{Email: "admin@example.com", Role: model.AdminRole}, "")
+	require.Nil(t, err)
+	manager.UseClaimsValidation(defaultTokenIssuer, defaultTokenAudience, time.Minute)
+	_, err = client.GetUserDetails(withToken(token), &userpb.GetUserDetailsRequest{})
+	assert.NotNil(t, err)
+	_, err = client.GetUserDetails(context.Background(), &userpb.GetUserDetailsRequest{})
+	assert.NotNil(t, err)
+
+	// public RPCs need no token
+	added, err := client.AddUser(context.Background(), &userpb.AddUserRequest{})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusCreated), added.StatusCode)
+}
+
+func TestServerEnforcesTheAccessPolicy(t *testing.T) {
+	manager, _ := jwt.NewJWTManager("secret", time.Hour, 24*time.Hour)
+	client := startTestServer(t, newGrpcServer(manager, loadAccessPolicy(t), loadRateLimiter(t)))
+
+	token, err := manager.GenerateToken(&model.User{Email: "user@example.com", Role: model.UserRole}, "")
+	require.Nil(t, err)
+	_, err = client.GetUserDetails(withToken(token), &userpb.GetUserDetailsRequest{})
+	assert.Equal(t, codes.Code(403), status.Code(err))
+
+	_, err = client.GetUserDetails(context.Background(), &userpb.GetUserDetailsRequest{})
+	assert.Equal(t, codes.Code(401), status.Code(err))
+
+	token, err = manager.GenerateToken(&model.User{Email: "admin@example.com", Role: model.AdminRole}, "")
+	require.Nil(t, err)
+	_, err = client.GetUserDetails(withToken(token), &userpb.GetUserDetailsRequest{})
+	assert.Nil(t, err)
+}
+
+func TestServerRateLimitsRequests(t *testing.T) {
+	t.Setenv("RATE_LIMITS", "AddUser=1/h:2")
+	manager, _ := jwt.NewJWTManager("secret", time.Hour, 24*time.Hour)
+	client := startTestServer(t, newGrpcServer(manager, loadAccessPolicy(t), loadRateLimiter(t)))
+
+	for i := 0; i < 2; i++ {
+		_, err := client.AddUser(context.Background(), &userpb.AddUserRequest{})
+		require.Nil(t, err)
+	}
+	_, err := client.AddUser(context.Background(), &userpb.AddUserRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestServerRateLimitsInvalidTokens(t *testing.T) {
+	t.Setenv("RATE_LIMITS", "GetUserDetails=1/h:2")
+	manager, _ := jwt.NewJWTManager("secret", time.Hour, 24*time.Hour)
+	client := startTestServer(t, newGrpcServer(manager, loadAccessPolicy(t), loadRateLimiter(t)))
+
+	// guessed tokens are limited before they are verified
+	for i := 0; i < 2; i++ {
+		_, err := client.GetUserDetails(withToken("invalid"), &userpb.GetUserDetailsRequest{})
+		require.NotNil(t, err)
+		assert.NotEqual(t, codes.ResourceExhausted, status.Code(err))
+	}
+	_, err := client.GetUserDetails(withToken("invalid"), &userpb.GetUserDetailsRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// a valid token from the same address is limited too
+	token, err := manager.GenerateToken(&model.User{Email: "admin@example.com", Role: model.AdminRole}, "")
+	require.Nil(t, err)
+	_, err = client.GetUserDetails(withToken(token), &userpb.GetUserDetailsRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"auth-microservice/config"
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// serviceTokenDuration is how long a service client token stays valid, the
+// client simply requests a new one with its credentials.
+const serviceTokenDuration = time.Hour
+
+func (userServiceManager *UserService) CreateServiceClient(ctx context.Context, request *userpb.CreateServiceClientRequest) (*userpb.CreateServiceClientResponse, error) {
+	logger.Info("Received CreateServiceClient request", zap.String("clientName", request.Name))
+	valid := request.Name != "" && len(request.Scopes) > 0
+	for _, scope := range request.Scopes {
+		valid = valid && config.ValidatePermissionName(scope)
+	}
+	if !valid {
+		logger.Warn("Invalid request fields", zap.String("clientName", request.Name))
+		return &userpb.CreateServiceClientResponse{
+			Data:       nil,
+			Message:    "The request contains missing or invalid fields. Scopes must look like \"users:read\".",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	clientID, err := jwt.GenerateID()
+	client := &model.ServiceClient{ClientID: clientID, Name: request.Name, Scopes: strings.Join(request.Scopes, " ")}
+	var clientSecret string
+	if err == nil {
+		clientSecret, client.SecretHash, err = jwt.GenerateOpaqueToken()
+	}
+	if err == nil {
+		err = userDbConnector.Create(client).Error
+	}
+	if err != nil {
+		logger.Error("Failed to create service client", zap.String("clientName", request.Name), zap.Error(err))
+		return &userpb.CreateServiceClientResponse{
+			Data:       nil,
+			Message:    "Failed to create client",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Service client created successfully", zap.String("clientName", client.Name), zap.String("clientId", client.ClientID))
+	return &userpb.CreateServiceClientResponse{
+		Data: &userpb.ServiceClient{
+			ClientId:     client.ClientID,
+			ClientSecret: clientSecret,
+			Name:         client.Name,
+			Scopes:       request.Scopes,
+		},
+		Message:    "Client created successfully, the secret is only shown once.",
+		Error:      "",
+		StatusCode: StatusCreated,
+	}, nil
+}
+
+// RotateServiceClientSecret replaces the secret of a client, for example when
+// it leaked. Tokens issued with the previous secret stop being accepted.
+func (userServiceManager *UserService) RotateServiceClientSecret(ctx context.Context, request *userpb.RotateServiceClientSecretRequest) (*userpb.RotateServiceClientSecretResponse, error) {
+	logger.Info("Received RotateServiceClientSecret request", zap.String("clientId", request.ClientId))
+	var client model.ServiceClient
+	if err := userDbConnector.Where("client_id = ?", request.ClientId).First(&client).Error; err != nil {
+		logger.Warn("Service client not found", zap.String("clientId", request.ClientId), zap.Error(err))
+		return &userpb.RotateServiceClientSecretResponse{
+			Data:       nil,
+			Message:    "Client not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	clientSecret, secretHash, err := jwt.GenerateOpaqueToken()
+	if err == nil {
+		err = userDbConnector.Model(&client).Updates(map[string]interface{}{
+			"secret_hash":       secretHash,
+			"secret_rotated_at": time.Now(),
+		}).Error
+	}
+	if err != nil {
+		logger.Error("Failed to rotate service client secret", zap.String("clientId", client.ClientID), zap.Error(err))
+		return &userpb.RotateServiceClientSecretResponse{
+			Data:       nil,
+			Message:    "Failed to rotate the client secret, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Service client secret rotated", zap.String("clientId", client.ClientID))
+	return &userpb.RotateServiceClientSecretResponse{
+		Data: &userpb.ServiceClient{
+			ClientId:     client.ClientID,
+			ClientSecret: clientSecret,
+			Name:         client.Name,
+			Scopes:       strings.Fields(client.Scopes),
+		},
+		Message:    "Client secret rotated successfully, the secret is only shown once.",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+// DeleteServiceClient removes a client, which revokes the tokens issued to it.
+func (*UserService) DeleteServiceClient(ctx context.Context, request *userpb.DeleteServiceClientRequest) (*userpb.DeleteServiceClientResponse, error) {
+	logger.Info("Received DeleteServiceClient request", zap.String("clientId", request.ClientId))
+	result := userDbConnector.Where("client_id = ?", request.ClientId).Delete(&model.ServiceClient{})
+	if result.Error != nil {
+		logger.Error("Failed to delete service client", zap.String("clientId", request.ClientId), zap.Error(result.Error))
+		return &userpb.DeleteServiceClientResponse{
+			Message:    "Failed to delete the client, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if result.RowsAffected == 0 {
+		logger.Warn("Service client not found", zap.String("clientId", request.ClientId))
+		return &userpb.DeleteServiceClientResponse{
+			Message:    "Client not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	logger.Info("Service client deleted", zap.String("clientId", request.ClientId))
+	return &userpb.DeleteServiceClientResponse{
+		Message:    "Client deleted successfully",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+// IssueServiceToken implements the OAuth2 client credentials grant. The
+// token gets the requested scopes, or all scopes of the client if none are
+// requested.
+func (userServiceManager *UserService) IssueServiceToken(ctx context.Context, request *userpb.IssueServiceTokenRequest) (*userpb.IssueServiceTokenResponse, error) {
+	logger.Info("Received IssueServiceToken request", zap.String("clientId", request.ClientId))
+	if request.GrantType != "client_credentials" || request.ClientId == "" || request.ClientSecret == "" {
+		logger.Warn("Invalid request fields", zap.String("clientId", request.ClientId))
+		return &userpb.IssueServiceTokenResponse{
+			Data:       nil,
+			Message:    "The request contains missing or invalid fields. Only the client_credentials grant is supported.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var client model.ServiceClient
+	err := userDbConnector.Where("client_id = ?", request.ClientId).First(&client).Error
+	if err != nil || subtle.ConstantTimeCompare([]byte(jwt.HashOpaqueToken(request.ClientSecret)), []byte(client.SecretHash)) != 1 {
+		logger.Warn("Invalid client credentials", zap.String("clientId", request.ClientId), zap.Error(err))
+		return &userpb.IssueServiceTokenResponse{
+			Data:       nil,
+			Message:    "Invalid client credentials",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	scopes := strings.Fields(client.Scopes)
+	if request.Scope != "" {
+		scopes = strings.Fields(request.Scope)
+		for _, scope := range scopes {
+			if !strings.Contains(" "+client.Scopes+" ", " "+scope+" ") {
+				logger.Warn("Scope not granted to client", zap.String("clientId", client.ClientID), zap.String("scope", scope))
+				return &userpb.IssueServiceTokenResponse{
+					Data:       nil,
+					Message:    "The client was not granted the " + scope + " scope",
+					Error:      "Forbidden",
+					StatusCode: StatusForbidden,
+				}, nil
+			}
+		}
+	}
+	token, err := userServiceManager.jwtManager.GenerateServiceToken(client.ClientID, scopes, serviceTokenDuration)
+	if err != nil {
+		logger.Error("Error in generating service token", zap.String("clientId", client.ClientID), zap.Error(err))
+		return &userpb.IssueServiceTokenResponse{
+			Data:       nil,
+			Message:    "Security Issues, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Service token issued", zap.String("clientId", client.ClientID), zap.Strings("scopes", scopes))
+	return &userpb.IssueServiceTokenResponse{
+		Data: &userpb.ServiceToken{
+			AccessToken: token,
+			TokenType:   "Bearer",
+			ExpiresIn:   int64(serviceTokenDuration.Seconds()),
+			Scope:       strings.Join(scopes, " "),
+		},
+		Message:    "Token issued successfully",
+		Error:      "",
+		StatusCode: StatusCreated,
+	}, nil
+}
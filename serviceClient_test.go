@@ -0,0 +1,125 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func callAsService(t *testing.T, service *UserService, token string, fullMethod string) (*jwt.Principal, error) {
+	return callWithMetadata(t, service, metadata.Pairs("authorization", "Bearer "+token), fullMethod)
+}
+
+func TestServiceClientCredentials(t *testing.T) {
+	service, _ := newTestUserService(t)
+	created, err := service.CreateServiceClient(context.Background(), &userpb.CreateServiceClientRequest{
+		Name: "orders", Scopes: []string{"users:read", "orders:write"}})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), created.StatusCode)
+
+	issued, err := service.IssueServiceToken(context.Background(), &userpb.IssueServiceTokenRequest{
+		GrantType: "client_credentials", ClientId: created.Data.ClientId, ClientSecret: "wrong"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), issued.StatusCode)
+
+	issued, err = service.IssueServiceToken(context.Background(), &userpb.IssueServiceTokenRequest{
+		GrantType: "client_credentials", ClientId: created.Data.ClientId, ClientSecret: created.Data.ClientSecret,
+		Scope: "roles:manage"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusForbidden), issued.StatusCode)
+
+	issued, err = service.IssueServiceToken(context.Background(), &userpb.IssueServiceTokenRequest{
+		GrantType: "client_credentials", ClientId: created.Data.ClientId, ClientSecret: created.Data.ClientSecret,
+		Scope: "users:read"})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), issued.StatusCode)
+	assert.Equal(t, "users:read", issued.Data.Scope)
+
+	principal, err := callAsService(t, service, issued.Data.AccessToken, "/userpb.UserService/GetUserProfile")
+	require.Nil(t, err)
+	assert.True(t, principal.IsService())
+	assert.Equal(t, created.Data.ClientId, principal.ClientID)
+	assert.Zero(t, principal.UserID)
+
+	// service clients have no account of their own
+	_, err = callAsService(t, service, issued.Data.AccessToken, "/userpb.UserService/ChangePassword")
+	assert.NotNil(t, err)
+	var user model.User
+	assert.Equal(t, errServicePrincipal, loadPrincipalUser(principal, &user))
+
+	// deleting the client revokes its tokens
+	deleted, err := service.DeleteServiceClient(context.Background(), &userpb.DeleteServiceClientRequest{ClientId: created.Data.ClientId})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), deleted.StatusCode)
+	_, err = callAsService(t, service, issued.Data.AccessToken, "/userpb.UserService/GetUserProfile")
+	assert.NotNil(t, err)
+	issued, err = service.IssueServiceToken(context.Background(), &userpb.IssueServiceTokenRequest{
+		GrantType: "client_credentials", ClientId: created.Data.ClientId, ClientSecret: created.Data.ClientSecret})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), issued.StatusCode)
+
+	deleted, err = service.DeleteServiceClient(context.Background(), &userpb.DeleteServiceClientRequest{ClientId: created.Data.ClientId})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), deleted.StatusCode)
+}
+
+func TestRotateServiceClientSecret(t *testing.T) {
+	service, _ := newTestUserService(t)
+	created, err := service.CreateServiceClient(context.Background(), &userpb.CreateServiceClientRequest{
+		Name: "orders", Scopes: []string{"users:read"}})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), created.StatusCode)
+	issued, err := service.IssueServiceToken(context.Background(), &userpb.IssueServiceTokenRequest{
+		GrantType: "client_credentials", ClientId: created.Data.ClientId, ClientSecret: created.Data.ClientSecret})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), issued.StatusCode)
+	// tokens carry their issue time in seconds, so rotate in the next second
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+
+	rotated, err := service.RotateServiceClientSecret(context.Background(), &userpb.RotateServiceClientSecretRequest{ClientId: created.Data.ClientId})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), rotated.StatusCode)
+	assert.NotEqual(t, created.Data.ClientSecret, rotated.Data.ClientSecret)
+	assert.Equal(t, []string{"users:read"}, rotated.Data.Scopes)
+
+	// the old secret and its tokens stop working
+	_, err = callAsService(t, service, issued.Data.AccessToken, "/userpb.UserService/GetUserProfile")
+	assert.NotNil(t, err)
+	reissued, err := service.IssueServiceToken(context.Background(), &userpb.IssueServiceTokenRequest{
+		GrantType: "client_credentials", ClientId: created.Data.ClientId, ClientSecret: created.Data.ClientSecret})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), reissued.StatusCode)
+
+	reissued, err = service.IssueServiceToken(context.Background(), &userpb.IssueServiceTokenRequest{
+		GrantType: "client_credentials", ClientId: created.Data.ClientId, ClientSecret: rotated.Data.ClientSecret})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusCreated), reissued.StatusCode)
+	_, err = callAsService(t, service, reissued.Data.AccessToken, "/userpb.UserService/GetUserProfile")
+	assert.Nil(t, err)
+
+	rotated, err = service.RotateServiceClientSecret(context.Background(), &userpb.RotateServiceClientSecretRequest{ClientId: "unknown"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), rotated.StatusCode)
+}
+
+func TestGetUserProfile(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+
+	response, err := service.GetUserProfile(context.Background(), &userpb.GetUserProfileRequest{UserId: "1"})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), response.StatusCode)
+	assert.Equal(t, user.Email, response.Data.UserEmail)
+
+	response, err = service.GetUserProfile(context.Background(), &userpb.GetUserProfileRequest{UserId: "2"})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), response.StatusCode)
+}
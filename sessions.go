@@ -0,0 +1,121 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func userpbSession(session *model.Session, currentSessionID string) *userpb.Session {
+	return &userpb.Session{
+		SessionId:  session.SessionID,
+		UserAgent:  session.UserAgent,
+		Ip:         session.IP,
+		CreatedAt:  session.CreatedAt.UTC().Format(time.RFC3339),
+		LastSeenAt: session.LastSeenAt.UTC().Format(time.RFC3339),
+		Current:    session.SessionID == currentSessionID,
+	}
+}
+
+// ListSessions returns the devices the user is logged in on, most recently
+// used first.
+func (*UserService) ListSessions(ctx context.Context, request *userpb.ListSessionsRequest) (*userpb.ListSessionsResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.ListSessionsResponse{
+			Data:       nil,
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received ListSessions request", zap.String("userEmail", userEmail))
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.ListSessionsResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	var sessions []model.Session
+	err := userDbConnector.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", user.ID, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error
+	if err != nil {
+		logger.Error("Failed to list sessions", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.ListSessionsResponse{
+			Data:       nil,
+			Message:    "Failed to list the sessions, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	data := make([]*userpb.Session, 0, len(sessions))
+	for i := range sessions {
+		data = append(data, userpbSession(&sessions[i], principal.SessionID))
+	}
+	return &userpb.ListSessionsResponse{
+		Data:       data,
+		Message:    "Sessions fetched successfully",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+// RevokeSession logs a device out. Its refresh tokens stop working and its
+// access tokens are rejected by the interceptor right away.
+func (*UserService) RevokeSession(ctx context.Context, request *userpb.RevokeSessionRequest) (*userpb.RevokeSessionResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.RevokeSessionResponse{
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received RevokeSession request", zap.String("userEmail", userEmail), zap.String("sessionId", request.SessionId))
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.RevokeSessionResponse{
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	var session model.Session
+	err := userDbConnector.Where("session_id = ? AND user_id = ? AND revoked_at IS NULL", request.SessionId, user.ID).
+		First(&session).Error
+	if err != nil {
+		logger.Warn("Session not found", zap.String("userEmail", userEmail), zap.String("sessionId", request.SessionId), zap.Error(err))
+		return &userpb.RevokeSessionResponse{
+			Message:    "Session not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if err := revokeRefreshTokenFamily(session.SessionID); err != nil {
+		logger.Error("Failed to revoke session", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.RevokeSessionResponse{
+			Message:    "Failed to revoke the session, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Session revoked", zap.String("userEmail", userEmail), zap.String("sessionId", session.SessionID))
+	return &userpb.RevokeSessionResponse{
+		Message:    "Session revoked successfully",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+// loginFrom issues tokens like a login from the device and returns them with
+// the context of an RPC made with the access token.
+func loginFrom(t *testing.T, service *UserService, user *model.User, device string, ip string) (*userpb.Responsedata, context.Context) {
+	deviceCtx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs("grpcgateway-user-agent", device, "x-forwarded-for", ip))
+	tokens, err := service.issueTokens(deviceCtx, user, "")
+	require.Nil(t, err)
+	principal, err := callWithMetadata(t, service, metadata.Pairs("authorization", "Bearer "+tokens.Token), "/userpb.UserService/ListSessions")
+	require.Nil(t, err)
+	return tokens, jwt.NewContext(context.Background(), principal)
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	_, laptopCtx := loginFrom(t, service, &user, "Laptop", "203.0.113.7")
+	phone, _ := loginFrom(t, service, &user, "Phone", "198.51.100.4")
+
+	listed, err := service.ListSessions(laptopCtx, &userpb.ListSessionsRequest{})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), listed.StatusCode)
+	require.Len(t, listed.Data, 2)
+	var phoneSessionID string
+	for _, session := range listed.Data {
+		if session.UserAgent == "Phone" {
+			phoneSessionID = session.SessionId
+			assert.Equal(t, "198.51.100.4", session.Ip)
+			assert.False(t, session.Current)
+		} else {
+			assert.True(t, session.Current)
+		}
+	}
+	require.NotEmpty(t, phoneSessionID)
+
+	revoked, err := service.RevokeSession(laptopCtx, &userpb.RevokeSessionRequest{SessionId: phoneSessionID})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), revoked.StatusCode)
+
+	// the phone is logged out, its access and refresh tokens stop working
+	_, err = callWithMetadata(t, service, metadata.Pairs("authorization", "Bearer "+phone.Token), "/userpb.UserService/ListSessions")
+	assert.NotNil(t, err)
+	refreshed, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: phone.RefreshToken})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), refreshed.StatusCode)
+
+	listed, err = service.ListSessions(laptopCtx, &userpb.ListSessionsRequest{})
+	require.Nil(t, err)
+	assert.Len(t, listed.Data, 1)
+
+	revoked, err = service.RevokeSession(laptopCtx, &userpb.RevokeSessionRequest{SessionId: phoneSessionID})
+	require.Nil(t, err)
+	assert.Equal(t, int64(StatusNotFound), revoked.StatusCode)
+}
+
+func TestRefreshKeepsSessionAndLogoutEndsIt(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "user", Email: "user@example.com", Phone: "9876543210", Role: model.UserRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	tokens, ctx := loginFrom(t, service, &user, "Laptop", "203.0.113.7")
+
+	refreshed, err := service.RefreshToken(context.Background(), &userpb.RefreshTokenRequest{RefreshToken: tokens.RefreshToken})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), refreshed.StatusCode)
+	principal, err := callWithMetadata(t, service, metadata.Pairs("authorization", "Bearer "+refreshed.Data.Token), "/userpb.UserService/ListSessions")
+	require.Nil(t, err)
+	assert.Equal(t, jwtPrincipal(t, ctx).SessionID, principal.SessionID)
+
+	loggedOut, err := service.Logout(ctx, &userpb.LogoutRequest{})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), loggedOut.StatusCode)
+	_, err = callWithMetadata(t, service, metadata.Pairs("authorization", "Bearer "+refreshed.Data.Token), "/userpb.UserService/ListSessions")
+	assert.NotNil(t, err)
+}
+
+func jwtPrincipal(t *testing.T, ctx context.Context) *jwt.Principal {
+	principal, ok := jwt.FromContext(ctx)
+	require.True(t, ok)
+	return principal
+}
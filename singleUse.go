@@ -0,0 +1,27 @@
+package main
+
+import (
+	"gorm.io/gorm"
+)
+
+// consumeOnce loads the record matching the conditions into record and
+// hard deletes it, so a state, challenge or code is used at most once. It returns
+// notFound when nothing matches or a concurrent request used the record first.
+func consumeOnce(record interface{}, notFound error, query string, args ...interface{}) error {
+	err := userDbConnector.Where(query, args...).First(record).Error
+	if err == gorm.ErrRecordNotFound {
+		return notFound
+	}
+	if err != nil {
+		return err
+	}
+	// the delete is conditional, so concurrent requests cannot both use it
+	result := userDbConnector.Unscoped().Delete(record)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return notFound
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SmsSender delivers text messages to phone numbers.
+type SmsSender interface {
+	Send(ctx context.Context, phone string, message string) error
+}
+
+// LogSender writes text messages to the log instead of sending them, for
+// development only, the log then holds the codes sent in the messages.
+type LogSender struct {
+	logger *zap.Logger
+}
+
+func NewLogSender(logger *zap.Logger) *LogSender {
+	return &LogSender{logger: logger}
+}
+
+func (sender *LogSender) Send(ctx context.Context, phone string, message string) error {
+	sender.logger.Info("SMS", zap.String("phone", phone), zap.String("message", message))
+	return nil
+}
+
+// TwilioSender sends text messages through the Twilio Messaging API.
+type TwilioSender struct {
+	client     *http.Client
+	messageURL string
+	accountSID string
+	authToken  string
+	from       string
+	// countryCode is put in front of the 10 digit phone numbers, like "+91"
+	countryCode string
+}
+
+func NewTwilioSender(accountSID string, authToken string, from string, countryCode string) *TwilioSender {
+	return &TwilioSender{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		messageURL:  "https://api.twilio.com/2010-04-01/Accounts/" + url.PathEscape(accountSID) + "/Messages.json",
+		accountSID:  accountSID,
+		authToken:   authToken,
+		from:        from,
+		countryCode: countryCode,
+	}
+}
+
+func (sender *TwilioSender) Send(ctx context.Context, phone string, message string) error {
+	form := url.Values{"To": {sender.countryCode + phone}, "From": {sender.from}, "Body": {message}}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sender.messageURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.SetBasicAuth(sender.accountSID, sender.authToken)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := sender.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("could not send sms: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("could not send sms: twilio responded %s: %s", response.Status, body)
+	}
+	return nil
+}
+
+// FakeSender keeps text messages in memory so tests can read them back.
+type FakeSender struct {
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func NewFakeSender() *FakeSender {
+	return &FakeSender{messages: map[string][]string{}}
+}
+
+func (sender *FakeSender) Send(ctx context.Context, phone string, message string) error {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	sender.messages[phone] = append(sender.messages[phone], message)
+	return nil
+}
+
+// Messages returns every message sent to phone, oldest first.
+func (sender *FakeSender) Messages(phone string) []string {
+	sender.mu.Lock()
+	defer sender.mu.Unlock()
+	return append([]string(nil), sender.messages[phone]...)
+}
+
+// LastMessage returns the latest message sent to phone.
+func (sender *FakeSender) LastMessage(phone string) (string, bool) {
+	messages := sender.Messages(phone)
+	if len(messages) == 0 {
+		return "", false
+	}
+	return messages[len(messages)-1], true
+}
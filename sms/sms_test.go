@@ -0,0 +1,49 @@
+package sms
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeSender(t *testing.T) {
+	sender := NewFakeSender()
+	_, ok := sender.LastMessage("9876543210")
+	assert.False(t, ok)
+
+	assert.Nil(t, sender.Send(context.Background(), "9876543210", "first"))
+	assert.Nil(t, sender.Send(context.Background(), "9876543210", "second"))
+	assert.Nil(t, sender.Send(context.Background(), "9876543211", "other"))
+
+	message, ok := sender.LastMessage("9876543210")
+	assert.True(t, ok)
+	assert.Equal(t, "second", message)
+	assert.Equal(t, []string{"first", "second"}, sender.Messages("9876543210"))
+}
+
+func TestTwilioSender(t *testing.T) {
+	var form map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if user != "AC123" || pass != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		form = map[string]string{"To": r.PostForm.Get("To"), "From": r.PostForm.Get("From"), "Body": r.PostForm.Get("Body")}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	sender := NewTwilioSender("AC123", "token", "+15005550006", "+91")
+	sender.messageURL = server.URL
+	assert.Nil(t, sender.Send(context.Background(), "9876543210", "123456 is your code"))
+	assert.Equal(t, map[string]string{"To": "+919876543210", "From": "+15005550006", "Body": "123456 is your code"}, form)
+
+	sender = NewTwilioSender("AC123", "wrong", "+15005550006", "+91")
+	sender.messageURL = server.URL
+	assert.NotNil(t, sender.Send(context.Background(), "9876543210", "123456 is your code"))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"auth-microservice/model"
+	"context"
+	"time"
+)
+
+// expirySweepInterval is how often expired records are deleted.
+const expirySweepInterval = time.Hour
+
+// expiringRecords are the records that are useless once they expire.
+// Abandoned logins and links leave single-use records behind.
+var expiringRecords = []interface{}{
+	&model.PasskeyChallenge{},
+	&model.OAuthState{},
+	&model.OAuthAuthorizationCode{},
+}
+
+// sweepExpiredRecords hard deletes the records that expired at now, and any
+// that were soft deleted before they were hard deleted on use. A revoked token
+// is only deleted once clockSkew passed after its expiry, until then the token
+// would still be accepted. It also deletes the otp requests that no longer
+// count towards the rate limits.
+func sweepExpiredRecords(now time.Time, clockSkew time.Duration) error {
+	for _, record := range expiringRecords {
+		err := userDbConnector.Unscoped().
+			Where("expires_at <= ? OR deleted_at IS NOT NULL", now).
+			Delete(record).Error
+		if err != nil {
+			return err
+		}
+	}
+	err := userDbConnector.Unscoped().
+		Where("expires_at <= ?", now.Add(-clockSkew)).
+		Delete(&model.RevokedToken{}).Error
+	if err != nil {
+		return err
+	}
+	// otp requests only count towards the limits within the longest window
+	return userDbConnector.
+		Where("created_at <= ?", now.Add(-otpIPWindow)).
+		Delete(&model.OtpRequest{}).Error
+}
+
+// startExpirySweep deletes expired records every interval until ctx is done.
+func startExpirySweep(ctx context.Context, interval time.Duration, clockSkew time.Duration, onError func(error)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if err := sweepExpiredRecords(now, clockSkew); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"auth-microservice/model"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSweepExpiredRecords(t *testing.T) {
+	newTestUserService(t)
+	now := time.Now()
+	require.Nil(t, userDbConnector.Create(&model.PasskeyChallenge{SessionID: "expired", ExpiresAt: now.Add(-time.Minute)}).Error)
+	require.Nil(t, userDbConnector.Create(&model.PasskeyChallenge{SessionID: "pending", ExpiresAt: now.Add(time.Minute)}).Error)
+	require.Nil(t, userDbConnector.Create(&model.OAuthState{State: "expired", ExpiresAt: now.Add(-time.Minute)}).Error)
+	require.Nil(t, userDbConnector.Create(&model.OAuthAuthorizationCode{CodeHash: "expired", ExpiresAt: now.Add(-time.Minute)}).Error)
+	deleted := model.OAuthAuthorizationCode{CodeHash: "deleted", ExpiresAt: now.Add(time.Minute)}
+	require.Nil(t, userDbConnector.Create(&deleted).Error)
+	require.Nil(t, userDbConnector.Delete(&deleted).Error)
+	require.Nil(t, userDbConnector.Create(&model.RevokedToken{TokenID: "expired", ExpiresAt: now.Add(-time.Minute)}).Error)
+	require.Nil(t, userDbConnector.Create(&model.RevokedToken{TokenID: "valid", ExpiresAt: now.Add(time.Minute)}).Error)
+
+	require.Nil(t, sweepExpiredRecords(now, 0))
+
+	var challenges []model.PasskeyChallenge
+	require.Nil(t, userDbConnector.Unscoped().Find(&challenges).Error)
+	require.Len(t, challenges, 1)
+	assert.Equal(t, "pending", challenges[0].SessionID)
+	var count int64
+	require.Nil(t, userDbConnector.Unscoped().Model(&model.OAuthState{}).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+	require.Nil(t, userDbConnector.Unscoped().Model(&model.OAuthAuthorizationCode{}).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+	var revoked []model.RevokedToken
+	require.Nil(t, userDbConnector.Unscoped().Find(&revoked).Error)
+	require.Len(t, revoked, 1)
+	assert.Equal(t, "valid", revoked[0].TokenID)
+}
+
+func TestSweepKeepsRevokedTokensWithinClockSkew(t *testing.T) {
+	newTestUserService(t)
+	now := time.Now()
+	clockSkew := time.Minute
+	require.Nil(t, userDbConnector.Create(&model.RevokedToken{TokenID: "past skew", ExpiresAt: now.Add(-clockSkew - time.Second)}).Error)
+	require.Nil(t, userDbConnector.Create(&model.RevokedToken{TokenID: "at skew", ExpiresAt: now.Add(-clockSkew)}).Error)
+	// still accepted by the interceptor, so the revocation has to stay
+	require.Nil(t, userDbConnector.Create(&model.RevokedToken{TokenID: "within skew", ExpiresAt: now.Add(-clockSkew + time.Second)}).Error)
+
+	require.Nil(t, sweepExpiredRecords(now, clockSkew))
+
+	var revoked []model.RevokedToken
+	require.Nil(t, userDbConnector.Unscoped().Find(&revoked).Error)
+	require.Len(t, revoked, 1)
+	assert.Equal(t, "within skew", revoked[0].TokenID)
+}
+
+func TestConsumeOnceHardDeletes(t *testing.T) {
+	newTestUserService(t)
+	errUsed := errors.New("used")
+	require.Nil(t, userDbConnector.Create(&model.OAuthState{State: "state", ExpiresAt: time.Now().Add(time.Minute)}).Error)
+
+	var state model.OAuthState
+	require.Nil(t, consumeOnce(&state, errUsed, "state = ?", "state"))
+	assert.Equal(t, errUsed, consumeOnce(&model.OAuthState{}, errUsed, "state = ?", "state"))
+
+	var count int64
+	require.Nil(t, userDbConnector.Unscoped().Model(&model.OAuthState{}).Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestSweepOldOtpRequests(t *testing.T) {
+	newTestUserService(t)
+	now := time.Now()
+	require.Nil(t, userDbConnector.Create(&model.OtpRequest{Phone: "old", CreatedAt: now.Add(-otpIPWindow - time.Minute)}).Error)
+	require.Nil(t, userDbConnector.Create(&model.OtpRequest{Phone: "recent", CreatedAt: now.Add(-time.Minute)}).Error)
+
+	require.Nil(t, sweepExpiredRecords(now, 0))
+
+	var requests []model.OtpRequest
+	require.Nil(t, userDbConnector.Find(&requests).Error)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "recent", requests[0].Phone)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/mailer"
+	"auth-microservice/model"
+	"auth-microservice/password"
+	"auth-microservice/policy"
+	userpb "auth-microservice/proto/user"
+	"auth-microservice/sms"
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// newTestUserService points userDbConnector at a fresh in-memory database and
+// returns a service that sends SMS to a fake sender and email to a fake mailer.
+func newTestUserService(t *testing.T) (*UserService, *sms.FakeSender) {
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: gormlogger.Discard})
+	require.Nil(t, err)
+	require.Nil(t, db.AutoMigrate(model.UserModels()...))
+	userDbConnector = db
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+
+	jwtManager, err := jwt.NewJWTManager("secret", time.Hour, 24*time.Hour)
+	require.Nil(t, err)
+	jwtManager.UseRevocationStore(jwt.NewGormRevocationStore(db))
+	sender := sms.NewFakeSender()
+	return &UserService{
+		jwtManager: jwtManager,
+		mailer:     mailer.NewFakeMailer(),
+		smsSender:  sender,
+
+		passwordPolicy:  password.DefaultPolicy(),
+		passwordHashers: testPasswordHashers,
+		loginThrottle:   defaultLoginThrottle(),
+		otpSecret:       []byte("otp-secret"),
+		totpKey:         []byte("totp-key"),
+	}, sender
+}
+
+// testPasswordHashers hash with argon2id and verify bcrypt hashes, like the
+// default configuration.
+var testPasswordHashers = func() *password.Hashers {
+	argon2idHasher, _ := password.NewArgon2idHasher(password.DefaultArgon2Params())
+	bcryptHasher, _ := password.NewBcryptHasher(bcrypt.DefaultCost)
+	return password.NewHashers(argon2idHasher, bcryptHasher)
+}()
+
+func hashPassword(t *testing.T, userPassword string) string {
+	hashedPassword, err := testPasswordHashers.Hash(userPassword)
+	require.Nil(t, err)
+	return hashedPassword
+}
+
+var otpCodePattern = regexp.MustCompile(`\d{6}`)
+
+func lastOtpCode(t *testing.T, sender *sms.FakeSender, phone string) string {
+	message, ok := sender.LastMessage(phone)
+	require.True(t, ok)
+	return otpCodePattern.FindString(message)
+}
+
+func contextFromIP(ip string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}})
+}
+
+// callWithMetadata runs fullMethod through the jwt and policy interceptors
+// with the request metadata and returns the principal the handler saw.
+func callWithMetadata(t *testing.T, service *UserService, md metadata.MD, fullMethod string) (*jwt.Principal, error) {
+	accessPolicy, err := policy.FromService(userpb.File_user_user_proto.Services().ByName("UserService"))
+	require.Nil(t, err)
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	info := &grpc.UnaryServerInfo{FullMethod: fullMethod}
+	var principal *jwt.Principal
+	handler := func(ctx context.Context, req any) (any, error) {
+		principal, _ = jwt.FromContext(ctx)
+		return nil, nil
+	}
+	_, err = service.jwtManager.UnaryServerInterceptor()(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		return accessPolicy.UnaryInterceptor(ctx, req, info, handler)
+	})
+	return principal, err
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	"auth-microservice/otp"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// codes of totpSkew time steps before or after now are accepted, for
+	// clocks that drift
+	totpSkew          = 1
+	recoveryCodeCount = 10
+	// recovery codes are typed by hand, 10 base32 characters are 50 bits
+	recoveryCodeLength = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// recoveryCodeHash keys the hash of a recovery code by its user, so a stored
+// hash only matches the codes of its own user.
+func (userServiceManager *UserService) recoveryCodeHash(userID uint, code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return otp.HashCode(userServiceManager.otpSecret, fmt.Sprintf("user:%d", userID), "recovery", normalized)
+}
+
+// generateRecoveryCodes replaces the recovery codes of user and returns the new
+// ones, they are only shown this once.
+func (userServiceManager *UserService) generateRecoveryCodes(user *model.User) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	records := make([]model.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, recoveryCodeLength*5/8)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		records[i] = model.RecoveryCode{UserID: user.ID, CodeHash: userServiceManager.recoveryCodeHash(user.ID, code)}
+	}
+	err := userDbConnector.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// acceptTotp checks a code of the authenticator of user. A code is accepted
+// once, its time step has to be after the step of the last accepted code.
+func (userServiceManager *UserService) acceptTotp(user *model.User, code string) (bool, error) {
+	secret, err := otp.OpenTotpSecret(userServiceManager.totpKey, user.TotpSecret)
+	if err != nil {
+		return false, err
+	}
+	step, ok := otp.ValidateTotp(secret, code, time.Now(), totpSkew)
+	if !ok {
+		return false, nil
+	}
+	// the update is conditional, so concurrent requests cannot both use a code
+	result := userDbConnector.Model(&model.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// useRecoveryCode marks an unused recovery code of user as used.
+func (userServiceManager *UserService) useRecoveryCode(user *model.User, code string) (bool, error) {
+	result := userDbConnector.Model(&model.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, userServiceManager.recoveryCodeHash(user.ID, code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// sealLegacyTotpSecrets encrypts the authenticator secrets that were stored
+// in plaintext before they were sealed with key.
+func sealLegacyTotpSecrets(key []byte) error {
+	var users []model.User
+	err := userDbConnector.Select("id", "totp_secret").
+		Where("totp_secret <> '' AND totp_secret NOT LIKE ?", "sealed:%").
+		Find(&users).Error
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		sealed, err := otp.SealTotpSecret(key, user.TotpSecret)
+		if err != nil {
+			return err
+		}
+		// the condition keeps a secret that was enrolled again meanwhile
+		err = userDbConnector.Model(&model.User{}).
+			Where("id = ? AND totp_secret = ?", user.ID, user.TotpSecret).
+			Update("totp_secret", sealed).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSecondFactor accepts a code of the authenticator or a recovery code.
+func (userServiceManager *UserService) checkSecondFactor(user *model.User, code string) (bool, error) {
+	if len(code) == otp.TotpDigits {
+		return userServiceManager.acceptTotp(user, code)
+	}
+	return userServiceManager.useRecoveryCode(user, code)
+}
+
+func (userServiceManager *UserService) EnrollTotp(ctx context.Context, request *userpb.EnrollTotpRequest) (*userpb.EnrollTotpResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.EnrollTotpResponse{
+			Data:       nil,
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received EnrollTotp request", zap.String("userEmail", userEmail))
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.EnrollTotpResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if user.TotpEnabled {
+		logger.Warn("Two-factor authentication already enabled", zap.String("userEmail", userEmail))
+		return &userpb.EnrollTotpResponse{
+			Data:       nil,
+			Message:    "Two-factor authentication is already enabled",
+			Error:      "Conflict",
+			StatusCode: StatusConflict,
+		}, nil
+	}
+	secret, err := otp.GenerateTotpSecret()
+	var sealedSecret string
+	if err == nil {
+		sealedSecret, err = otp.SealTotpSecret(userServiceManager.totpKey, secret)
+	}
+	if err == nil {
+		err = userDbConnector.Model(&user).Updates(map[string]interface{}{
+			"totp_secret":    sealedSecret,
+			"totp_last_step": 0,
+		}).Error
+	}
+	if err != nil {
+		logger.Error("Failed to enroll totp", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.EnrollTotpResponse{
+			Data:       nil,
+			Message:    "Failed to enroll two-factor authentication, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Totp enrolled", zap.String("userEmail", userEmail))
+	return &userpb.EnrollTotpResponse{
+		Data: &userpb.EnrollTotpResponseData{
+			Secret: secret,
+			Uri:    otp.TotpURI(getEnv("TOTP_ISSUER", "Meal Mingle"), user.Email, secret),
+		},
+		Message:    "Add the secret to an authenticator app and confirm it with a code.",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+func (userServiceManager *UserService) ConfirmTotp(ctx context.Context, request *userpb.ConfirmTotpRequest) (*userpb.ConfirmTotpResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.ConfirmTotpResponse{
+			Data:       nil,
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received ConfirmTotp request", zap.String("userEmail", userEmail))
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.ConfirmTotpResponse{
+			Data:       nil,
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if user.TotpEnabled {
+		logger.Warn("Two-factor authentication already enabled", zap.String("userEmail", userEmail))
+		return &userpb.ConfirmTotpResponse{
+			Data:       nil,
+			Message:    "Two-factor authentication is already enabled",
+			Error:      "Conflict",
+			StatusCode: StatusConflict,
+		}, nil
+	}
+	if user.TotpSecret == "" || len(request.Code) != otp.TotpDigits {
+		logger.Warn("Invalid request fields", zap.String("userEmail", userEmail))
+		return &userpb.ConfirmTotpResponse{
+			Data:       nil,
+			Message:    fmt.Sprintf("Enroll two-factor authentication first and send a %d digit code.", otp.TotpDigits),
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	accepted, err := userServiceManager.acceptTotp(&user, request.Code)
+	if err != nil {
+		logger.Error("Failed to check totp code", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.ConfirmTotpResponse{
+			Data:       nil,
+			Message:    "Failed to enable two-factor authentication, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if !accepted {
+		logger.Warn("Invalid totp code", zap.String("userEmail", userEmail))
+		return &userpb.ConfirmTotpResponse{
+			Data:       nil,
+			Message:    "The code is invalid",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	codes, err := userServiceManager.generateRecoveryCodes(&user)
+	if err == nil {
+		err = userDbConnector.Model(&user).Update("totp_enabled", true).Error
+	}
+	if err != nil {
+		logger.Error("Failed to enable totp", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.ConfirmTotpResponse{
+			Data:       nil,
+			Message:    "Failed to enable two-factor authentication, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Two-factor authentication enabled", zap.String("userEmail", userEmail))
+	return &userpb.ConfirmTotpResponse{
+		Data: &userpb.ConfirmTotpResponseData{
+			RecoveryCodes: codes,
+		},
+		Message:    "Two-factor authentication enabled. Store the recovery codes in a safe place, they are shown only once.",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+func (userServiceManager *UserService) DisableTotp(ctx context.Context, request *userpb.DisableTotpRequest) (*userpb.DisableTotpResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.DisableTotpResponse{
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	userEmail := principal.Email
+	logger.Info("Received DisableTotp request", zap.String("userEmail", userEmail))
+	if request.Password == "" || request.Code == "" {
+		logger.Warn("Invalid request fields", zap.String("userEmail", userEmail))
+		return &userpb.DisableTotpResponse{
+			Message:    "The request contains missing or invalid fields.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.DisableTotpResponse{
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if !user.TotpEnabled {
+		logger.Warn("Two-factor authentication not enabled", zap.String("userEmail", userEmail))
+		return &userpb.DisableTotpResponse{
+			Message:    "Two-factor authentication is not enabled",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	throttleKey := mfaThrottleKey(user.ID)
+	wait, locked, err := userServiceManager.loginThrottle.check(throttleKey)
+	if err != nil {
+		logger.Error("Failed to check mfa throttle", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.DisableTotpResponse{
+			Message:    "Failed to disable two-factor authentication, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if wait > 0 {
+		logger.Warn("Disable totp throttled", zap.String("userEmail", userEmail), zap.Duration("wait", wait))
+		return &userpb.DisableTotpResponse{
+			Message:    mfaThrottledMessage(wait, locked),
+			Error:      "Too Many Requests",
+			StatusCode: StatusTooManyRequests,
+		}, nil
+	}
+	if userServiceManager.passwordHashers.Compare(user.Password, request.Password) != nil {
+		userServiceManager.loginThrottle.recordFailures(throttleKey)
+		logger.Warn("Disable totp failed due to wrong password", zap.String("userEmail", userEmail))
+		return &userpb.DisableTotpResponse{
+			Message:    "The password or code is wrong",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	accepted, err := userServiceManager.checkSecondFactor(&user, request.Code)
+	if err == nil && !accepted {
+		userServiceManager.loginThrottle.recordFailures(throttleKey)
+		logger.Warn("Disable totp failed due to wrong code", zap.String("userEmail", userEmail))
+		return &userpb.DisableTotpResponse{
+			Message:    "The password or code is wrong",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	if err == nil {
+		err = userDbConnector.Transaction(func(tx *gorm.DB) error {
+			err := tx.Model(&user).Updates(map[string]interface{}{
+				"totp_secret":    "",
+				"totp_enabled":   false,
+				"totp_last_step": 0,
+			}).Error
+			if err != nil {
+				return err
+			}
+			return tx.Where("user_id = ?", user.ID).Delete(&model.RecoveryCode{}).Error
+		})
+	}
+	if err != nil {
+		logger.Error("Failed to disable totp", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.DisableTotpResponse{
+			Message:    "Failed to disable two-factor authentication, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if err := userServiceManager.loginThrottle.reset(throttleKey); err != nil {
+		logger.Error("Failed to reset mfa throttle", zap.String("userEmail", userEmail), zap.Error(err))
+	}
+	logger.Info("Two-factor authentication disabled", zap.String("userEmail", userEmail))
+	return &userpb.DisableTotpResponse{
+		Message:    "Two-factor authentication disabled",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	"auth-microservice/otp"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func totpCode(t *testing.T, secret string, step int64) string {
+	code, err := otp.TotpCode(secret, step)
+	require.Nil(t, err)
+	return code
+}
+
+// enableTotp enrolls and confirms two-factor authentication for user and
+// returns the secret and the recovery codes.
+func enableTotp(t *testing.T, service *UserService, user *model.User) (string, []string) {
+	ctx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: user.ID, Email: user.Email})
+	enrolled, err := service.EnrollTotp(ctx, &userpb.EnrollTotpRequest{})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), enrolled.StatusCode)
+	secret := enrolled.Data.Secret
+
+	confirmed, err := service.ConfirmTotp(ctx, &userpb.ConfirmTotpRequest{Code: totpCode(t, secret, otp.TotpStep(time.Now()))})
+	require.Nil(t, err)
+	require.Equal(t, int64(StatusOK), confirmed.StatusCode)
+	return secret, confirmed.Data.RecoveryCodes
+}
+
+func TestLoginWithTotp(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "owner", Email: "owner@example.com", Phone: "9876543210",
+		Password: hashPassword(t, "owner-password"), Role: model.AdminRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	secret, recoveryCodes := enableTotp(t, service, &user)
+	assert.Len(t, recoveryCodes, recoveryCodeCount)
+	ctx := context.Background()
+
+	login, err := service.AuthenticateUser(ctx, &userpb.AuthenticateUserRequest{
+		UserEmail: user.Email, UserPassword: "owner-password", Role: model.AdminRole})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), login.StatusCode)
+	assert.Nil(t, login.Data)
+	require.NotEmpty(t, login.MfaToken)
+
+	// the code that confirmed the enrollment cannot be used again
+	response, err := service.VerifyMfa(ctx, &userpb.VerifyMfaRequest{MfaToken: login.MfaToken,
+		Code: totpCode(t, secret, otp.TotpStep(time.Now()))})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), response.StatusCode)
+
+	response, err = service.VerifyMfa(ctx, &userpb.VerifyMfaRequest{MfaToken: login.MfaToken,
+		Code: totpCode(t, secret, otp.TotpStep(time.Now())+1)})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusCreated), response.StatusCode)
+	require.NotNil(t, response.Data)
+	assert.NotEmpty(t, response.Data.Token)
+
+	// the challenge can only be answered once
+	response, err = service.VerifyMfa(ctx, &userpb.VerifyMfaRequest{MfaToken: login.MfaToken, Code: recoveryCodes[0]})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), response.StatusCode)
+}
+
+func TestVerifyMfaWithRecoveryCode(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "owner", Email: "owner@example.com", Phone: "9876543210",
+		Password: hashPassword(t, "owner-password"), Role: model.AdminRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	_, recoveryCodes := enableTotp(t, service, &user)
+	ctx := context.Background()
+
+	for i, expected := range []int64{StatusCreated, StatusUnauthorized} {
+		login, err := service.AuthenticateUser(ctx, &userpb.AuthenticateUserRequest{
+			UserEmail: user.Email, UserPassword: "owner-password", Role: model.AdminRole})
+		require.Nil(t, err)
+		response, err := service.VerifyMfa(ctx, &userpb.VerifyMfaRequest{MfaToken: login.MfaToken, Code: recoveryCodes[0]})
+		assert.Nil(t, err)
+		assert.Equal(t, expected, response.StatusCode, "attempt %d", i)
+	}
+}
+
+func TestDisableTotp(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "owner", Email: "owner@example.com", Phone: "9876543210",
+		Password: hashPassword(t, "owner-password"), Role: model.AdminRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	_, recoveryCodes := enableTotp(t, service, &user)
+	ctx := jwt.NewContext(context.Background(), &jwt.Principal{UserID: user.ID, Email: user.Email})
+
+	response, err := service.DisableTotp(ctx, &userpb.DisableTotpRequest{Password: "wrong-password", Code: recoveryCodes[0]})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusUnauthorized), response.StatusCode)
+
+	response, err = service.DisableTotp(ctx, &userpb.DisableTotpRequest{Password: "owner-password", Code: recoveryCodes[0]})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusOK), response.StatusCode)
+
+	var stored model.User
+	require.Nil(t, userDbConnector.First(&stored, user.ID).Error)
+	assert.False(t, stored.TotpEnabled)
+	assert.Empty(t, stored.TotpSecret)
+
+	login, err := service.AuthenticateUser(context.Background(), &userpb.AuthenticateUserRequest{
+		UserEmail: user.Email, UserPassword: "owner-password", Role: model.AdminRole})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(StatusCreated), login.StatusCode)
+	assert.Empty(t, login.MfaToken)
+}
+
+func TestTotpSecretIsStoredSealed(t *testing.T) {
+	service, _ := newTestUserService(t)
+	user := model.User{Name: "owner", Email: "owner@example.com", Phone: "9876543210", Role: model.AdminRole}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+	secret, _ := enableTotp(t, service, &user)
+
+	var stored model.User
+	require.Nil(t, userDbConnector.First(&stored, user.ID).Error)
+	assert.True(t, otp.IsSealedTotpSecret(stored.TotpSecret))
+	assert.NotContains(t, stored.TotpSecret, secret)
+}
+
+func TestSealLegacyTotpSecrets(t *testing.T) {
+	service, _ := newTestUserService(t)
+	secret, err := otp.GenerateTotpSecret()
+	require.Nil(t, err)
+	user := model.User{Name: "owner", Email: "owner@example.com", Phone: "9876543210", Role: model.AdminRole,
+		TotpSecret: secret, TotpEnabled: true}
+	require.Nil(t, userDbConnector.Create(&user).Error)
+
+	// secrets stored in plaintext keep working until they are sealed
+	accepted, err := service.acceptTotp(&user, totpCode(t, secret, otp.TotpStep(time.Now())-1))
+	require.Nil(t, err)
+	assert.True(t, accepted)
+
+	require.Nil(t, sealLegacyTotpSecrets(service.totpKey))
+	var stored model.User
+	require.Nil(t, userDbConnector.First(&stored, user.ID).Error)
+	assert.True(t, otp.IsSealedTotpSecret(stored.TotpSecret))
+	accepted, err = service.acceptTotp(&stored, totpCode(t, secret, otp.TotpStep(time.Now())))
+	require.Nil(t, err)
+	assert.True(t, accepted)
+}
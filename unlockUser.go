@@ -0,0 +1,48 @@
+package main
+
+import (
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"strconv"
+
+	"go.uber.org/zap"
+)
+
+// UnlockUser clears the failed logins of an account, so the user can login
+// again right away. Lockouts of client addresses expire on their own.
+func (userServiceManager *UserService) UnlockUser(ctx context.Context, request *userpb.UnlockUserRequest) (*userpb.UnlockUserResponse, error) {
+	logger.Info("Received UnlockUser request", zap.String("userId", request.UserId))
+	userId, err := strconv.ParseUint(request.UserId, 10, 64)
+	if err != nil {
+		logger.Warn("Invalid user id", zap.String("userId", request.UserId))
+		return &userpb.UnlockUserResponse{
+			Message:    "Invalid user id",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	var user model.User
+	if err := userDbConnector.First(&user, userId).Error; err != nil {
+		logger.Warn("User not found", zap.String("userId", request.UserId), zap.Error(err))
+		return &userpb.UnlockUserResponse{
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if err := userServiceManager.loginThrottle.reset(accountThrottleKey(user.Email)); err != nil {
+		logger.Error("Failed to unlock user", zap.String("userId", request.UserId), zap.Error(err))
+		return &userpb.UnlockUserResponse{
+			Message:    "Failed to unlock user",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("User unlocked successfully", zap.String("userEmail", user.Email))
+	return &userpb.UnlockUserResponse{
+		Message:    "User unlocked successfully",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
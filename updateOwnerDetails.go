@@ -2,6 +2,7 @@ package main
 
 import (
 	"auth-microservice/config"
+	"auth-microservice/jwt"
 	"auth-microservice/model"
 	userpb "auth-microservice/proto/user"
 	"context"
@@ -12,24 +13,24 @@ import (
 
 func (*UserService) UpdateOwnerDetails(ctx context.Context, request *userpb.UpdateOwnerDetailsRequest) (*userpb.UpdateOwnerDetailsResponse, error) {
 	// get the user email from the context
-	userEmail, ok := ctx.Value("userEmail").(string)
+	principal, ok := jwt.FromContext(ctx)
 	if !ok {
-		logger.Error("Failed to get user email from context")
+		logger.Error("Failed to get authenticated user from context")
 		return &userpb.UpdateOwnerDetailsResponse{
 			Data:       nil,
-			Message:    "Failed to get user email from context",
+			Message:    "Failed to get authenticated user from context",
 			StatusCode: StatusInternalServerError,
 			Error:      "Internal Server Error",
 		}, nil
 	}
+	userEmail := principal.Email
 	logger.Info("Received UpdateOwnerDetails request", zap.String("userEmail", userEmail))
 
 	// get the user email from the database
 	var user model.User
 	var ownerDetails model.Details
-	err := userDbConnector.Where("email = ?", userEmail).First(&user)
-	if err.Error != nil {
-		logger.Warn("Admin does not exist", zap.String("userEmail", userEmail), zap.Error(err.Error))
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("Admin does not exist", zap.String("userEmail", userEmail), zap.Error(err))
 		return &userpb.UpdateOwnerDetailsResponse{
 			Data:       nil,
 			Message:    "Admin does not exist",
@@ -52,7 +53,7 @@ func (*UserService) UpdateOwnerDetails(ctx context.Context, request *userpb.Upda
 	}
 	// check if owner details already exists
 	ownerDetailsNotFoundError := ownerDetailsDbConector.Where("user_id = ?", user.ID).First(&ownerDetails).Error
-	if ownerDetailsNotFoundError != nil || user.Role != model.AdminRole {
+	if ownerDetailsNotFoundError != nil {
 		logger.Warn("Owner details not found", zap.String("userEmail", userEmail), zap.Error(ownerDetailsNotFoundError))
 		return &userpb.UpdateOwnerDetailsResponse{
 			Data:       nil,
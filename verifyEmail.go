@@ -0,0 +1,142 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/mailer"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const emailVerificationTokenDuration = 24 * time.Hour
+
+// sendVerificationEmail emails the user a single-use link to verify their address.
+func (userServiceManager *UserService) sendVerificationEmail(ctx context.Context, user *model.User) error {
+	token, err := userServiceManager.jwtManager.GenerateActionToken(user, jwt.PurposeEmailVerification, emailVerificationTokenDuration)
+	if err != nil {
+		return err
+	}
+	link := getEnv("EMAIL_VERIFICATION_URL", "http://localhost:3000/verify-email") + "?token=" + url.QueryEscape(token)
+	return userServiceManager.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Meal Mingle email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease verify your email address by opening the link below. "+
+			"The link expires in 24 hours.\n\n%s\n", user.Name, link),
+	})
+}
+
+func (userServiceManager *UserService) VerifyEmail(ctx context.Context, request *userpb.VerifyEmailRequest) (*userpb.VerifyEmailResponse, error) {
+	logger.Info("Received VerifyEmail request")
+	if request.Token == "" {
+		logger.Warn("Verification token is missing")
+		return &userpb.VerifyEmailResponse{
+			Message:    "The request contains missing or invalid fields.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	claims, err := userServiceManager.jwtManager.ConsumeActionToken(request.Token, jwt.PurposeEmailVerification)
+	if err != nil {
+		logger.Warn("Invalid verification token", zap.Error(err))
+		return &userpb.VerifyEmailResponse{
+			Message:    "The verification link is invalid, expired or was already used.",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	// the email must still match, the link is void if the address changed since
+	result := userDbConnector.Model(&model.User{}).
+		Where("id = ? AND email = ?", claims.UserID(), claims.UserEmail).
+		Update("email_verification_pending", false)
+	if result.Error != nil {
+		logger.Error("Failed to verify email", zap.String("userEmail", claims.UserEmail), zap.Error(result.Error))
+		return &userpb.VerifyEmailResponse{
+			Message:    "Failed to verify email, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if result.RowsAffected == 0 {
+		logger.Warn("User for verification token not found", zap.String("userEmail", claims.UserEmail))
+		return &userpb.VerifyEmailResponse{
+			Message:    "The verification link is invalid, expired or was already used.",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	logger.Info("Email verified successfully", zap.String("userEmail", claims.UserEmail))
+	return &userpb.VerifyEmailResponse{
+		Message:    "Email verified successfully. Please refresh your token or login again.",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+func (userServiceManager *UserService) ResendVerificationEmail(ctx context.Context, request *userpb.ResendVerificationEmailRequest) (*userpb.ResendVerificationEmailResponse, error) {
+	principal, ok := jwt.FromContext(ctx)
+	if !ok {
+		logger.Error("Failed to get authenticated user from context")
+		return &userpb.ResendVerificationEmailResponse{
+			Message:    "Failed to get authenticated user from context",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("Received ResendVerificationEmail request", zap.String("userEmail", principal.Email))
+	var user model.User
+	if err := loadPrincipalUser(principal, &user); err != nil {
+		logger.Warn("User not found", zap.String("userEmail", principal.Email), zap.Error(err))
+		return &userpb.ResendVerificationEmailResponse{
+			Message:    "User not found",
+			Error:      "Not Found",
+			StatusCode: StatusNotFound,
+		}, nil
+	}
+	if !user.EmailVerificationPending {
+		return &userpb.ResendVerificationEmailResponse{
+			Message:    "Email is already verified",
+			Error:      "Conflict",
+			StatusCode: StatusConflict,
+		}, nil
+	}
+	if err := userServiceManager.sendVerificationEmail(ctx, &user); err != nil {
+		logger.Error("Failed to send verification email", zap.String("userEmail", user.Email), zap.Error(err))
+		return &userpb.ResendVerificationEmailResponse{
+			Message:    "Failed to send verification email, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	return &userpb.ResendVerificationEmailResponse{
+		Message:    "Verification email sent",
+		Error:      "",
+		StatusCode: StatusOK,
+	}, nil
+}
+
+// newMailer picks the mailer configured through the MAILER environment
+// variable. The emails carry single-use links, so the log and file mailers
+// are only allowed in development.
+func newMailer() (mailer.Mailer, error) {
+	name := getEnv("MAILER", "log")
+	if (name == "log" || name == "file") && !developmentMode() {
+		return nil, fmt.Errorf("MAILER %q is only allowed with APP_ENV=development, set MAILER=smtp", name)
+	}
+	switch name {
+	case "smtp":
+		return mailer.NewSMTPMailer(os.Getenv("SMTP_HOST"), getEnv("SMTP_PORT", "587"),
+			os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_FROM")), nil
+	case "file":
+		return mailer.NewFileMailer(getEnv("MAIL_DIR", "mail"))
+	case "log":
+		return mailer.NewLogMailer(logger), nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q", name)
+	}
+}
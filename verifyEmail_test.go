@@ -0,0 +1,27 @@
+package main
+
+import (
+	"auth-microservice/mailer"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMailerNeedsRealMailerOutsideDevelopment(t *testing.T) {
+	t.Setenv("APP_ENV", "")
+	t.Setenv("MAILER", "")
+	_, err := newMailer()
+	assert.NotNil(t, err)
+	t.Setenv("MAILER", "file")
+	_, err = newMailer()
+	assert.NotNil(t, err)
+	t.Setenv("MAILER", "smtp")
+	_, err = newMailer()
+	assert.Nil(t, err)
+
+	t.Setenv("APP_ENV", "development")
+	t.Setenv("MAILER", "")
+	userMailer, err := newMailer()
+	assert.Nil(t, err)
+	assert.IsType(t, &mailer.LogMailer{}, userMailer)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"auth-microservice/jwt"
+	"auth-microservice/model"
+	userpb "auth-microservice/proto/user"
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// mfaChallengeDuration is how long a user has to send the second factor after
+// the password was checked
+const mfaChallengeDuration = 5 * time.Minute
+
+// mfaThrottleKey counts wrong second factors of a user, like wrong passwords
+// are counted per account.
+func mfaThrottleKey(userID uint) string {
+	return fmt.Sprintf("mfa:%d", userID)
+}
+
+func mfaThrottledMessage(wait time.Duration, locked bool) string {
+	if locked {
+		return fmt.Sprintf("Too many wrong codes, two-factor authentication is locked. Please try again in %d minutes.",
+			int(math.Ceil(wait.Minutes())))
+	}
+	return fmt.Sprintf("Too many wrong codes. Please try again in %d seconds.", int(math.Ceil(wait.Seconds())))
+}
+
+// issueMfaChallenge returns the token a user with two-factor authentication
+// gets instead of an access token, VerifyMfa trades it for one.
+func (userServiceManager *UserService) issueMfaChallenge(user *model.User) (string, error) {
+	return userServiceManager.jwtManager.GenerateActionToken(user, jwt.PurposeMfaChallenge, mfaChallengeDuration)
+}
+
+func (userServiceManager *UserService) VerifyMfa(ctx context.Context, request *userpb.VerifyMfaRequest) (*userpb.VerifyMfaResponse, error) {
+	logger.Info("Received VerifyMfa request")
+	if request.MfaToken == "" || request.Code == "" {
+		logger.Warn("Invalid request fields")
+		return &userpb.VerifyMfaResponse{
+			Data:       nil,
+			Message:    "The request contains missing or invalid fields.",
+			Error:      "Invalid Request",
+			StatusCode: StatusBadRequest,
+		}, nil
+	}
+	// the token is only consumed once the code is right, so a typo does not
+	// force the user to enter the password again
+	claims, err := userServiceManager.jwtManager.VerifyActionToken(request.MfaToken, jwt.PurposeMfaChallenge)
+	if err != nil {
+		logger.Warn("Invalid mfa token", zap.Error(err))
+		return &userpb.VerifyMfaResponse{
+			Data:       nil,
+			Message:    "The login expired, Please login again.",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	userEmail := claims.UserEmail
+	var user model.User
+	if err := userDbConnector.First(&user, claims.UserID()).Error; err != nil || !user.TotpEnabled {
+		logger.Warn("User for mfa token not found or without two-factor authentication", zap.String("userEmail", userEmail))
+		return &userpb.VerifyMfaResponse{
+			Data:       nil,
+			Message:    "The login expired, Please login again.",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	throttleKey := mfaThrottleKey(user.ID)
+	wait, locked, err := userServiceManager.loginThrottle.check(throttleKey)
+	if err != nil {
+		logger.Error("Failed to check mfa throttle", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.VerifyMfaResponse{
+			Data:       nil,
+			Message:    "Security Issues, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if wait > 0 {
+		logger.Warn("Mfa throttled", zap.String("userEmail", userEmail), zap.Duration("wait", wait), zap.Bool("locked", locked))
+		return &userpb.VerifyMfaResponse{
+			Data:       nil,
+			Message:    mfaThrottledMessage(wait, locked),
+			Error:      "Too Many Requests",
+			StatusCode: StatusTooManyRequests,
+		}, nil
+	}
+	accepted, err := userServiceManager.checkSecondFactor(&user, request.Code)
+	if err != nil {
+		logger.Error("Failed to check second factor", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.VerifyMfaResponse{
+			Data:       nil,
+			Message:    "Security Issues, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	if !accepted {
+		userServiceManager.loginThrottle.recordFailures(throttleKey)
+		logger.Warn("Wrong second factor", zap.String("userEmail", userEmail))
+		return &userpb.VerifyMfaResponse{
+			Data:       nil,
+			Message:    "The code is invalid",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	if _, err := userServiceManager.jwtManager.ConsumeActionToken(request.MfaToken, jwt.PurposeMfaChallenge); err != nil {
+		logger.Warn("Mfa token was already used", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.VerifyMfaResponse{
+			Data:       nil,
+			Message:    "The login expired, Please login again.",
+			Error:      "Unauthorized",
+			StatusCode: StatusUnauthorized,
+		}, nil
+	}
+	if err := userServiceManager.loginThrottle.reset(throttleKey); err != nil {
+		logger.Error("Failed to reset mfa throttle", zap.String("userEmail", userEmail), zap.Error(err))
+	}
+	data, err := userServiceManager.issueTokens(ctx, &user, "")
+	if err != nil {
+		logger.Error("Error in generating token", zap.String("userEmail", userEmail), zap.Error(err))
+		return &userpb.VerifyMfaResponse{
+			Data:       nil,
+			Message:    "Security Issues, Please try again later.",
+			Error:      "Internal Server Error",
+			StatusCode: StatusInternalServerError,
+		}, nil
+	}
+	logger.Info("User authenticated successfully with two factors", zap.String("userEmail", userEmail))
+	return &userpb.VerifyMfaResponse{
+		Data:       data,
+		Message:    "User authenticated successfully",
+		Error:      "",
+		StatusCode: StatusCreated,
+	}, nil
+}